@@ -10,6 +10,19 @@ import (
 
 type Program struct {
 	Statements []Statement
+	// Comments holds the comments of the source in order.
+	Comments []*Comment
+}
+
+// Comment is a `// ...` line comment. Comments are not part of the tree,
+// they are only kept for tools printing the source back.
+type Comment struct {
+	Token token.Token
+}
+
+// Text returns the comment including the leading `//`.
+func (c *Comment) Text() string {
+	return c.Token.Literal
 }
 
 func (p *Program) TokenLiteral() string {
@@ -64,6 +77,27 @@ func (ls *LetStatement) String() string {
 	return out.String()
 }
 
+// ExportStatement is `export let ...`, which makes the names the let binds
+// part of the value importing the module evaluates to.
+type ExportStatement struct {
+	statement
+	Token     token.Token
+	Statement *LetStatement
+}
+
+func (es *ExportStatement) TokenLiteral() string {
+	return es.Token.Literal
+}
+
+func (es *ExportStatement) String() string {
+	return es.TokenLiteral() + " " + es.Statement.String()
+}
+
+// Names returns the identifiers the exported let binds.
+func (es *ExportStatement) Names() []*Identifier {
+	return BoundIdentifiers(es.Statement.Pattern)
+}
+
 type ReturnStatement struct {
 	statement
 	Token       token.Token
@@ -89,6 +123,8 @@ type BlockStatement struct {
 	statement
 	Token      token.Token
 	Statements []Statement
+	// Rbrace is the position of the closing brace.
+	Rbrace token.Position
 }
 
 func (ie *BlockStatement) TokenLiteral() string {
@@ -168,6 +204,58 @@ func (pe *InfixExpression) String() string {
 	return out.String()
 }
 
+// IsPlaceholder reports whether e is the placeholder `_` marking where a
+// pipeline passes its value to a call.
+func IsPlaceholder(e Expression) bool {
+	ident, ok := e.(*Identifier)
+	return ok && ident.Value == "_"
+}
+
+// PipelineCall returns the call `left | f(args)` or `left | r.m(args)`
+// stands for, in which left replaces the placeholder in args or, without
+// one, comes before args. It reports false unless pe is a pipeline into a
+// call.
+func (pe *InfixExpression) PipelineCall() (Expression, bool) {
+	if pe.Operator != "|" {
+		return nil, false
+	}
+	switch call := pe.Right.(type) {
+	case *CallExpression:
+		return &CallExpression{
+			Token:     call.Token,
+			Function:  call.Function,
+			Arguments: pipelineArguments(pe.Left, call.Arguments),
+			Optional:  call.Optional,
+		}, true
+	case *MethodCallExpression:
+		return &MethodCallExpression{
+			Token:     call.Token,
+			Receiver:  call.Receiver,
+			Method:    call.Method,
+			Arguments: pipelineArguments(pe.Left, call.Arguments),
+			Optional:  call.Optional,
+		}, true
+	}
+	return nil, false
+}
+
+func pipelineArguments(left Expression, arguments []Expression) []Expression {
+	args := []Expression{}
+	placed := false
+	for _, a := range arguments {
+		if IsPlaceholder(a) {
+			args = append(args, left)
+			placed = true
+		} else {
+			args = append(args, a)
+		}
+	}
+	if !placed {
+		args = append([]Expression{left}, args...)
+	}
+	return args
+}
+
 type IfExpression struct {
 	expression
 	Token       token.Token
@@ -201,6 +289,8 @@ type CallExpression struct {
 	Token     token.Token
 	Function  Expression
 	Arguments []Expression
+	// Optional is set for `f?.()`, which evaluates to null when f is null.
+	Optional bool
 }
 
 func (ce *CallExpression) TokenLiteral() string {
@@ -216,6 +306,9 @@ func (ce *CallExpression) String() string {
 	}
 
 	out.WriteString(ce.Function.String())
+	if ce.Optional {
+		out.WriteString("?.")
+	}
 	out.WriteRune('(')
 	out.WriteString(strings.Join(args, ", "))
 	out.WriteRune(')')
@@ -228,6 +321,8 @@ type IndexExpression struct {
 	Token token.Token
 	Left  Expression
 	Index Expression
+	// Optional is set for `a?.[i]`, which evaluates to null when a is null.
+	Optional bool
 }
 
 func (ie *IndexExpression) TokenLiteral() string {
@@ -239,12 +334,52 @@ func (ie *IndexExpression) String() string {
 
 	out.WriteRune('(')
 	out.WriteString(ie.Left.String())
+	if ie.Optional {
+		out.WriteString("?.")
+	}
 	out.WriteRune('[')
 	out.WriteString(ie.Index.String())
 	out.WriteString("])")
 	return out.String()
 }
 
+// MethodCallExpression is `receiver.Method(args)`.
+type MethodCallExpression struct {
+	expression
+	Token     token.Token
+	Receiver  Expression
+	Method    *Identifier
+	Arguments []Expression
+	// Optional is set for `r?.m()`, which evaluates to null when r is null.
+	Optional bool
+}
+
+func (mc *MethodCallExpression) TokenLiteral() string {
+	return mc.Token.Literal
+}
+
+func (mc *MethodCallExpression) String() string {
+	var out bytes.Buffer
+
+	args := []string{}
+	for _, a := range mc.Arguments {
+		args = append(args, a.String())
+	}
+
+	out.WriteString(mc.Receiver.String())
+	if mc.Optional {
+		out.WriteString("?.")
+	} else {
+		out.WriteRune('.')
+	}
+	out.WriteString(mc.Method.String())
+	out.WriteRune('(')
+	out.WriteString(strings.Join(args, ", "))
+	out.WriteRune(')')
+
+	return out.String()
+}
+
 type Identifier struct {
 	expression
 	pattern
@@ -262,6 +397,7 @@ func (i *Identifier) String() string {
 
 type NumberLiteral struct {
 	expression
+	pattern
 	Token token.Token
 	Value string
 }
@@ -276,6 +412,7 @@ func (i *NumberLiteral) String() string {
 
 type BooleanLiteral struct {
 	expression
+	pattern
 	Token token.Token
 	Value bool
 }
@@ -288,8 +425,23 @@ func (b *BooleanLiteral) String() string {
 	return b.Token.Literal
 }
 
+type NullLiteral struct {
+	expression
+	pattern
+	Token token.Token
+}
+
+func (n *NullLiteral) TokenLiteral() string {
+	return n.Token.Literal
+}
+
+func (n *NullLiteral) String() string {
+	return n.Token.Literal
+}
+
 type StringLiteral struct {
 	expression
+	pattern
 	Token token.Token
 	Value string
 }
@@ -302,6 +454,22 @@ func (b *StringLiteral) String() string {
 	return b.Token.Literal
 }
 
+// SpreadExpression is `...value` in an argument list or array literal,
+// which expands to the elements of the array value.
+type SpreadExpression struct {
+	expression
+	Token token.Token
+	Value Expression
+}
+
+func (se *SpreadExpression) TokenLiteral() string {
+	return se.Token.Literal
+}
+
+func (se *SpreadExpression) String() string {
+	return "..." + se.Value.String()
+}
+
 type ArrayLiteral struct {
 	expression
 	Token    token.Token
@@ -327,10 +495,15 @@ func (al *ArrayLiteral) String() string {
 	return out.String()
 }
 
+type HashPair struct {
+	Key   Expression
+	Value Expression
+}
+
 type HashLiteral struct {
 	expression
 	Token token.Token
-	Pairs map[Expression]Expression
+	Pairs []HashPair
 }
 
 func (hl *HashLiteral) TokenLiteral() string {
@@ -341,8 +514,8 @@ func (hl *HashLiteral) String() string {
 	var out bytes.Buffer
 
 	pairs := []string{}
-	for k, v := range hl.Pairs {
-		pairs = append(pairs, k.String()+": "+v.String())
+	for _, p := range hl.Pairs {
+		pairs = append(pairs, p.Key.String()+": "+p.Value.String())
 	}
 
 	out.WriteRune('{')
@@ -356,8 +529,21 @@ type FunctionLiteral struct {
 	expression
 	Token      token.Token
 	Parameters []Pattern
-	Body       *BlockStatement
-	Name       string
+	// Defaults holds the default value of each parameter, or nil for a
+	// parameter without one. It is nil when no parameter has a default.
+	Defaults []Expression
+	// Rest is the `...rest` parameter collecting the remaining arguments.
+	Rest *Identifier
+	Body *BlockStatement
+	Name string
+}
+
+// Default returns the default value of the i-th parameter, or nil.
+func (fl *FunctionLiteral) Default(i int) Expression {
+	if i < len(fl.Defaults) {
+		return fl.Defaults[i]
+	}
+	return nil
 }
 
 func (fl *FunctionLiteral) TokenLiteral() string {
@@ -368,8 +554,15 @@ func (fl *FunctionLiteral) String() string {
 	var out bytes.Buffer
 
 	params := []string{}
-	for _, p := range fl.Parameters {
-		params = append(params, p.String())
+	for i, p := range fl.Parameters {
+		if d := fl.Default(i); d != nil {
+			params = append(params, p.String()+" = "+d.String())
+		} else {
+			params = append(params, p.String())
+		}
+	}
+	if fl.Rest != nil {
+		params = append(params, "..."+fl.Rest.String())
 	}
 	out.WriteString(fl.TokenLiteral())
 	if fl.Name != "" {
@@ -382,6 +575,48 @@ func (fl *FunctionLiteral) String() string {
 	return out.String()
 }
 
+// ImportExpression is `import "path"`, which evaluates to a hash of the
+// names the module at path exports.
+type ImportExpression struct {
+	expression
+	Token token.Token
+	Path  *StringLiteral
+}
+
+func (ie *ImportExpression) TokenLiteral() string {
+	return ie.Token.Literal
+}
+
+func (ie *ImportExpression) String() string {
+	return ie.TokenLiteral() + " \"" + ie.Path.Value + "\""
+}
+
+type MacroLiteral struct {
+	expression
+	Token      token.Token
+	Parameters []*Identifier
+	Body       *BlockStatement
+}
+
+func (ml *MacroLiteral) TokenLiteral() string {
+	return ml.Token.Literal
+}
+
+func (ml *MacroLiteral) String() string {
+	var out bytes.Buffer
+
+	params := []string{}
+	for _, p := range ml.Parameters {
+		params = append(params, p.String())
+	}
+	out.WriteString(ml.TokenLiteral())
+	out.WriteRune('(')
+	out.WriteString(strings.Join(params, ", "))
+	out.WriteString(") ")
+	out.WriteString(ml.Body.String())
+	return out.String()
+}
+
 type Node interface {
 	TokenLiteral() string
 	String() string
@@ -431,6 +666,17 @@ type HashPattern struct {
 	pattern
 	Token   token.Token
 	Pattern []*Identifier
+	// Values holds the sub-pattern written as `key: pattern` for each key.
+	// A nil entry binds the value to the key's name.
+	Values []Pattern
+}
+
+// ValuePattern returns the pattern the value of the i-th key is matched against.
+func (hp *HashPattern) ValuePattern(i int) Pattern {
+	if i < len(hp.Values) && hp.Values[i] != nil {
+		return hp.Values[i]
+	}
+	return hp.Pattern[i]
 }
 
 func (hp *HashPattern) TokenLiteral() string {
@@ -441,8 +687,12 @@ func (hp *HashPattern) String() string {
 	var out bytes.Buffer
 
 	patterns := []string{}
-	for _, p := range hp.Pattern {
-		patterns = append(patterns, p.String())
+	for i, p := range hp.Pattern {
+		if i < len(hp.Values) && hp.Values[i] != nil {
+			patterns = append(patterns, p.String()+": "+hp.Values[i].String())
+		} else {
+			patterns = append(patterns, p.String())
+		}
 	}
 
 	out.WriteRune('{')
@@ -451,3 +701,90 @@ func (hp *HashPattern) String() string {
 
 	return out.String()
 }
+
+// BoundIdentifiers returns the identifiers p binds, in order.
+func BoundIdentifiers(p Pattern) []*Identifier {
+	switch p := p.(type) {
+	case *Identifier:
+		return []*Identifier{p}
+	case *ArrayPattern:
+		identifiers := []*Identifier{}
+		for _, e := range p.Pattern {
+			identifiers = append(identifiers, BoundIdentifiers(e)...)
+		}
+		return identifiers
+	case *HashPattern:
+		identifiers := []*Identifier{}
+		for i := range p.Pattern {
+			identifiers = append(identifiers, BoundIdentifiers(p.ValuePattern(i))...)
+		}
+		return identifiers
+	}
+	return nil
+}
+
+type WildcardPattern struct {
+	pattern
+	Token token.Token
+}
+
+func (wp *WildcardPattern) TokenLiteral() string {
+	return wp.Token.Literal
+}
+
+func (wp *WildcardPattern) String() string {
+	return "_"
+}
+
+type MatchArm struct {
+	Token   token.Token
+	Pattern Pattern
+	Guard   Expression
+	Body    Expression
+}
+
+func (ma *MatchArm) TokenLiteral() string {
+	return ma.Token.Literal
+}
+
+func (ma *MatchArm) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(ma.Pattern.String())
+	if ma.Guard != nil {
+		out.WriteString(" if ")
+		out.WriteString(ma.Guard.String())
+	}
+	out.WriteString(" => ")
+	out.WriteString(ma.Body.String())
+
+	return out.String()
+}
+
+type MatchExpression struct {
+	expression
+	Token token.Token
+	Value Expression
+	Arms  []*MatchArm
+}
+
+func (me *MatchExpression) TokenLiteral() string {
+	return me.Token.Literal
+}
+
+func (me *MatchExpression) String() string {
+	var out bytes.Buffer
+
+	arms := []string{}
+	for _, a := range me.Arms {
+		arms = append(arms, a.String())
+	}
+
+	out.WriteString("match (")
+	out.WriteString(me.Value.String())
+	out.WriteString(") { ")
+	out.WriteString(strings.Join(arms, ", "))
+	out.WriteString(" }")
+
+	return out.String()
+}
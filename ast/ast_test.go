@@ -1,6 +1,7 @@
 package ast
 
 import (
+	"reflect"
 	"testing"
 
 	"github.com/wreulicke/monkey/token"
@@ -30,3 +31,203 @@ func TestString(t *testing.T) {
 		t.Errorf("program.String() wrong. got=%q", program.String())
 	}
 }
+
+func TestModify(t *testing.T) {
+	one := func() Expression { return &NumberLiteral{Value: "1"} }
+	two := func() Expression { return &NumberLiteral{Value: "2"} }
+
+	turnOneIntoTwo := func(node Node) Node {
+		number, ok := node.(*NumberLiteral)
+		if !ok || number.Value != "1" {
+			return node
+		}
+		return two()
+	}
+
+	tests := []struct {
+		input    Node
+		expected Node
+	}{
+		{one(), two()},
+		{
+			&Program{Statements: []Statement{&ExpressionStatement{Expression: one()}}},
+			&Program{Statements: []Statement{&ExpressionStatement{Expression: two()}}},
+		},
+		{
+			&InfixExpression{Left: one(), Operator: "+", Right: two()},
+			&InfixExpression{Left: two(), Operator: "+", Right: two()},
+		},
+		{
+			&PrefixExpression{Operator: "-", Right: one()},
+			&PrefixExpression{Operator: "-", Right: two()},
+		},
+		{
+			&IndexExpression{Left: one(), Index: one()},
+			&IndexExpression{Left: two(), Index: two()},
+		},
+		{
+			&IfExpression{
+				Condition:   one(),
+				Consequence: &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: one()}}},
+				Alternative: &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: one()}}},
+			},
+			&IfExpression{
+				Condition:   two(),
+				Consequence: &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: two()}}},
+				Alternative: &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: two()}}},
+			},
+		},
+		{
+			&ReturnStatement{ReturnValue: one()},
+			&ReturnStatement{ReturnValue: two()},
+		},
+		{
+			&LetStatement{Value: one()},
+			&LetStatement{Value: two()},
+		},
+		{
+			&FunctionLiteral{
+				Parameters: []Pattern{},
+				Defaults:   []Expression{one()},
+				Body:       &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: one()}}},
+			},
+			&FunctionLiteral{
+				Parameters: []Pattern{},
+				Defaults:   []Expression{two()},
+				Body:       &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: two()}}},
+			},
+		},
+		{
+			&ArrayLiteral{Elements: []Expression{one(), &SpreadExpression{Value: one()}}},
+			&ArrayLiteral{Elements: []Expression{two(), &SpreadExpression{Value: two()}}},
+		},
+		{
+			&CallExpression{Function: one(), Arguments: []Expression{one()}},
+			&CallExpression{Function: two(), Arguments: []Expression{two()}},
+		},
+		{
+			&HashLiteral{Pairs: []HashPair{{Key: one(), Value: one()}}},
+			&HashLiteral{Pairs: []HashPair{{Key: two(), Value: two()}}},
+		},
+		{
+			&MatchExpression{Value: one(), Arms: []*MatchArm{{Pattern: &WildcardPattern{}, Guard: one(), Body: one()}}},
+			&MatchExpression{Value: two(), Arms: []*MatchArm{{Pattern: &WildcardPattern{}, Guard: two(), Body: two()}}},
+		},
+	}
+
+	for _, tt := range tests {
+		modified := Modify(tt.input, turnOneIntoTwo)
+		if !reflect.DeepEqual(modified, tt.expected) {
+			t.Errorf("not equal. got=%#v, want=%#v", modified, tt.expected)
+		}
+	}
+}
+
+func TestModifyPatterns(t *testing.T) {
+	ident := func(name string) *Identifier { return &Identifier{Value: name} }
+
+	renameAToB := func(node Node) Node {
+		id, ok := node.(*Identifier)
+		if !ok || id.Value != "a" {
+			return node
+		}
+		return ident("b")
+	}
+
+	tests := []struct {
+		input    Node
+		expected Node
+	}{
+		{
+			&LetStatement{Pattern: ident("a"), Value: ident("a")},
+			&LetStatement{Pattern: ident("b"), Value: ident("b")},
+		},
+		{
+			&ArrayPattern{Pattern: []Pattern{ident("a"), &ArrayPattern{Pattern: []Pattern{ident("a")}}}},
+			&ArrayPattern{Pattern: []Pattern{ident("b"), &ArrayPattern{Pattern: []Pattern{ident("b")}}}},
+		},
+		{
+			&HashPattern{Pattern: []*Identifier{ident("a"), ident("c")}, Values: []Pattern{nil, ident("a")}},
+			&HashPattern{Pattern: []*Identifier{ident("b"), ident("c")}, Values: []Pattern{nil, ident("b")}},
+		},
+		{
+			&FunctionLiteral{
+				Parameters: []Pattern{ident("a")},
+				Rest:       ident("a"),
+				Body:       &BlockStatement{Statements: []Statement{}},
+			},
+			&FunctionLiteral{
+				Parameters: []Pattern{ident("b")},
+				Rest:       ident("b"),
+				Body:       &BlockStatement{Statements: []Statement{}},
+			},
+		},
+		{
+			&MatchArm{Pattern: ident("a"), Body: ident("a")},
+			&MatchArm{Pattern: ident("b"), Body: ident("b")},
+		},
+		{
+			&MethodCallExpression{Receiver: ident("a"), Method: ident("a"), Arguments: []Expression{ident("a")}},
+			&MethodCallExpression{Receiver: ident("b"), Method: ident("b"), Arguments: []Expression{ident("b")}},
+		},
+	}
+
+	for _, tt := range tests {
+		modified := Modify(tt.input, renameAToB)
+		if !reflect.DeepEqual(modified, tt.expected) {
+			t.Errorf("not equal. got=%s, want=%s", modified, tt.expected)
+		}
+	}
+}
+
+func TestInspect(t *testing.T) {
+	ident := func(name string) *Identifier { return &Identifier{Value: name} }
+
+	// let [a, {b, c: d}] = fn(e, f = g, ...h) { i.j(k) }(l ?? m);
+	program := &Program{Statements: []Statement{
+		&LetStatement{
+			Pattern: &ArrayPattern{Pattern: []Pattern{
+				ident("a"),
+				&HashPattern{Pattern: []*Identifier{ident("b"), ident("c")}, Values: []Pattern{nil, ident("d")}},
+			}},
+			Value: &CallExpression{
+				Function: &FunctionLiteral{
+					Parameters: []Pattern{ident("e"), ident("f")},
+					Defaults:   []Expression{nil, ident("g")},
+					Rest:       ident("h"),
+					Body: &BlockStatement{Statements: []Statement{
+						&ExpressionStatement{Expression: &MethodCallExpression{
+							Receiver: ident("i"), Method: ident("j"), Arguments: []Expression{ident("k")},
+						}},
+					}},
+				},
+				Arguments: []Expression{&InfixExpression{Left: ident("l"), Operator: "??", Right: ident("m")}},
+			},
+		},
+	}}
+
+	var names []string
+	Inspect(program, func(node Node) bool {
+		if id, ok := node.(*Identifier); ok {
+			names = append(names, id.Value)
+		}
+		return true
+	})
+	expected := []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l", "m"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("wrong identifiers. got=%v, want=%v", names, expected)
+	}
+
+	var visited int
+	Inspect(program, func(node Node) bool {
+		if node != nil {
+			visited++
+		}
+		_, isFunction := node.(*FunctionLiteral)
+		return !isFunction
+	})
+	// Program, Let, ArrayPattern, a, HashPattern, b, c, d, Call, FunctionLiteral, Infix, l, m
+	if visited != 13 {
+		t.Errorf("wrong number of visited nodes. got=%d, want=13", visited)
+	}
+}
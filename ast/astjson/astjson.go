@@ -0,0 +1,263 @@
+// Package astjson converts syntax trees to and from a typed JSON tree.
+//
+// Every node is a JSON object whose "type" member names the node, e.g.
+// "InfixExpression", followed by "pos" with the line and column of the
+// node's token when it is known, and then the node's children. A program
+// also lists its comments. Decoding
+// rebuilds the tokens from the node itself, so JSON written by hand or by
+// other tools does not need to carry them.
+package astjson
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/wreulicke/monkey/ast"
+	"github.com/wreulicke/monkey/token"
+)
+
+// Marshal returns the JSON encoding of node.
+func Marshal(node ast.Node) ([]byte, error) {
+	return marshal(encode(node))
+}
+
+// MarshalIndent is like Marshal but indents the output like json.MarshalIndent.
+func MarshalIndent(node ast.Node, prefix, indent string) ([]byte, error) {
+	data, err := Marshal(node)
+	if err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, data, prefix, indent); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// marshal is json.Marshal without escaping operators like < and >.
+func marshal(v interface{}) ([]byte, error) {
+	var out bytes.Buffer
+	enc := json.NewEncoder(&out)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(out.Bytes(), []byte{'\n'}), nil
+}
+
+type field struct {
+	key   string
+	value interface{}
+}
+
+// object is a JSON object that keeps its members in insertion order, so
+// "type" and "pos" come first.
+type object []field
+
+func (o object) MarshalJSON() ([]byte, error) {
+	var out bytes.Buffer
+	out.WriteRune('{')
+	for i, f := range o {
+		if i > 0 {
+			out.WriteRune(',')
+		}
+		key, err := marshal(f.key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := marshal(f.value)
+		if err != nil {
+			return nil, err
+		}
+		out.Write(key)
+		out.WriteRune(':')
+		out.Write(value)
+	}
+	out.WriteRune('}')
+	return out.Bytes(), nil
+}
+
+type position struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+func newObject(kind string, tok token.Token) object {
+	o := object{{"type", kind}}
+	if tok.Pos.IsValid() {
+		o = append(o, field{"pos", position{tok.Pos.Line, tok.Pos.Column}})
+	}
+	return o
+}
+
+func (o object) with(key string, value interface{}) object {
+	return append(o, field{key, value})
+}
+
+func encode(node ast.Node) interface{} {
+	switch node := node.(type) {
+	case *ast.Program:
+		o := object{{"type", "Program"}}.with("statements", encodeStatements(node.Statements))
+		if len(node.Comments) > 0 {
+			comments := []interface{}{}
+			for _, c := range node.Comments {
+				comments = append(comments, newObject("Comment", c.Token).with("text", c.Text()))
+			}
+			o = o.with("comments", comments)
+		}
+		return o
+	case *ast.LetStatement:
+		return newObject("LetStatement", node.Token).
+			with("pattern", encode(node.Pattern)).
+			with("value", encode(node.Value))
+	case *ast.ExportStatement:
+		return newObject("ExportStatement", node.Token).
+			with("statement", encode(node.Statement))
+	case *ast.ReturnStatement:
+		return newObject("ReturnStatement", node.Token).
+			with("value", encode(node.ReturnValue))
+	case *ast.ExpressionStatement:
+		return newObject("ExpressionStatement", node.Token).
+			with("expression", encode(node.Expression))
+	case *ast.BlockStatement:
+		o := newObject("BlockStatement", node.Token).
+			with("statements", encodeStatements(node.Statements))
+		if node.Rbrace.IsValid() {
+			o = o.with("end", position{node.Rbrace.Line, node.Rbrace.Column})
+		}
+		return o
+	case *ast.PrefixExpression:
+		return newObject("PrefixExpression", node.Token).
+			with("operator", node.Operator).
+			with("right", encode(node.Right))
+	case *ast.InfixExpression:
+		return newObject("InfixExpression", node.Token).
+			with("operator", node.Operator).
+			with("left", encode(node.Left)).
+			with("right", encode(node.Right))
+	case *ast.IfExpression:
+		o := newObject("IfExpression", node.Token).
+			with("condition", encode(node.Condition)).
+			with("consequence", encode(node.Consequence))
+		if node.Alternative != nil {
+			o = o.with("alternative", encode(node.Alternative))
+		}
+		return o
+	case *ast.MatchExpression:
+		arms := []interface{}{}
+		for _, arm := range node.Arms {
+			arms = append(arms, encode(arm))
+		}
+		return newObject("MatchExpression", node.Token).
+			with("value", encode(node.Value)).
+			with("arms", arms)
+	case *ast.MatchArm:
+		o := newObject("MatchArm", node.Token).
+			with("pattern", encode(node.Pattern))
+		if node.Guard != nil {
+			o = o.with("guard", encode(node.Guard))
+		}
+		return o.with("body", encode(node.Body))
+	case *ast.CallExpression:
+		return newObject("CallExpression", node.Token).
+			with("function", encode(node.Function)).
+			with("arguments", encodeExpressions(node.Arguments)).
+			with("optional", node.Optional)
+	case *ast.IndexExpression:
+		return newObject("IndexExpression", node.Token).
+			with("left", encode(node.Left)).
+			with("index", encode(node.Index)).
+			with("optional", node.Optional)
+	case *ast.MethodCallExpression:
+		return newObject("MethodCallExpression", node.Token).
+			with("receiver", encode(node.Receiver)).
+			with("method", encode(node.Method)).
+			with("arguments", encodeExpressions(node.Arguments)).
+			with("optional", node.Optional)
+	case *ast.SpreadExpression:
+		return newObject("SpreadExpression", node.Token).
+			with("value", encode(node.Value))
+	case *ast.Identifier:
+		return newObject("Identifier", node.Token).with("value", node.Value)
+	case *ast.NumberLiteral:
+		return newObject("NumberLiteral", node.Token).with("value", node.Value)
+	case *ast.StringLiteral:
+		return newObject("StringLiteral", node.Token).with("value", node.Value)
+	case *ast.BooleanLiteral:
+		return newObject("BooleanLiteral", node.Token).with("value", node.Value)
+	case *ast.NullLiteral:
+		return newObject("NullLiteral", node.Token)
+	case *ast.ArrayLiteral:
+		return newObject("ArrayLiteral", node.Token).
+			with("elements", encodeExpressions(node.Elements))
+	case *ast.HashLiteral:
+		pairs := []interface{}{}
+		for _, pair := range node.Pairs {
+			pairs = append(pairs, object{{"key", encode(pair.Key)}, {"value", encode(pair.Value)}})
+		}
+		return newObject("HashLiteral", node.Token).with("pairs", pairs)
+	case *ast.ImportExpression:
+		return newObject("ImportExpression", node.Token).
+			with("path", encode(node.Path))
+	case *ast.FunctionLiteral:
+		params := []interface{}{}
+		for i, p := range node.Parameters {
+			param := object{{"pattern", encode(p)}}
+			if d := node.Default(i); d != nil {
+				param = param.with("default", encode(d))
+			}
+			params = append(params, param)
+		}
+		o := newObject("FunctionLiteral", node.Token).with("parameters", params)
+		if node.Rest != nil {
+			o = o.with("rest", encode(node.Rest))
+		}
+		if node.Name != "" {
+			o = o.with("name", node.Name)
+		}
+		return o.with("body", encode(node.Body))
+	case *ast.MacroLiteral:
+		params := []interface{}{}
+		for _, p := range node.Parameters {
+			params = append(params, encode(p))
+		}
+		return newObject("MacroLiteral", node.Token).
+			with("parameters", params).
+			with("body", encode(node.Body))
+	case *ast.ArrayPattern:
+		elements := []interface{}{}
+		for _, p := range node.Pattern {
+			elements = append(elements, encode(p))
+		}
+		return newObject("ArrayPattern", node.Token).with("elements", elements)
+	case *ast.HashPattern:
+		keys := []interface{}{}
+		for i, key := range node.Pattern {
+			k := object{{"key", encode(key)}}
+			if i < len(node.Values) && node.Values[i] != nil {
+				k = k.with("value", encode(node.Values[i]))
+			}
+			keys = append(keys, k)
+		}
+		return newObject("HashPattern", node.Token).with("keys", keys)
+	case *ast.WildcardPattern:
+		return newObject("WildcardPattern", node.Token)
+	}
+	return nil
+}
+
+func encodeStatements(statements []ast.Statement) []interface{} {
+	result := []interface{}{}
+	for _, s := range statements {
+		result = append(result, encode(s))
+	}
+	return result
+}
+
+func encodeExpressions(expressions []ast.Expression) []interface{} {
+	result := []interface{}{}
+	for _, e := range expressions {
+		result = append(result, encode(e))
+	}
+	return result
+}
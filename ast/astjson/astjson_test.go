@@ -0,0 +1,144 @@
+package astjson
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/wreulicke/monkey/ast"
+	"github.com/wreulicke/monkey/lexer"
+	"github.com/wreulicke/monkey/parser"
+)
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+	p := parser.New(lexer.New(bytes.NewBufferString(input)))
+	program := p.Parse()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser has errors: %v", p.Errors())
+	}
+	return program
+}
+
+func TestMarshal(t *testing.T) {
+	program := parse(t, "let x = -a + 1;")
+	expected := `{"type":"Program","statements":[` +
+		`{"type":"LetStatement","pos":{"line":1,"column":1},` +
+		`"pattern":{"type":"Identifier","pos":{"line":1,"column":5},"value":"x"},` +
+		`"value":{"type":"InfixExpression","pos":{"line":1,"column":12},"operator":"+",` +
+		`"left":{"type":"PrefixExpression","pos":{"line":1,"column":9},"operator":"-",` +
+		`"right":{"type":"Identifier","pos":{"line":1,"column":10},"value":"a"}},` +
+		`"right":{"type":"NumberLiteral","pos":{"line":1,"column":14},"value":"1"}}}]}`
+
+	data, err := Marshal(program)
+	if err != nil {
+		t.Fatalf("Marshal failed: %s", err)
+	}
+	if string(data) != expected {
+		t.Errorf("wrong json.\nwant=%s\ngot =%s", expected, data)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	tests := []string{
+		`let x = 1 * (2 + 3);`,
+		`let f = fn(a, b = 2, ...rest) { return a + b; };`,
+		`let [a, {b, c: [d, _]}] = [1, {"b": 2, "c": [3, 4]}];`,
+		`if (a < b) { a } else { !b }`,
+		`match (x) { 1 => "one", -1 => "minus one", [a, _] if a > 0 => a, {k: null} => true, _ => false }`,
+		`h?.a?.b; h.c; f?.(1); a?.[0]; "abc".len(); h?.m(...xs)`,
+		`x | f(_, 1) | g; (f >> g)(1); a ?? b`,
+		`let m = macro(a, b) { quote(unquote(b) - unquote(a)) };`,
+		"let s = \"line\\nbreak\";\n\nlet t = true == false;",
+		"// comment\nlet f = fn() {\n  1 // one\n};",
+		`let m = import "./m"; export let [a, {b}] = m.f();`,
+	}
+
+	for _, input := range tests {
+		program := parse(t, input)
+		data, err := Marshal(program)
+		if err != nil {
+			t.Fatalf("Marshal failed for %q: %s", input, err)
+		}
+		node, err := Unmarshal(data)
+		if err != nil {
+			t.Fatalf("Unmarshal failed for %q: %s", input, err)
+		}
+		decoded, ok := node.(*ast.Program)
+		if !ok {
+			t.Fatalf("node is not *ast.Program. got=%T", node)
+		}
+		if decoded.String() != program.String() {
+			t.Errorf("wrong program for %q.\nwant=%s\ngot =%s", input, program, decoded)
+		}
+		again, err := Marshal(decoded)
+		if err != nil {
+			t.Fatalf("Marshal failed for %q: %s", input, err)
+		}
+		if string(again) != string(data) {
+			t.Errorf("json changed by round trip for %q.\nwant=%s\ngot =%s", input, data, again)
+		}
+	}
+}
+
+func TestUnmarshalWithoutPositions(t *testing.T) {
+	input := `{"type":"Program","statements":[{"type":"ExpressionStatement","expression":
+		{"type":"CallExpression","function":{"type":"Identifier","value":"len"},
+		 "arguments":[{"type":"NumberLiteral","value":"1"}]}}]}`
+
+	node, err := Unmarshal([]byte(input))
+	if err != nil {
+		t.Fatalf("Unmarshal failed: %s", err)
+	}
+	if node.String() != "len(1)" {
+		t.Errorf("wrong program. got=%q", node.String())
+	}
+	if node.TokenLiteral() != "len" {
+		t.Errorf("wrong token literal. got=%q", node.TokenLiteral())
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`[]`, "expected node, got array"},
+		{`{"type":"Loop"}`, `unknown node type "Loop"`},
+		{`{"type":"Program"}`, `Program is missing "statements"`},
+		{`{"type":"InfixExpression","operator":"+","left":{"type":"NullLiteral"}}`, `InfixExpression is missing "right"`},
+		{
+			`{"type":"InfixExpression","operator":"%","left":{"type":"NullLiteral"},"right":{"type":"NullLiteral"}}`,
+			`unknown operator "%"`,
+		},
+		{
+			`{"type":"Program","statements":[{"type":"NullLiteral"}]}`,
+			"expected statement in statements of Program, got NullLiteral",
+		},
+		{
+			`{"type":"LetStatement","pattern":{"type":"NullLiteral"},"value":{"type":"NullLiteral"}}`,
+			"", // literals are patterns, as in match arms
+		},
+		{
+			`{"type":"LetStatement","pattern":{"type":"CallExpression","function":{"type":"NullLiteral"},"arguments":[]},"value":{"type":"NullLiteral"}}`,
+			"expected pattern in pattern of LetStatement, got CallExpression",
+		},
+		{`{"type":"Identifier","value":1}`, "expected string in value of Identifier, got number"},
+	}
+
+	for _, tt := range tests {
+		_, err := Unmarshal([]byte(tt.input))
+		if tt.expected == "" {
+			if err != nil {
+				t.Errorf("unexpected error for %s: %s", tt.input, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("expected error for %s", tt.input)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("wrong error for %s. want=%q, got=%q", tt.input, tt.expected, err.Error())
+		}
+	}
+}
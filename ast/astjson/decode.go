@@ -0,0 +1,528 @@
+package astjson
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/wreulicke/monkey/ast"
+	"github.com/wreulicke/monkey/lexer"
+	"github.com/wreulicke/monkey/token"
+)
+
+// Unmarshal rebuilds the node encoded in data.
+func Unmarshal(data []byte) (ast.Node, error) {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	d := &decoder{tokens: map[ast.Node]token.Token{}}
+	node := d.node(v)
+	if d.err != nil {
+		return nil, d.err
+	}
+	return node, nil
+}
+
+type decoder struct {
+	// tokens holds the token of each decoded expression, used to rebuild
+	// the token of the expression statement starting with it.
+	tokens map[ast.Node]token.Token
+	err    error
+}
+
+func (d *decoder) fail(format string, a ...interface{}) {
+	if d.err == nil {
+		d.err = fmt.Errorf(format, a...)
+	}
+}
+
+func (d *decoder) node(v interface{}) ast.Node {
+	o, ok := v.(map[string]interface{})
+	if !ok {
+		d.fail("expected node, got %s", describe(v))
+		return nil
+	}
+	kind, _ := o["type"].(string)
+	pos := d.pos(o)
+
+	switch kind {
+	case "Program":
+		program := &ast.Program{Statements: d.statements(o, "statements")}
+		if _, ok := o["comments"]; ok {
+			for _, v := range d.list(o, "comments") {
+				c := d.object(v, "comments of Program")
+				text := d.string(c, "text")
+				program.Comments = append(program.Comments, &ast.Comment{Token: newToken(token.COMMENT, text, d.pos(c))})
+			}
+		}
+		return program
+	case "LetStatement":
+		return &ast.LetStatement{
+			Token:   newToken(token.LET, "let", pos),
+			Pattern: d.pattern(o, "pattern"),
+			Value:   d.expression(o, "value"),
+		}
+	case "ExportStatement":
+		n := d.child(o, "statement")
+		let, ok := n.(*ast.LetStatement)
+		if n != nil && !ok {
+			d.fail("expected LetStatement in statement of ExportStatement, got %s", describe(n))
+		}
+		return &ast.ExportStatement{Token: newToken(token.EXPORT, "export", pos), Statement: let}
+	case "ReturnStatement":
+		return &ast.ReturnStatement{
+			Token:       newToken(token.RETURN, "return", pos),
+			ReturnValue: d.expression(o, "value"),
+		}
+	case "ExpressionStatement":
+		e := d.expression(o, "expression")
+		tok := d.tokens[leftmost(e)]
+		tok.Pos = pos
+		return &ast.ExpressionStatement{Token: tok, Expression: e}
+	case "BlockStatement":
+		return d.block(o, pos)
+	case "PrefixExpression":
+		operator := d.string(o, "operator")
+		return d.remember(&ast.PrefixExpression{
+			Token:    d.operator(operator, pos),
+			Operator: operator,
+			Right:    d.expression(o, "right"),
+		})
+	case "InfixExpression":
+		operator := d.string(o, "operator")
+		return d.remember(&ast.InfixExpression{
+			Token:    d.operator(operator, pos),
+			Left:     d.expression(o, "left"),
+			Operator: operator,
+			Right:    d.expression(o, "right"),
+		})
+	case "IfExpression":
+		e := &ast.IfExpression{
+			Token:       newToken(token.IF, "if", pos),
+			Condition:   d.expression(o, "condition"),
+			Consequence: d.blockField(o, "consequence"),
+		}
+		if _, ok := o["alternative"]; ok {
+			e.Alternative = d.blockField(o, "alternative")
+		}
+		return d.remember(e)
+	case "MatchExpression":
+		e := &ast.MatchExpression{
+			Token: newToken(token.MATCH, "match", pos),
+			Value: d.expression(o, "value"),
+			Arms:  []*ast.MatchArm{},
+		}
+		for _, v := range d.list(o, "arms") {
+			arm, ok := d.node(v).(*ast.MatchArm)
+			if !ok {
+				d.fail("expected MatchArm in arms of MatchExpression")
+				continue
+			}
+			e.Arms = append(e.Arms, arm)
+		}
+		return d.remember(e)
+	case "MatchArm":
+		arm := &ast.MatchArm{Pattern: d.pattern(o, "pattern")}
+		arm.Token = d.tokens[arm.Pattern]
+		arm.Token.Pos = pos
+		if _, ok := o["guard"]; ok {
+			arm.Guard = d.expression(o, "guard")
+		}
+		arm.Body = d.expression(o, "body")
+		return arm
+	case "CallExpression":
+		e := &ast.CallExpression{
+			Function:  d.expression(o, "function"),
+			Arguments: d.expressions(o, "arguments"),
+			Optional:  d.bool(o, "optional"),
+		}
+		e.Token = newToken(token.LPAREN, "(", pos)
+		if e.Optional {
+			e.Token = newToken(token.OPTIONAL, "?.", pos)
+		}
+		return e
+	case "IndexExpression":
+		e := &ast.IndexExpression{
+			Left:     d.expression(o, "left"),
+			Index:    d.expression(o, "index"),
+			Optional: d.bool(o, "optional"),
+		}
+		e.Token = newToken(token.LBRACKET, "[", pos)
+		if e.Optional {
+			e.Token = newToken(token.OPTIONAL, "?.", pos)
+		}
+		return e
+	case "MethodCallExpression":
+		e := &ast.MethodCallExpression{
+			Receiver:  d.expression(o, "receiver"),
+			Method:    d.identifier(o, "method"),
+			Arguments: d.expressions(o, "arguments"),
+			Optional:  d.bool(o, "optional"),
+		}
+		e.Token = newToken(token.DOT, ".", pos)
+		if e.Optional {
+			e.Token = newToken(token.OPTIONAL, "?.", pos)
+		}
+		return e
+	case "SpreadExpression":
+		return d.remember(&ast.SpreadExpression{
+			Token: newToken(token.ELLIPSIS, "...", pos),
+			Value: d.expression(o, "value"),
+		})
+	case "Identifier":
+		value := d.string(o, "value")
+		return d.remember(&ast.Identifier{Token: newToken(token.LookupIdent(value), value, pos), Value: value})
+	case "NumberLiteral":
+		value := d.string(o, "value")
+		return d.remember(&ast.NumberLiteral{Token: newToken(token.NUMBER, strings.TrimPrefix(value, "-"), pos), Value: value})
+	case "StringLiteral":
+		value := d.string(o, "value")
+		return d.remember(&ast.StringLiteral{Token: newToken(token.STRING, value, pos), Value: value})
+	case "BooleanLiteral":
+		if d.bool(o, "value") {
+			return d.remember(&ast.BooleanLiteral{Token: newToken(token.TRUE, "true", pos), Value: true})
+		}
+		return d.remember(&ast.BooleanLiteral{Token: newToken(token.FALSE, "false", pos), Value: false})
+	case "NullLiteral":
+		return d.remember(&ast.NullLiteral{Token: newToken(token.NULL, "null", pos)})
+	case "ArrayLiteral":
+		return d.remember(&ast.ArrayLiteral{
+			Token:    newToken(token.LBRACKET, "[", pos),
+			Elements: d.expressions(o, "elements"),
+		})
+	case "HashLiteral":
+		e := &ast.HashLiteral{Token: newToken(token.LBRACE, "{", pos), Pairs: []ast.HashPair{}}
+		for _, v := range d.list(o, "pairs") {
+			pair := d.object(v, "pairs of HashLiteral")
+			e.Pairs = append(e.Pairs, ast.HashPair{
+				Key:   d.expression(pair, "key"),
+				Value: d.expression(pair, "value"),
+			})
+		}
+		return d.remember(e)
+	case "ImportExpression":
+		n := d.child(o, "path")
+		path, ok := n.(*ast.StringLiteral)
+		if n != nil && !ok {
+			d.fail("expected StringLiteral in path of ImportExpression, got %s", describe(n))
+		}
+		return d.remember(&ast.ImportExpression{Token: newToken(token.IMPORT, "import", pos), Path: path})
+	case "FunctionLiteral":
+		return d.remember(d.functionLiteral(o, pos))
+	case "MacroLiteral":
+		e := &ast.MacroLiteral{Token: newToken(token.MACRO, "macro", pos), Parameters: []*ast.Identifier{}}
+		for _, v := range d.list(o, "parameters") {
+			id, ok := d.node(v).(*ast.Identifier)
+			if !ok {
+				d.fail("expected Identifier in parameters of MacroLiteral")
+				continue
+			}
+			e.Parameters = append(e.Parameters, id)
+		}
+		e.Body = d.blockField(o, "body")
+		return d.remember(e)
+	case "ArrayPattern":
+		p := &ast.ArrayPattern{Token: newToken(token.LBRACKET, "[", pos)}
+		for _, v := range d.list(o, "elements") {
+			p.Pattern = append(p.Pattern, d.patternValue(v, "elements of ArrayPattern"))
+		}
+		return d.remember(p)
+	case "HashPattern":
+		p := &ast.HashPattern{Token: newToken(token.LBRACE, "{", pos)}
+		for _, v := range d.list(o, "keys") {
+			key := d.object(v, "keys of HashPattern")
+			p.Pattern = append(p.Pattern, d.identifier(key, "key"))
+			var value ast.Pattern
+			if _, ok := key["value"]; ok {
+				value = d.pattern(key, "value")
+			}
+			p.Values = append(p.Values, value)
+		}
+		return d.remember(p)
+	case "WildcardPattern":
+		return d.remember(&ast.WildcardPattern{Token: newToken(token.IDENT, "_", pos)})
+	}
+	d.fail("unknown node type %q", kind)
+	return nil
+}
+
+func (d *decoder) functionLiteral(o map[string]interface{}, pos token.Position) *ast.FunctionLiteral {
+	e := &ast.FunctionLiteral{Token: newToken(token.FUNCTION, "fn", pos), Parameters: []ast.Pattern{}}
+	defaults := []ast.Expression{}
+	hasDefault := false
+	for _, v := range d.list(o, "parameters") {
+		param := d.object(v, "parameters of FunctionLiteral")
+		e.Parameters = append(e.Parameters, d.pattern(param, "pattern"))
+		var value ast.Expression
+		if _, ok := param["default"]; ok {
+			value = d.expression(param, "default")
+			hasDefault = true
+		}
+		defaults = append(defaults, value)
+	}
+	if hasDefault {
+		e.Defaults = defaults
+	}
+	if _, ok := o["rest"]; ok {
+		e.Rest = d.identifier(o, "rest")
+	}
+	e.Name, _ = o["name"].(string)
+	e.Body = d.blockField(o, "body")
+	return e
+}
+
+func (d *decoder) block(o map[string]interface{}, pos token.Position) *ast.BlockStatement {
+	return &ast.BlockStatement{
+		Token:      newToken(token.LBRACE, "{", pos),
+		Statements: d.statements(o, "statements"),
+		Rbrace:     d.position(o["end"]),
+	}
+}
+
+// remember records the token of e and returns e.
+func (d *decoder) remember(e ast.Node) ast.Node {
+	switch e := e.(type) {
+	case *ast.PrefixExpression:
+		d.tokens[e] = e.Token
+	case *ast.InfixExpression:
+		d.tokens[e] = e.Token
+	case *ast.IfExpression:
+		d.tokens[e] = e.Token
+	case *ast.MatchExpression:
+		d.tokens[e] = e.Token
+	case *ast.SpreadExpression:
+		d.tokens[e] = e.Token
+	case *ast.Identifier:
+		d.tokens[e] = e.Token
+	case *ast.NumberLiteral:
+		d.tokens[e] = e.Token
+	case *ast.StringLiteral:
+		d.tokens[e] = e.Token
+	case *ast.BooleanLiteral:
+		d.tokens[e] = e.Token
+	case *ast.NullLiteral:
+		d.tokens[e] = e.Token
+	case *ast.ArrayLiteral:
+		d.tokens[e] = e.Token
+	case *ast.HashLiteral:
+		d.tokens[e] = e.Token
+	case *ast.FunctionLiteral:
+		d.tokens[e] = e.Token
+	case *ast.ImportExpression:
+		d.tokens[e] = e.Token
+	case *ast.MacroLiteral:
+		d.tokens[e] = e.Token
+	case *ast.ArrayPattern:
+		d.tokens[e] = e.Token
+	case *ast.HashPattern:
+		d.tokens[e] = e.Token
+	case *ast.WildcardPattern:
+		d.tokens[e] = e.Token
+	}
+	return e
+}
+
+// leftmost returns the expression an expression statement starting with e
+// starts with, since its token is the first token of the statement.
+func leftmost(e ast.Expression) ast.Node {
+	for {
+		switch n := e.(type) {
+		case *ast.InfixExpression:
+			e = n.Left
+		case *ast.IndexExpression:
+			e = n.Left
+		case *ast.CallExpression:
+			e = n.Function
+		case *ast.MethodCallExpression:
+			e = n.Receiver
+		default:
+			return e
+		}
+	}
+}
+
+func (d *decoder) operator(operator string, pos token.Position) token.Token {
+	tok := lexer.New(strings.NewReader(operator)).NextToken()
+	if tok.Type == token.ILLEGAL || tok.Literal != operator {
+		d.fail("unknown operator %q", operator)
+	}
+	return newToken(tok.Type, operator, pos)
+}
+
+func newToken(t token.TokenType, literal string, pos token.Position) token.Token {
+	return token.Token{Type: t, Literal: literal, Pos: pos}
+}
+
+func (d *decoder) pos(o map[string]interface{}) token.Position {
+	return d.position(o["pos"])
+}
+
+func (d *decoder) position(v interface{}) token.Position {
+	p, ok := v.(map[string]interface{})
+	if !ok {
+		return token.Position{}
+	}
+	line, _ := p["line"].(float64)
+	column, _ := p["column"].(float64)
+	return token.Position{Line: int(line), Column: int(column)}
+}
+
+func (d *decoder) object(v interface{}, context string) map[string]interface{} {
+	o, ok := v.(map[string]interface{})
+	if !ok {
+		d.fail("expected object in %s, got %s", context, describe(v))
+		return map[string]interface{}{}
+	}
+	return o
+}
+
+func (d *decoder) field(o map[string]interface{}, key string) (interface{}, bool) {
+	v, ok := o[key]
+	if !ok || v == nil {
+		d.fail("%s is missing %q", kindOf(o), key)
+		return nil, false
+	}
+	return v, true
+}
+
+func (d *decoder) string(o map[string]interface{}, key string) string {
+	v, ok := d.field(o, key)
+	if !ok {
+		return ""
+	}
+	s, ok := v.(string)
+	if !ok {
+		d.fail("expected string in %s of %s, got %s", key, kindOf(o), describe(v))
+	}
+	return s
+}
+
+func (d *decoder) bool(o map[string]interface{}, key string) bool {
+	v, ok := o[key]
+	if !ok {
+		return false
+	}
+	b, ok := v.(bool)
+	if !ok {
+		d.fail("expected boolean in %s of %s, got %s", key, kindOf(o), describe(v))
+	}
+	return b
+}
+
+func (d *decoder) list(o map[string]interface{}, key string) []interface{} {
+	v, ok := d.field(o, key)
+	if !ok {
+		return nil
+	}
+	l, ok := v.([]interface{})
+	if !ok {
+		d.fail("expected array in %s of %s, got %s", key, kindOf(o), describe(v))
+	}
+	return l
+}
+
+func (d *decoder) child(o map[string]interface{}, key string) ast.Node {
+	v, ok := d.field(o, key)
+	if !ok {
+		return nil
+	}
+	return d.node(v)
+}
+
+func (d *decoder) expression(o map[string]interface{}, key string) ast.Expression {
+	n := d.child(o, key)
+	e, ok := n.(ast.Expression)
+	if n != nil && !ok {
+		d.fail("expected expression in %s of %s, got %s", key, kindOf(o), describe(n))
+	}
+	return e
+}
+
+func (d *decoder) expressions(o map[string]interface{}, key string) []ast.Expression {
+	result := []ast.Expression{}
+	for _, v := range d.list(o, key) {
+		n := d.node(v)
+		e, ok := n.(ast.Expression)
+		if n != nil && !ok {
+			d.fail("expected expression in %s of %s, got %s", key, kindOf(o), describe(n))
+		}
+		result = append(result, e)
+	}
+	return result
+}
+
+func (d *decoder) statements(o map[string]interface{}, key string) []ast.Statement {
+	result := []ast.Statement{}
+	for _, v := range d.list(o, key) {
+		n := d.node(v)
+		s, ok := n.(ast.Statement)
+		if n != nil && !ok {
+			d.fail("expected statement in %s of %s, got %s", key, kindOf(o), describe(n))
+		}
+		result = append(result, s)
+	}
+	return result
+}
+
+func (d *decoder) pattern(o map[string]interface{}, key string) ast.Pattern {
+	v, ok := d.field(o, key)
+	if !ok {
+		return nil
+	}
+	return d.patternValue(v, fmt.Sprintf("%s of %s", key, kindOf(o)))
+}
+
+func (d *decoder) patternValue(v interface{}, context string) ast.Pattern {
+	n := d.node(v)
+	p, ok := n.(ast.Pattern)
+	if n != nil && !ok {
+		d.fail("expected pattern in %s, got %s", context, describe(n))
+	}
+	return p
+}
+
+func (d *decoder) identifier(o map[string]interface{}, key string) *ast.Identifier {
+	n := d.child(o, key)
+	id, ok := n.(*ast.Identifier)
+	if n != nil && !ok {
+		d.fail("expected Identifier in %s of %s, got %s", key, kindOf(o), describe(n))
+	}
+	return id
+}
+
+func (d *decoder) blockField(o map[string]interface{}, key string) *ast.BlockStatement {
+	n := d.child(o, key)
+	b, ok := n.(*ast.BlockStatement)
+	if n != nil && !ok {
+		d.fail("expected BlockStatement in %s of %s, got %s", key, kindOf(o), describe(n))
+	}
+	return b
+}
+
+func kindOf(o map[string]interface{}) string {
+	if kind, ok := o["type"].(string); ok {
+		return kind
+	}
+	return "object"
+}
+
+func describe(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case ast.Node:
+		return strings.TrimPrefix(fmt.Sprintf("%T", v), "*ast.")
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	}
+	return fmt.Sprintf("%T", v)
+}
@@ -0,0 +1,110 @@
+package ast
+
+type ModifierFunc func(Node) Node
+
+// Modify replaces every node below node, children first, with the result
+// of modifier, and returns modifier(node). A replacement that does not fit
+// the field it is stored in, such as an expression replacing a statement,
+// leaves nil behind.
+func Modify(node Node, modifier ModifierFunc) Node {
+	switch node := node.(type) {
+	case *Program:
+		for i, statement := range node.Statements {
+			node.Statements[i], _ = Modify(statement, modifier).(Statement)
+		}
+	case *BlockStatement:
+		for i, statement := range node.Statements {
+			node.Statements[i], _ = Modify(statement, modifier).(Statement)
+		}
+	case *ExpressionStatement:
+		node.Expression, _ = Modify(node.Expression, modifier).(Expression)
+	case *ReturnStatement:
+		node.ReturnValue, _ = Modify(node.ReturnValue, modifier).(Expression)
+	case *LetStatement:
+		node.Pattern, _ = Modify(node.Pattern, modifier).(Pattern)
+		node.Value, _ = Modify(node.Value, modifier).(Expression)
+	case *ExportStatement:
+		node.Statement, _ = Modify(node.Statement, modifier).(*LetStatement)
+	case *PrefixExpression:
+		node.Right, _ = Modify(node.Right, modifier).(Expression)
+	case *InfixExpression:
+		node.Left, _ = Modify(node.Left, modifier).(Expression)
+		node.Right, _ = Modify(node.Right, modifier).(Expression)
+	case *IndexExpression:
+		node.Left, _ = Modify(node.Left, modifier).(Expression)
+		node.Index, _ = Modify(node.Index, modifier).(Expression)
+	case *CallExpression:
+		node.Function, _ = Modify(node.Function, modifier).(Expression)
+		modifyExpressions(node.Arguments, modifier)
+	case *MethodCallExpression:
+		node.Receiver, _ = Modify(node.Receiver, modifier).(Expression)
+		node.Method, _ = Modify(node.Method, modifier).(*Identifier)
+		modifyExpressions(node.Arguments, modifier)
+	case *SpreadExpression:
+		node.Value, _ = Modify(node.Value, modifier).(Expression)
+	case *ImportExpression:
+		node.Path, _ = Modify(node.Path, modifier).(*StringLiteral)
+	case *IfExpression:
+		node.Condition, _ = Modify(node.Condition, modifier).(Expression)
+		node.Consequence, _ = Modify(node.Consequence, modifier).(*BlockStatement)
+		if node.Alternative != nil {
+			node.Alternative, _ = Modify(node.Alternative, modifier).(*BlockStatement)
+		}
+	case *MatchExpression:
+		node.Value, _ = Modify(node.Value, modifier).(Expression)
+		for i, arm := range node.Arms {
+			node.Arms[i], _ = Modify(arm, modifier).(*MatchArm)
+		}
+	case *MatchArm:
+		node.Pattern, _ = Modify(node.Pattern, modifier).(Pattern)
+		if node.Guard != nil {
+			node.Guard, _ = Modify(node.Guard, modifier).(Expression)
+		}
+		node.Body, _ = Modify(node.Body, modifier).(Expression)
+	case *FunctionLiteral:
+		for i, p := range node.Parameters {
+			node.Parameters[i], _ = Modify(p, modifier).(Pattern)
+		}
+		for i, d := range node.Defaults {
+			if d != nil {
+				node.Defaults[i], _ = Modify(d, modifier).(Expression)
+			}
+		}
+		if node.Rest != nil {
+			node.Rest, _ = Modify(node.Rest, modifier).(*Identifier)
+		}
+		node.Body, _ = Modify(node.Body, modifier).(*BlockStatement)
+	case *MacroLiteral:
+		for i, p := range node.Parameters {
+			node.Parameters[i], _ = Modify(p, modifier).(*Identifier)
+		}
+		node.Body, _ = Modify(node.Body, modifier).(*BlockStatement)
+	case *ArrayLiteral:
+		modifyExpressions(node.Elements, modifier)
+	case *HashLiteral:
+		for i, pair := range node.Pairs {
+			node.Pairs[i].Key, _ = Modify(pair.Key, modifier).(Expression)
+			node.Pairs[i].Value, _ = Modify(pair.Value, modifier).(Expression)
+		}
+	case *ArrayPattern:
+		for i, p := range node.Pattern {
+			node.Pattern[i], _ = Modify(p, modifier).(Pattern)
+		}
+	case *HashPattern:
+		for i, key := range node.Pattern {
+			node.Pattern[i], _ = Modify(key, modifier).(*Identifier)
+		}
+		for i, p := range node.Values {
+			if p != nil {
+				node.Values[i], _ = Modify(p, modifier).(Pattern)
+			}
+		}
+	}
+	return modifier(node)
+}
+
+func modifyExpressions(expressions []Expression, modifier ModifierFunc) {
+	for i, e := range expressions {
+		expressions[i], _ = Modify(e, modifier).(Expression)
+	}
+}
@@ -0,0 +1,132 @@
+package ast
+
+// A Visitor's Visit method is invoked for each node encountered by Walk.
+// If the result visitor w is not nil, Walk visits each of the children
+// of node with the visitor w, followed by a call of w.Visit(nil).
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// Walk traverses the syntax tree below node in depth-first order, visiting
+// children in source order.
+func Walk(v Visitor, node Node) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+
+	switch n := node.(type) {
+	case *Program:
+		for _, s := range n.Statements {
+			Walk(v, s)
+		}
+	case *BlockStatement:
+		for _, s := range n.Statements {
+			Walk(v, s)
+		}
+	case *ExpressionStatement:
+		walkIfPresent(v, n.Expression)
+	case *ReturnStatement:
+		walkIfPresent(v, n.ReturnValue)
+	case *LetStatement:
+		walkIfPresent(v, n.Pattern)
+		walkIfPresent(v, n.Value)
+	case *ExportStatement:
+		Walk(v, n.Statement)
+	case *PrefixExpression:
+		Walk(v, n.Right)
+	case *InfixExpression:
+		Walk(v, n.Left)
+		Walk(v, n.Right)
+	case *IndexExpression:
+		Walk(v, n.Left)
+		Walk(v, n.Index)
+	case *CallExpression:
+		Walk(v, n.Function)
+		walkExpressions(v, n.Arguments)
+	case *MethodCallExpression:
+		Walk(v, n.Receiver)
+		Walk(v, n.Method)
+		walkExpressions(v, n.Arguments)
+	case *SpreadExpression:
+		Walk(v, n.Value)
+	case *ImportExpression:
+		Walk(v, n.Path)
+	case *IfExpression:
+		Walk(v, n.Condition)
+		Walk(v, n.Consequence)
+		if n.Alternative != nil {
+			Walk(v, n.Alternative)
+		}
+	case *MatchExpression:
+		Walk(v, n.Value)
+		for _, arm := range n.Arms {
+			Walk(v, arm)
+		}
+	case *MatchArm:
+		Walk(v, n.Pattern)
+		walkIfPresent(v, n.Guard)
+		Walk(v, n.Body)
+	case *FunctionLiteral:
+		for i, p := range n.Parameters {
+			Walk(v, p)
+			walkIfPresent(v, n.Default(i))
+		}
+		if n.Rest != nil {
+			Walk(v, n.Rest)
+		}
+		Walk(v, n.Body)
+	case *MacroLiteral:
+		for _, p := range n.Parameters {
+			Walk(v, p)
+		}
+		Walk(v, n.Body)
+	case *ArrayLiteral:
+		walkExpressions(v, n.Elements)
+	case *HashLiteral:
+		for _, pair := range n.Pairs {
+			Walk(v, pair.Key)
+			Walk(v, pair.Value)
+		}
+	case *ArrayPattern:
+		for _, p := range n.Pattern {
+			Walk(v, p)
+		}
+	case *HashPattern:
+		for i, key := range n.Pattern {
+			Walk(v, key)
+			if i < len(n.Values) {
+				walkIfPresent(v, n.Values[i])
+			}
+		}
+	}
+
+	v.Visit(nil)
+}
+
+func walkIfPresent(v Visitor, node Node) {
+	if node != nil {
+		Walk(v, node)
+	}
+}
+
+func walkExpressions(v Visitor, expressions []Expression) {
+	for _, e := range expressions {
+		Walk(v, e)
+	}
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect traverses the syntax tree below node in depth-first order,
+// calling f for each node. If f returns true, Inspect continues with the
+// children of node, followed by a call of f(nil).
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
@@ -1,13 +1,25 @@
 package cli
 
 import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
 	"os"
 
 	"github.com/spf13/cobra"
 
+	"github.com/wreulicke/monkey/ast/astjson"
+	"github.com/wreulicke/monkey/compiler"
+	"github.com/wreulicke/monkey/format"
+	"github.com/wreulicke/monkey/interpreter"
 	interpreterRepl "github.com/wreulicke/monkey/interpreter/repl"
+	"github.com/wreulicke/monkey/lexer"
 	lexerRepl "github.com/wreulicke/monkey/lexer/repl"
+	"github.com/wreulicke/monkey/module"
+	"github.com/wreulicke/monkey/object"
+	"github.com/wreulicke/monkey/parser"
 	parserRepl "github.com/wreulicke/monkey/parser/repl"
+	"github.com/wreulicke/monkey/vm"
 	vmRepl "github.com/wreulicke/monkey/vm/repl"
 )
 
@@ -18,7 +30,7 @@ func New() *cobra.Command {
 		Run: func(cmd *cobra.Command, args []string) {
 		},
 	}
-	c.AddCommand(NewInterpreterCommand(), NewLexerCommand(), NewParserCommand(), NewVMCommand())
+	c.AddCommand(NewInterpreterCommand(), NewLexerCommand(), NewParserCommand(), NewVMCommand(), NewASTCommand(), NewFmtCommand(), NewRunCommand())
 	return c
 }
 
@@ -65,3 +77,157 @@ func NewParserCommand() *cobra.Command {
 	}
 	return c
 }
+
+func NewASTCommand() *cobra.Command {
+	var format string
+	c := &cobra.Command{
+		Use:   "ast file",
+		Short: "print the syntax tree of a file",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			f, err := os.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+
+			p := parser.New(lexer.New(f))
+			program := p.Parse()
+			if len(p.Errors()) != 0 {
+				for _, e := range p.Errors() {
+					fmt.Fprintln(cmd.ErrOrStderr(), e)
+				}
+				return fmt.Errorf("cannot parse %s", args[0])
+			}
+
+			switch format {
+			case "text":
+				fmt.Fprintln(cmd.OutOrStdout(), program.String())
+			case "json":
+				data, err := astjson.MarshalIndent(program, "", "  ")
+				if err != nil {
+					return err
+				}
+				fmt.Fprintln(cmd.OutOrStdout(), string(data))
+			default:
+				return fmt.Errorf("unknown format %q: want text or json", format)
+			}
+			return nil
+		},
+	}
+	c.Flags().StringVar(&format, "format", "text", "output format: text or json")
+	return c
+}
+
+func NewFmtCommand() *cobra.Command {
+	var write, showDiff bool
+	c := &cobra.Command{
+		Use:   "fmt files...",
+		Short: "format source files",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			for _, name := range args {
+				src, err := ioutil.ReadFile(name)
+				if err != nil {
+					return err
+				}
+				out, err := format.Source(src)
+				if err != nil {
+					return fmt.Errorf("%s: %w", name, err)
+				}
+
+				if showDiff {
+					cmd.OutOrStdout().Write(diff(name, src, out))
+				}
+				if write {
+					if string(out) == string(src) {
+						continue
+					}
+					info, err := os.Stat(name)
+					if err != nil {
+						return err
+					}
+					if err := ioutil.WriteFile(name, out, info.Mode().Perm()); err != nil {
+						return err
+					}
+				}
+				if !showDiff && !write {
+					cmd.OutOrStdout().Write(out)
+				}
+			}
+			return nil
+		},
+	}
+	c.Flags().BoolVarP(&write, "write", "w", false, "write the result to the source file instead of stdout")
+	c.Flags().BoolVarP(&showDiff, "diff", "d", false, "display diffs instead of the formatted source")
+	return c
+}
+
+func NewRunCommand() *cobra.Command {
+	var engine string
+	var seed int64
+	c := &cobra.Command{
+		Use:          "run file",
+		Short:        "run a file",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			f, err := os.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+
+			p := parser.New(lexer.New(f))
+			program := p.Parse()
+			if len(p.Errors()) != 0 {
+				for _, e := range p.Errors() {
+					fmt.Fprintln(cmd.ErrOrStderr(), e)
+				}
+				return fmt.Errorf("cannot parse %s", args[0])
+			}
+			macroEnv := object.NewEnvironment()
+			interpreter.DefineMacros(program, macroEnv)
+			expanded, expandErr := interpreter.ExpandMacros(program, macroEnv)
+			if expandErr != nil {
+				return fmt.Errorf("%s", expandErr.Message)
+			}
+
+			ctx := &object.Context{
+				File:         args[0],
+				Modules:      module.NewLoader(module.SearchPath()...),
+				Capabilities: object.CapFileSystem | object.CapStdin,
+				Stdout:       cmd.OutOrStdout(),
+				Stderr:       cmd.ErrOrStderr(),
+			}
+			if cmd.Flags().Changed("seed") {
+				ctx.Rand = rand.New(rand.NewSource(seed))
+			}
+			switch engine {
+			case "interpreter":
+				env := object.NewEnvironment()
+				env.SetContext(ctx)
+				if result, ok := interpreter.Eval(expanded, env).(*object.Error); ok {
+					return fmt.Errorf("%s", result.Message)
+				}
+			case "vm":
+				comp := compiler.New()
+				comp.SetContext(ctx)
+				if err := comp.Compile(expanded); err != nil {
+					return err
+				}
+				machine := vm.New(comp.Bytecode())
+				machine.SetContext(ctx)
+				if err := machine.Run(); err != nil {
+					return err
+				}
+			default:
+				return fmt.Errorf("unknown engine %q: want interpreter or vm", engine)
+			}
+			return nil
+		},
+	}
+	c.Flags().StringVar(&engine, "engine", "vm", "engine running the file: interpreter or vm")
+	c.Flags().Int64Var(&seed, "seed", 0, "seed of the random numbers of the math builtins, random when not given")
+	return c
+}
@@ -0,0 +1,74 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// numbered returns the lines from..to, each a number, replacing those in
+// changes.
+func numbered(from, to int, changes map[int]string) string {
+	var out strings.Builder
+	for i := from; i <= to; i++ {
+		if line, ok := changes[i]; ok {
+			out.WriteString(line + "\n")
+		} else {
+			fmt.Fprintf(&out, "%d\n", i)
+		}
+	}
+	return out.String()
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		a, b     string
+		expected string
+	}{
+		{"identical", "a\nb\n", "a\nb\n", ""},
+		{"insert", "a\nb\n", "a\nx\nb\n", "@@ -1,2 +1,3 @@\n a\n+x\n b\n"},
+		{"insert into empty", "", "x\n", "@@ -0,0 +1,1 @@\n+x\n"},
+		{"delete", "a\nb\nc\n", "a\nc\n", "@@ -1,3 +1,2 @@\n a\n-b\n c\n"},
+		{"delete all", "x\n", "", "@@ -1,1 +0,0 @@\n-x\n"},
+		{
+			"change in the middle",
+			numbered(1, 10, nil),
+			numbered(1, 10, map[int]string{5: "five"}),
+			"@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n",
+		},
+		{
+			"changes sharing context",
+			numbered(1, 10, nil),
+			numbered(1, 10, map[int]string{2: "two", 9: "nine"}),
+			"@@ -1,10 +1,10 @@\n 1\n-2\n+two\n 3\n 4\n 5\n 6\n 7\n 8\n-9\n+nine\n 10\n",
+		},
+		{
+			"distant changes",
+			numbered(1, 20, nil),
+			numbered(1, 20, map[int]string{2: "two", 18: "eighteen"}),
+			"@@ -1,5 +1,5 @@\n 1\n-2\n+two\n 3\n 4\n 5\n" +
+				"@@ -15,6 +15,6 @@\n 15\n 16\n 17\n-18\n+eighteen\n 19\n 20\n",
+		},
+		{
+			"newline added at the end",
+			"a\nb", "a\nb\n",
+			"@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+b\n",
+		},
+		{
+			"change without newlines at the end",
+			"a\nb", "a\nc",
+			"@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+c\n\\ No newline at end of file\n",
+		},
+	}
+	for _, tt := range tests {
+		got := string(diff("f.mk", []byte(tt.a), []byte(tt.b)))
+		expected := tt.expected
+		if expected != "" {
+			expected = "--- f.mk\n+++ f.mk\n" + expected
+		}
+		if got != expected {
+			t.Errorf("%s: wrong diff.\nwant=%q\ngot=%q", tt.name, expected, got)
+		}
+	}
+}
@@ -29,6 +29,14 @@ const (
 
 	OpJumpNotTruthy
 	OpJump
+	// OpJumpNull jumps when the top of the stack is null, leaving it there.
+	OpJumpNull
+	// OpJumpNotNull jumps when the top of the stack is not null, leaving it
+	// there, and pops it otherwise.
+	OpJumpNotNull
+	// OpJumpArgGiven jumps when the current call was given an argument for
+	// the parameter at the index of its first operand.
+	OpJumpArgGiven
 
 	OpNull
 
@@ -37,10 +45,16 @@ const (
 
 	OpArray
 	OpHash
+	// OpSpread marks the array on top of the stack to be expanded into its
+	// elements by the following OpArray, OpCall or OpMethodCall.
+	OpSpread
 
 	OpIndex
 
 	OpCall
+	// OpMethodCall calls the method named by a constant on the receiver
+	// below the arguments.
+	OpMethodCall
 	OpReturn
 	OpReturnValue
 
@@ -51,6 +65,24 @@ const (
 	OpClosure
 	OpGetFree
 	OpCurrentClosure
+	// OpCompose replaces the two functions on top of the stack with a
+	// closure calling the lower one and passing the result to the upper one.
+	OpCompose
+
+	OpMatchArray
+	OpMatchHash
+	OpMatchKey
+	OpMatchValue
+	OpNoMatch
+	// OpDestructureArray pops a value and fails unless an array pattern of
+	// the operand elements can destructure it, OpDestructureHash unless a
+	// hash pattern of the keys in the constant operand can.
+	OpDestructureArray
+	OpDestructureHash
+
+	// OpImport pushes the exports of a module, calling the function in the
+	// constant operand to compute them when the global operand is unset.
+	OpImport
 )
 
 type Definition struct {
@@ -77,6 +109,9 @@ var definitions = map[Opcode]*Definition{
 
 	OpJumpNotTruthy: {"OpJumpNotTruthy", []int{2}},
 	OpJump:          {"OpJump", []int{2}},
+	OpJumpNull:      {"OpJumpNull", []int{2}},
+	OpJumpNotNull:   {"OpJumpNotNull", []int{2}},
+	OpJumpArgGiven:  {"OpJumpArgGiven", []int{1, 2}},
 	OpNull:          {"OpNull", []int{}},
 
 	OpGetGlobal: {"OpGetGlobal", []int{2}},
@@ -84,13 +119,15 @@ var definitions = map[Opcode]*Definition{
 
 	OpGetLocal:   {"OpGetLocal", []int{1}},
 	OpSetLocal:   {"OpSetLocal", []int{1}},
-	OpGetBuiltin: {"OpGetBuiltin", []int{1}},
+	OpGetBuiltin: {"OpGetBuiltin", []int{2}},
 
-	OpArray: {"OpArray", []int{2}},
-	OpHash:  {"OpHash", []int{2}},
-	OpIndex: {"OpIndex", []int{}},
+	OpArray:  {"OpArray", []int{2}},
+	OpHash:   {"OpHash", []int{2}},
+	OpSpread: {"OpSpread", []int{}},
+	OpIndex:  {"OpIndex", []int{}},
 
 	OpCall:        {"OpCall", []int{1}},
+	OpMethodCall:  {"OpMethodCall", []int{2, 1}},
 	OpReturnValue: {"OpReturnValue", []int{}},
 	OpReturn:      {"OpReturn", []int{}},
 
@@ -98,6 +135,18 @@ var definitions = map[Opcode]*Definition{
 	OpGetFree: {"OpGetFree", []int{1}},
 
 	OpCurrentClosure: {"OpCurrentClosure", []int{}},
+	OpCompose:        {"OpCompose", []int{}},
+
+	OpMatchArray: {"OpMatchArray", []int{2}},
+	OpMatchHash:  {"OpMatchHash", []int{}},
+	OpMatchKey:   {"OpMatchKey", []int{2}},
+	OpMatchValue: {"OpMatchValue", []int{2}},
+	OpNoMatch:    {"OpNoMatch", []int{}},
+
+	OpDestructureArray: {"OpDestructureArray", []int{2}},
+	OpDestructureHash:  {"OpDestructureHash", []int{2}},
+
+	OpImport: {"OpImport", []int{2, 2}},
 }
 
 func Lookup(op byte) (*Definition, error) {
@@ -12,6 +12,7 @@ func TestMake(t *testing.T) {
 		{OpAdd, []int{}, []byte{byte(OpAdd)}},
 		{OpGetLocal, []int{255}, []byte{byte(OpGetLocal), 255}},
 		{OpClosure, []int{65534, 255}, []byte{byte(OpClosure), 255, 254, 255}},
+		{OpGetBuiltin, []int{300}, []byte{byte(OpGetBuiltin), 1, 44}},
 	}
 	for _, tt := range tests {
 		instruction := Make(tt.op, tt.operands...)
@@ -60,6 +61,7 @@ func TestReadOperands(t *testing.T) {
 	}{
 		{OpConstant, []int{65535}, 2},
 		{OpClosure, []int{65535, 255}, 3},
+		{OpGetBuiltin, []int{65535}, 2},
 	}
 	for _, tt := range tests {
 		instruction := Make(tt.op, tt.operands...)
@@ -2,8 +2,6 @@ package compiler
 
 import (
 	"fmt"
-	"sort"
-	"strconv"
 
 	"github.com/wreulicke/monkey/ast"
 	"github.com/wreulicke/monkey/code"
@@ -21,6 +19,8 @@ type Compiler struct {
 
 	scopes     []CompilationScope
 	scopeIndex int
+
+	ctx *object.Context
 }
 
 type CompilationScope struct {
@@ -33,9 +33,7 @@ func New() *Compiler {
 	mainScope := CompilationScope{}
 
 	symbolTable := NewSymbolTable()
-	for i, v := range object.Builtins {
-		symbolTable.DefineBuiltin(i, v.Name)
-	}
+	symbolTable.SetBuiltins(object.DefaultRegistry())
 
 	return &Compiler{
 		constants:   []object.Object{},
@@ -51,10 +49,20 @@ func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
 	return compiler
 }
 
+// SetContext sets the file being compiled, the loader of the modules it
+// imports and the builtins it refers to.
+func (c *Compiler) SetContext(ctx *object.Context) {
+	c.ctx = ctx
+	c.symbolTable.SetBuiltins(ctx.BuiltinRegistry())
+}
+
 func (c *Compiler) Compile(node ast.Node) error {
 	switch node := node.(type) {
 	case *ast.Program:
 		for _, s := range node.Statements {
+			if export, ok := s.(*ast.ExportStatement); ok {
+				s = export.Statement
+			}
 			err := c.Compile(s)
 			if err != nil {
 				return err
@@ -68,7 +76,6 @@ func (c *Compiler) Compile(node ast.Node) error {
 			}
 		}
 	case *ast.LetStatement:
-		// TODO support pattern match
 		switch p := node.Pattern.(type) {
 		case *ast.Identifier:
 			symbol := c.symbolTable.Define(p.Value)
@@ -78,74 +85,31 @@ func (c *Compiler) Compile(node ast.Node) error {
 				return err
 			}
 
-			if symbol.Scope == GlobalScope {
-				c.emit(code.OpSetGlobal, symbol.Index)
-			} else {
-				c.emit(code.OpSetLocal, symbol.Index)
-			}
-		case *ast.ArrayPattern:
+			c.setSymbol(symbol)
+		case *ast.WildcardPattern:
 			err := c.Compile(node.Value)
 			if err != nil {
 				return err
 			}
-
-			tempSymbol := c.symbolTable.Define("$") // FIXME better way?
-			if tempSymbol.Scope == GlobalScope {
-				c.emit(code.OpSetGlobal, tempSymbol.Index)
-			} else {
-				c.emit(code.OpSetLocal, tempSymbol.Index)
-			}
-
-			for i, v := range p.Pattern {
-				// TODO support nested pattern match
-				name := v.(*ast.Identifier).Value
-				symbol := c.symbolTable.Define(name)
-
-				c.loadSymbol(tempSymbol) // FIXME better way?
-
-				constIndex := c.addConstant(&object.Integer{Value: int64(i)})
-				c.emit(code.OpConstant, constIndex)
-				c.emit(code.OpIndex)
-
-				if symbol.Scope == GlobalScope {
-					c.emit(code.OpSetGlobal, symbol.Index)
-				} else {
-					c.emit(code.OpSetLocal, symbol.Index)
-				}
-			}
-		case *ast.HashPattern:
+			c.emit(code.OpPop)
+		default:
 			err := c.Compile(node.Value)
 			if err != nil {
 				return err
 			}
 
-			tempSymbol := c.symbolTable.Define("$") // FIXME better way?
-			if tempSymbol.Scope == GlobalScope {
-				c.emit(code.OpSetGlobal, tempSymbol.Index)
-			} else {
-				c.emit(code.OpSetLocal, tempSymbol.Index)
-			}
-
-			for _, e := range p.Pattern {
-				name := e.Value
-				symbol := c.symbolTable.Define(name)
-
-				c.loadSymbol(tempSymbol) // FIXME better way?
+			tempSymbol := c.symbolTable.defineTemporary()
+			c.setSymbol(tempSymbol)
 
-				constIndex := c.addConstant(&object.String{Value: name})
-				c.emit(code.OpConstant, constIndex)
-				c.emit(code.OpIndex)
-
-				if symbol.Scope == GlobalScope {
-					c.emit(code.OpSetGlobal, symbol.Index)
-				} else {
-					c.emit(code.OpSetLocal, symbol.Index)
-				}
+			err = c.compileDestructuring(tempSymbol, p)
+			if err != nil {
+				return err
 			}
-
-		default:
-			panic("unsupported pattern")
 		}
+	case *ast.ExportStatement:
+		return fmt.Errorf("export is only allowed at the top level")
+	case *ast.ImportExpression:
+		return c.compileImport(node)
 	case *ast.ReturnStatement:
 		err := c.Compile(node.ReturnValue)
 		if err != nil {
@@ -171,6 +135,22 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpGreaterThan)
 			return nil
 		}
+		if node.Operator == "??" {
+			err := c.Compile(node.Left)
+			if err != nil {
+				return err
+			}
+			jumpNotNullPos := c.emit(code.OpJumpNotNull, 9999)
+			err = c.Compile(node.Right)
+			if err != nil {
+				return err
+			}
+			c.changeOperand(jumpNotNullPos, len(c.currentInstructions()))
+			return nil
+		}
+		if call, ok := node.PipelineCall(); ok {
+			return c.Compile(call)
+		}
 		if node.Operator == "|" {
 			err := c.Compile(node.Right)
 			if err != nil {
@@ -206,9 +186,13 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpEqual)
 		case "!=":
 			c.emit(code.OpNotEqual)
+		case ">>":
+			c.emit(code.OpCompose)
 		default:
 			return fmt.Errorf("unknown operator %s", node.Operator)
 		}
+	case *ast.MatchExpression:
+		return c.compileMatchExpression(node)
 	case *ast.IfExpression:
 		err := c.Compile(node.Condition)
 		if err != nil {
@@ -260,28 +244,15 @@ func (c *Compiler) Compile(node ast.Node) error {
 		default:
 			return fmt.Errorf("unknown operator %s", node.Operator)
 		}
-	case *ast.IndexExpression:
-		err := c.Compile(node.Left)
-		if err != nil {
-			return err
-		}
-		err = c.Compile(node.Index)
-		if err != nil {
-			return err
-		}
-		c.emit(code.OpIndex)
 	case *ast.CallExpression:
-		err := c.Compile(node.Function)
-		if err != nil {
-			return err
-		}
-		for _, a := range node.Arguments {
-			err := c.Compile(a)
-			if err != nil {
-				return err
-			}
+		if node.Function.TokenLiteral() == "quote" {
+			return c.compileQuote(node)
 		}
-		c.emit(code.OpCall, len(node.Arguments))
+		return c.compileChain(node)
+	case *ast.MethodCallExpression:
+		return c.compileChain(node)
+	case *ast.IndexExpression:
+		return c.compileChain(node)
 	case *ast.Identifier:
 		symbol, ok := c.symbolTable.Resolve(node.Value)
 		if !ok {
@@ -294,15 +265,22 @@ func (c *Compiler) Compile(node ast.Node) error {
 		} else {
 			c.emit(code.OpFalse)
 		}
+	case *ast.NullLiteral:
+		c.emit(code.OpNull)
 	case *ast.NumberLiteral:
-		i, err := strconv.ParseInt(node.Value, 10, 64)
+		number, err := object.ParseNumber(node.Value)
 		if err != nil {
 			return err
 		}
-		integer := &object.Integer{Value: i}
-		c.emit(code.OpConstant, c.addConstant(integer))
+		c.emit(code.OpConstant, c.addConstant(number))
 	case *ast.StringLiteral:
 		c.emit(code.OpConstant, c.addConstant(&object.String{Value: node.Value}))
+	case *ast.SpreadExpression:
+		err := c.Compile(node.Value)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpSpread)
 	case *ast.ArrayLiteral:
 		for _, e := range node.Elements {
 			err := c.Compile(e)
@@ -326,31 +304,28 @@ func (c *Compiler) Compile(node ast.Node) error {
 				c.symbolTable.numDefinitions++
 			}
 		}
+		if node.Rest != nil {
+			c.symbolTable.Define(node.Rest.Value)
+		}
 
+		numDefaults := 0
 		for i, p := range node.Parameters {
-			switch v := p.(type) {
-			case *ast.ArrayPattern:
-				for index, pattern := range v.Pattern {
-					// TODO support nested pattern match
-					ident := pattern.(*ast.Identifier)
-					symbol := c.symbolTable.Define(ident.Value)
-
-					c.emit(code.OpGetLocal, i)
-					constIndex := c.addConstant(&object.Integer{Value: int64(index)})
-					c.emit(code.OpConstant, constIndex)
-					c.emit(code.OpIndex)
-					c.emit(code.OpSetLocal, symbol.Index)
-				}
-			case *ast.HashPattern:
-				for _, ident := range v.Pattern {
-					symbol := c.symbolTable.Define(ident.Value)
-
-					c.emit(code.OpGetLocal, i)
-					constIndex := c.addConstant(&object.String{Value: ident.Value})
-					c.emit(code.OpConstant, constIndex)
-					c.emit(code.OpIndex)
-					c.emit(code.OpSetLocal, symbol.Index)
+			if d := node.Default(i); d != nil {
+				numDefaults++
+				jumpPos := c.emit(code.OpJumpArgGiven, i, 9999)
+				err := c.Compile(d)
+				if err != nil {
+					return err
 				}
+				c.emit(code.OpSetLocal, i)
+				c.replaceInstruction(jumpPos, code.Make(code.OpJumpArgGiven, i, len(c.currentInstructions())))
+			}
+			if _, ok := p.(*ast.Identifier); ok {
+				continue
+			}
+			err := c.compileDestructuring(Symbol{Scope: LocalScope, Index: i}, p)
+			if err != nil {
+				return err
 			}
 		}
 
@@ -378,26 +353,19 @@ func (c *Compiler) Compile(node ast.Node) error {
 			Instructions:  instructions,
 			NumLocals:     numLocals,
 			NumParameters: len(node.Parameters),
+			NumDefaults:   numDefaults,
+			Variadic:      node.Rest != nil,
 		}
 		fnIndex := c.addConstant(compiledFn)
 		c.emit(code.OpClosure, fnIndex, len(freeSymbols))
 	case *ast.HashLiteral:
-		keys := []ast.Expression{}
-		for k := range node.Pairs {
-			keys = append(keys, k)
-		}
-
-		sort.Slice(keys, func(i, j int) bool {
-			return keys[i].String() < keys[j].String()
-		})
-
-		for _, k := range keys {
-			err := c.Compile(k)
+		for _, p := range node.Pairs {
+			err := c.Compile(p.Key)
 			if err != nil {
 				return err
 			}
 
-			err = c.Compile(node.Pairs[k])
+			err = c.Compile(p.Value)
 			if err != nil {
 				return err
 			}
@@ -481,6 +449,27 @@ func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
 	}
 }
 
+// compileQuote turns `quote(node)` into a constant. Unquoting needs to
+// evaluate code while quoting and is only done by macro expansion and the
+// interpreter.
+func (c *Compiler) compileQuote(call *ast.CallExpression) error {
+	if len(call.Arguments) != 1 {
+		return fmt.Errorf("wrong number of arguments to quote. got=%d, want=1", len(call.Arguments))
+	}
+	unquoted := false
+	ast.Inspect(call.Arguments[0], func(node ast.Node) bool {
+		if call, ok := node.(*ast.CallExpression); ok && call.Function.TokenLiteral() == "unquote" {
+			unquoted = true
+		}
+		return !unquoted
+	})
+	if unquoted {
+		return fmt.Errorf("unquote is not supported outside of macros")
+	}
+	c.emit(code.OpConstant, c.addConstant(&object.Quote{Node: call.Arguments[0]}))
+	return nil
+}
+
 func (c *Compiler) changeOperand(opPos int, operand int) {
 	op := code.Opcode(c.currentInstructions()[opPos])
 	newInstruction := code.Make(op, operand)
@@ -522,3 +511,79 @@ type Bytecode struct {
 	Instructions code.Instructions
 	Constants    []object.Object
 }
+
+// compileChain compiles node, the last link of a chain of calls and
+// indexes such as `a?.b.c()`, in which an optional link finding null jumps
+// to the end of the chain, so that the whole chain is null.
+func (c *Compiler) compileChain(node ast.Expression) error {
+	nullJumps := []int{}
+	if err := c.compileChainLink(node, &nullJumps); err != nil {
+		return err
+	}
+	for _, pos := range nullJumps {
+		c.changeOperand(pos, len(c.currentInstructions()))
+	}
+	return nil
+}
+
+// compileChainLink compiles node as a link of a chain, adding the jumps of
+// the optional links to nullJumps.
+func (c *Compiler) compileChainLink(node ast.Expression, nullJumps *[]int) error {
+	// target compiles the expression a link applies to, which continues
+	// the chain
+	target := func(e ast.Expression, optional bool) error {
+		var err error
+		switch e := e.(type) {
+		case *ast.CallExpression:
+			if e.Function.TokenLiteral() == "quote" {
+				err = c.compileQuote(e)
+			} else {
+				err = c.compileChainLink(e, nullJumps)
+			}
+		case *ast.MethodCallExpression, *ast.IndexExpression:
+			err = c.compileChainLink(e, nullJumps)
+		default:
+			err = c.Compile(e)
+		}
+		if err != nil {
+			return err
+		}
+		if optional {
+			*nullJumps = append(*nullJumps, c.emit(code.OpJumpNull, 9999))
+		}
+		return nil
+	}
+
+	switch node := node.(type) {
+	case *ast.IndexExpression:
+		if err := target(node.Left, node.Optional); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Index); err != nil {
+			return err
+		}
+		c.emit(code.OpIndex)
+	case *ast.CallExpression:
+		if err := target(node.Function, node.Optional); err != nil {
+			return err
+		}
+		for _, a := range node.Arguments {
+			if err := c.Compile(a); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpCall, len(node.Arguments))
+	case *ast.MethodCallExpression:
+		if err := target(node.Receiver, node.Optional); err != nil {
+			return err
+		}
+		for _, a := range node.Arguments {
+			if err := c.Compile(a); err != nil {
+				return err
+			}
+		}
+		name := &object.String{Value: node.Method.Value}
+		c.emit(code.OpMethodCall, c.addConstant(name), len(node.Arguments))
+	}
+	return nil
+}
@@ -59,6 +59,8 @@ func TestPipelineOperator(t *testing.T) {
 				0,
 				1,
 				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpDestructureArray, 2),
 					code.Make(code.OpGetLocal, 0),
 					code.Make(code.OpConstant, 0),
 					code.Make(code.OpIndex),
@@ -90,6 +92,309 @@ func TestPipelineOperator(t *testing.T) {
 	runCompilerTests(t, tests)
 }
 
+func TestMatchExpression(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `
+				match (1) { 1 => 2, _ => 3 };
+			`,
+			expectedConstants: []interface{}{1, 1, 2, 3},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpSetGlobal, 0),
+				// 0006
+				code.Make(code.OpGetGlobal, 0),
+				// 0009
+				code.Make(code.OpMatchValue, 1),
+				// 0012
+				code.Make(code.OpJumpNotTruthy, 18),
+				// 0015
+				code.Make(code.OpJump, 21),
+				// 0018
+				code.Make(code.OpJump, 27),
+				// 0021
+				code.Make(code.OpConstant, 2),
+				// 0024
+				code.Make(code.OpJump, 33),
+				// 0027
+				code.Make(code.OpConstant, 3),
+				// 0030
+				code.Make(code.OpJump, 33),
+				// 0033
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `
+				match ([1]) { [x] => x };
+			`,
+			expectedConstants: []interface{}{1, 0},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpArray, 1),
+				// 0006
+				code.Make(code.OpSetGlobal, 0),
+				// 0009
+				code.Make(code.OpGetGlobal, 0),
+				// 0012
+				code.Make(code.OpMatchArray, 1),
+				// 0015
+				code.Make(code.OpJumpNotTruthy, 31),
+				// 0018
+				code.Make(code.OpGetGlobal, 0),
+				// 0021
+				code.Make(code.OpConstant, 1),
+				// 0024
+				code.Make(code.OpIndex),
+				// 0025
+				code.Make(code.OpSetGlobal, 1),
+				// 0028
+				code.Make(code.OpJump, 35),
+				// 0031
+				code.Make(code.OpGetGlobal, 0),
+				// 0034
+				code.Make(code.OpNoMatch),
+				// 0035
+				code.Make(code.OpGetGlobal, 1),
+				// 0038
+				code.Make(code.OpJump, 41),
+				// 0041
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+func TestMatchExpressionSharesTests(t *testing.T) {
+	program := parse(`
+		match ([1, 2]) { [1, a] => a, [2, b] => b, [3, c] if c > 1 => c, [_, d] => d };
+	`)
+	compiler := New()
+	err := compiler.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	ins := compiler.Bytecode().Instructions
+	counts := map[code.Opcode]int{}
+	for i := 0; i < len(ins); {
+		def, err := code.Lookup(ins[i])
+		if err != nil {
+			t.Fatalf("lookup failed: %s", err)
+		}
+		counts[code.Opcode(ins[i])]++
+		_, read := code.ReadOperands(def, ins[i+1:])
+		i += 1 + read
+	}
+
+	if counts[code.OpMatchArray] != 1 {
+		t.Errorf("array shape should be checked once. got=%d", counts[code.OpMatchArray])
+	}
+	if counts[code.OpMatchValue] != 3 {
+		t.Errorf("wrong number of literal checks. want=3, got=%d", counts[code.OpMatchValue])
+	}
+}
+
+func TestTemporariesAreAnonymous(t *testing.T) {
+	program := parse(`
+		match (1) { x => x };
+		let [a] = [2];
+		let b = 3;
+	`)
+	compiler := New()
+	if err := compiler.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	if s, ok := compiler.symbolTable.Resolve("$"); ok {
+		t.Errorf("expected no symbol $, got=%+v", s)
+	}
+	// the matched value, x, the destructured value and a take the slots
+	// before b
+	if s, _ := compiler.symbolTable.Resolve("b"); s.Index != 4 {
+		t.Errorf("expected b at 4, got=%+v", s)
+	}
+}
+
+func TestNullishAndOptionalChaining(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "null ?? 1",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpNull),
+				// 0001
+				code.Make(code.OpJumpNotNull, 7),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "null?.[1]",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpNull),
+				// 0001
+				code.Make(code.OpJumpNull, 8),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
+				code.Make(code.OpIndex),
+				// 0008
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "null?.(1)",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpNull),
+				// 0001
+				code.Make(code.OpJumpNull, 9),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
+				code.Make(code.OpCall, 1),
+				// 0009
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+func TestPipelineCallAndCompose(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `1 | push(2, _)`,
+			expectedConstants: []interface{}{2, 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpGetBuiltin, 5),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpCall, 2),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `1 | push(2)`,
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpGetBuiltin, 5),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpCall, 2),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `len >> first`,
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpGetBuiltin, 0),
+				code.Make(code.OpGetBuiltin, 2),
+				code.Make(code.OpCompose),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+func TestDefaultParametersAndSpread(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `fn(a, b = 1) { b }`,
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpJumpArgGiven, 1, 9),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSetLocal, 1),
+					code.Make(code.OpGetLocal, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `[...[1], 2]`,
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpSpread),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpArray, 2),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+func TestQuote(t *testing.T) {
+	program := parse(`quote(1 + 2)`)
+	compiler := New()
+	err := compiler.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := compiler.Bytecode()
+	if len(bytecode.Constants) != 1 {
+		t.Fatalf("wrong number of constants. got=%d", len(bytecode.Constants))
+	}
+	quote, ok := bytecode.Constants[0].(*object.Quote)
+	if !ok {
+		t.Fatalf("constant is not Quote. got=%T", bytecode.Constants[0])
+	}
+	if quote.Node.String() != "(1 + 2)" {
+		t.Errorf("wrong quoted node. got=%q", quote.Node.String())
+	}
+
+	err = New().Compile(parse(`quote(unquote(1))`))
+	if err == nil || err.Error() != "unquote is not supported outside of macros" {
+		t.Errorf("wrong error for unquote. got=%v", err)
+	}
+}
+
+func TestMethodCalls(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `"abc".len()`,
+			expectedConstants: []interface{}{"abc", "len"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpMethodCall, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `[].push(1)`,
+			expectedConstants: []interface{}{1, "push"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpArray, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpMethodCall, 1, 1),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
 func TestLetStatementWithArrayPattern(t *testing.T) {
 	tests := []compilerTestCase{
 		{
@@ -109,7 +414,9 @@ func TestLetStatementWithArrayPattern(t *testing.T) {
 
 				code.Make(code.OpSetGlobal, 0),
 				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpDestructureArray, 2),
 
+				code.Make(code.OpGetGlobal, 0),
 				code.Make(code.OpConstant, 2),
 				code.Make(code.OpIndex),
 				code.Make(code.OpSetGlobal, 1),
@@ -135,6 +442,7 @@ func TestLetStatementWithHashPattern(t *testing.T) {
 				1,
 				"y",
 				2,
+				[]string{"x", "y"},
 				"x",
 				"y",
 			},
@@ -148,12 +456,15 @@ func TestLetStatementWithHashPattern(t *testing.T) {
 				code.Make(code.OpSetGlobal, 0),
 
 				code.Make(code.OpGetGlobal, 0),
-				code.Make(code.OpConstant, 4),
+				code.Make(code.OpDestructureHash, 4),
+
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 5),
 				code.Make(code.OpIndex),
 				code.Make(code.OpSetGlobal, 1),
 
 				code.Make(code.OpGetGlobal, 0),
-				code.Make(code.OpConstant, 5),
+				code.Make(code.OpConstant, 6),
 				code.Make(code.OpIndex),
 				code.Make(code.OpSetGlobal, 2),
 			},
@@ -172,6 +483,8 @@ func TestFunctionWithArrayPattern(t *testing.T) {
 				0,
 				1,
 				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpDestructureArray, 2),
 					code.Make(code.OpGetLocal, 0),
 					code.Make(code.OpConstant, 0),
 					code.Make(code.OpIndex),
@@ -201,6 +514,8 @@ func TestFunctionWithArrayPattern(t *testing.T) {
 				0,
 				1,
 				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpDestructureArray, 2),
 					code.Make(code.OpGetLocal, 0),
 					code.Make(code.OpConstant, 0),
 					code.Make(code.OpIndex),
@@ -235,16 +550,19 @@ func TestFunctionWithHashPattern(t *testing.T) {
 				fn({x, y}) { x + y };
 			`,
 			expectedConstants: []interface{}{
+				[]string{"x", "y"},
 				"x",
 				"y",
 				[]code.Instructions{
 					code.Make(code.OpGetLocal, 0),
-					code.Make(code.OpConstant, 0),
+					code.Make(code.OpDestructureHash, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 1),
 					code.Make(code.OpIndex),
 					code.Make(code.OpSetLocal, 1),
 
 					code.Make(code.OpGetLocal, 0),
-					code.Make(code.OpConstant, 1),
+					code.Make(code.OpConstant, 2),
 					code.Make(code.OpIndex),
 					code.Make(code.OpSetLocal, 2),
 
@@ -255,7 +573,7 @@ func TestFunctionWithHashPattern(t *testing.T) {
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpClosure, 3, 0),
 				code.Make(code.OpPop),
 			},
 		},
@@ -264,16 +582,19 @@ func TestFunctionWithHashPattern(t *testing.T) {
 				fn({x, y}, z) { x + y + z };
 			`,
 			expectedConstants: []interface{}{
+				[]string{"x", "y"},
 				"x",
 				"y",
 				[]code.Instructions{
 					code.Make(code.OpGetLocal, 0),
-					code.Make(code.OpConstant, 0),
+					code.Make(code.OpDestructureHash, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 1),
 					code.Make(code.OpIndex),
 					code.Make(code.OpSetLocal, 2),
 
 					code.Make(code.OpGetLocal, 0),
-					code.Make(code.OpConstant, 1),
+					code.Make(code.OpConstant, 2),
 					code.Make(code.OpIndex),
 					code.Make(code.OpSetLocal, 3),
 
@@ -286,7 +607,7 @@ func TestFunctionWithHashPattern(t *testing.T) {
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpClosure, 3, 0),
 				code.Make(code.OpPop),
 			},
 		},
@@ -770,6 +1091,20 @@ func TestHashLiterals(t *testing.T) {
 				code.Make(code.OpPop),
 			},
 		},
+		{
+			input:             "{5: 6, 1: 2, 3: 4}",
+			expectedConstants: []interface{}{5, 6, 1, 2, 3, 4},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpConstant, 4),
+				code.Make(code.OpConstant, 5),
+				code.Make(code.OpHash, 6),
+				code.Make(code.OpPop),
+			},
+		},
 		{
 			input:             "{1: 2 + 3, 4: 5 * 6}",
 			expectedConstants: []interface{}{1, 2, 3, 4, 5, 6},
@@ -1260,6 +1595,16 @@ func testConstants(
 			if err != nil {
 				return fmt.Errorf("constant %d - testStringObject failed: %w", i, err)
 			}
+		case []string:
+			array, ok := actual[i].(*object.Array)
+			if !ok || len(array.Elements) != len(constant) {
+				return fmt.Errorf("constant %d - not an array of %d elements: %T (%+v)", i, len(constant), actual[i], actual[i])
+			}
+			for j, s := range constant {
+				if err := testStringObject(s, array.Elements[j]); err != nil {
+					return fmt.Errorf("constant %d - element %d - testStringObject failed: %w", i, j, err)
+				}
+			}
 		case []code.Instructions:
 			fn, ok := actual[i].(*object.CompiledFunction)
 			if !ok {
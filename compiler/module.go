@@ -0,0 +1,72 @@
+package compiler
+
+import (
+	"fmt"
+
+	"github.com/wreulicke/monkey/ast"
+	"github.com/wreulicke/monkey/code"
+	"github.com/wreulicke/monkey/object"
+)
+
+// compiledModule is what the module loader caches for the compiler: the
+// constant holding the function running the module, and the global its
+// exports are stored in once it has run.
+type compiledModule struct {
+	constIndex  int
+	globalIndex int
+}
+
+// compileImport compiles the imported module into a function returning the
+// hash of its exports, and emits an OpImport running it the first time.
+func (c *Compiler) compileImport(node *ast.ImportExpression) error {
+	if c.ctx == nil || c.ctx.Modules == nil {
+		return fmt.Errorf("cannot import %q: modules are not available", node.Path.Value)
+	}
+	v, err := c.ctx.Modules.Import(node.Path.Value, c.ctx.File, c.compileModule)
+	if err != nil {
+		return err
+	}
+	m := v.(compiledModule)
+	c.emit(code.OpImport, m.constIndex, m.globalIndex)
+	return nil
+}
+
+func (c *Compiler) compileModule(file string, program *ast.Program) (interface{}, error) {
+	symbolTable := newModuleSymbolTable(c.symbolTable)
+	module := &Compiler{
+		constants:   c.constants,
+		symbolTable: symbolTable,
+		scopes:      []CompilationScope{{}},
+		ctx:         c.ctx.ForModule(file),
+	}
+	err := module.Compile(program)
+	// modules imported before an error stay loaded and refer to their
+	// constants from then on
+	c.constants = module.constants
+	if err != nil {
+		return nil, err
+	}
+
+	numExports := 0
+	for _, s := range program.Statements {
+		export, ok := s.(*ast.ExportStatement)
+		if !ok {
+			continue
+		}
+		for _, name := range export.Names() {
+			symbol, _ := module.symbolTable.Resolve(name.Value)
+			module.emit(code.OpConstant, module.addConstant(&object.String{Value: name.Value}))
+			module.loadSymbol(symbol)
+			numExports++
+		}
+	}
+	module.emit(code.OpHash, numExports*2)
+	module.emit(code.OpReturnValue)
+
+	c.constants = module.constants
+	fn := &object.CompiledFunction{Instructions: module.currentInstructions()}
+	return compiledModule{
+		constIndex:  c.addConstant(fn),
+		globalIndex: c.symbolTable.globalTable().allocate("").Index,
+	}, nil
+}
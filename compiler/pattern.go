@@ -0,0 +1,384 @@
+package compiler
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/wreulicke/monkey/ast"
+	"github.com/wreulicke/monkey/code"
+	"github.com/wreulicke/monkey/object"
+)
+
+// patternBinding is an identifier bound by a pattern, together with the
+// indexes leading from the destructured value to the bound value.
+type patternBinding struct {
+	name string
+	path []object.Object
+}
+
+type testKind int
+
+const (
+	// testArray checks that the value is an array of length arg.
+	testArray testKind = iota
+	// testHash checks that the value is a hash.
+	testHash
+	// testKey checks that the hash contains the key arg.
+	testKey
+	// testLiteral checks that the value equals the literal arg.
+	testLiteral
+)
+
+// patternTest is a single check performed on the value found at path.
+type patternTest struct {
+	kind testKind
+	path []object.Object
+	arg  object.Object
+}
+
+func (t patternTest) samePath(o patternTest) bool {
+	return pathKey(t.path) == pathKey(o.path)
+}
+
+func (t patternTest) equals(o patternTest) bool {
+	return t.kind == o.kind && t.samePath(o) && valueKey(t.arg) == valueKey(o.arg)
+}
+
+// contradicts reports whether o can never succeed once t has succeeded.
+func (t patternTest) contradicts(o patternTest) bool {
+	if !t.samePath(o) || t.equals(o) {
+		return false
+	}
+	switch t.kind {
+	case testArray:
+		return o.kind != testArray || valueKey(t.arg) != valueKey(o.arg)
+	case testHash:
+		return o.kind == testArray || o.kind == testLiteral
+	case testLiteral:
+		return true
+	}
+	return false
+}
+
+func pathKey(path []object.Object) string {
+	key := ""
+	for _, step := range path {
+		key += "/" + valueKey(step)
+	}
+	return key
+}
+
+func valueKey(o object.Object) string {
+	switch o := o.(type) {
+	case *object.String:
+		return "s" + strconv.Quote(o.Value)
+	case nil:
+		return ""
+	default:
+		return o.Type().String() + ":" + o.Inspect()
+	}
+}
+
+func extendPath(path []object.Object, step object.Object) []object.Object {
+	extended := make([]object.Object, len(path), len(path)+1)
+	copy(extended, path)
+	return append(extended, step)
+}
+
+// collectBindings lists the identifiers bound by p in source order.
+func collectBindings(p ast.Pattern, path []object.Object, bindings []patternBinding) ([]patternBinding, error) {
+	switch p := p.(type) {
+	case *ast.Identifier:
+		return append(bindings, patternBinding{name: p.Value, path: path}), nil
+	case *ast.WildcardPattern, *ast.NumberLiteral, *ast.StringLiteral, *ast.BooleanLiteral, *ast.NullLiteral:
+		return bindings, nil
+	case *ast.ArrayPattern:
+		var err error
+		for i, e := range p.Pattern {
+			bindings, err = collectBindings(e, extendPath(path, &object.Integer{Value: int64(i)}), bindings)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return bindings, nil
+	case *ast.HashPattern:
+		var err error
+		for i, key := range p.Pattern {
+			bindings, err = collectBindings(p.ValuePattern(i), extendPath(path, &object.String{Value: key.Value}), bindings)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return bindings, nil
+	}
+	return nil, fmt.Errorf("unsupported pattern %s", p.String())
+}
+
+// collectTests lists the checks a value has to pass to match p. A check on
+// a nested value always comes after the checks on its container.
+func collectTests(p ast.Pattern, path []object.Object, tests []patternTest) ([]patternTest, error) {
+	switch p := p.(type) {
+	case *ast.Identifier, *ast.WildcardPattern:
+		return tests, nil
+	case *ast.NumberLiteral:
+		number, err := object.ParseNumber(p.Value)
+		if err != nil {
+			return nil, err
+		}
+		return append(tests, patternTest{kind: testLiteral, path: path, arg: number}), nil
+	case *ast.StringLiteral:
+		return append(tests, patternTest{kind: testLiteral, path: path, arg: &object.String{Value: p.Value}}), nil
+	case *ast.BooleanLiteral:
+		return append(tests, patternTest{kind: testLiteral, path: path, arg: &object.Boolean{Value: p.Value}}), nil
+	case *ast.NullLiteral:
+		return append(tests, patternTest{kind: testLiteral, path: path, arg: &object.Null{}}), nil
+	case *ast.ArrayPattern:
+		tests = append(tests, patternTest{kind: testArray, path: path, arg: &object.Integer{Value: int64(len(p.Pattern))}})
+		var err error
+		for i, e := range p.Pattern {
+			tests, err = collectTests(e, extendPath(path, &object.Integer{Value: int64(i)}), tests)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return tests, nil
+	case *ast.HashPattern:
+		tests = append(tests, patternTest{kind: testHash, path: path})
+		for _, key := range p.Pattern {
+			tests = append(tests, patternTest{kind: testKey, path: path, arg: &object.String{Value: key.Value}})
+		}
+		var err error
+		for i, key := range p.Pattern {
+			tests, err = collectTests(p.ValuePattern(i), extendPath(path, &object.String{Value: key.Value}), tests)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return tests, nil
+	}
+	return nil, fmt.Errorf("unsupported pattern %s", p.String())
+}
+
+// matchRow is an arm of a match expression with the checks it still needs.
+type matchRow struct {
+	tests []patternTest
+	arm   int
+}
+
+// decisionNode is a node of the decision tree a match expression compiles to.
+// Inner nodes perform test and continue with yes or no. Leaves select arm, or
+// report that nothing matched when arm is negative; a guarded leaf continues
+// with no when its guard is falsy.
+type decisionNode struct {
+	test *patternTest
+	yes  *decisionNode
+	no   *decisionNode
+	arm  int
+}
+
+// buildDecisionTree turns the rows into a tree in which every check on a
+// value is performed at most once on each path from the root.
+func buildDecisionTree(rows []matchRow, guarded []bool) *decisionNode {
+	if len(rows) == 0 {
+		return &decisionNode{arm: -1}
+	}
+	first := rows[0]
+	if len(first.tests) == 0 {
+		node := &decisionNode{arm: first.arm}
+		if guarded[first.arm] {
+			node.no = buildDecisionTree(rows[1:], guarded)
+		}
+		return node
+	}
+
+	test := first.tests[0]
+	yes := []matchRow{}
+	no := []matchRow{}
+	for _, row := range rows {
+		required := false
+		contradicted := false
+		remaining := []patternTest{}
+		for _, t := range row.tests {
+			switch {
+			case test.equals(t):
+				required = true
+			case test.contradicts(t):
+				contradicted = true
+			default:
+				remaining = append(remaining, t)
+			}
+		}
+		if !contradicted {
+			yes = append(yes, matchRow{tests: remaining, arm: row.arm})
+		}
+		if !required {
+			no = append(no, row)
+		}
+	}
+	return &decisionNode{
+		test: &test,
+		yes:  buildDecisionTree(yes, guarded),
+		no:   buildDecisionTree(no, guarded),
+	}
+}
+
+func (c *Compiler) compileMatchExpression(node *ast.MatchExpression) error {
+	err := c.Compile(node.Value)
+	if err != nil {
+		return err
+	}
+	root := c.symbolTable.defineTemporary()
+	c.setSymbol(root)
+
+	rows := make([]matchRow, len(node.Arms))
+	guarded := make([]bool, len(node.Arms))
+	bindings := make([][]patternBinding, len(node.Arms))
+	symbols := make([][]Symbol, len(node.Arms))
+	for i, arm := range node.Arms {
+		tests, err := collectTests(arm.Pattern, nil, nil)
+		if err != nil {
+			return err
+		}
+		rows[i] = matchRow{tests: tests, arm: i}
+		guarded[i] = arm.Guard != nil
+
+		bindings[i], err = collectBindings(arm.Pattern, nil, nil)
+		if err != nil {
+			return err
+		}
+		for _, b := range bindings[i] {
+			symbols[i] = append(symbols[i], c.symbolTable.allocate(b.name))
+		}
+	}
+
+	armJumps := make([][]int, len(node.Arms))
+	var emitNode func(n *decisionNode) error
+	emitNode = func(n *decisionNode) error {
+		if n.test != nil {
+			c.emitPatternTest(root, *n.test)
+			jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+			if err := emitNode(n.yes); err != nil {
+				return err
+			}
+			c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+			return emitNode(n.no)
+		}
+		if n.arm < 0 {
+			c.loadSymbol(root)
+			c.emit(code.OpNoMatch)
+			return nil
+		}
+
+		for i, b := range bindings[n.arm] {
+			c.loadPath(root, b.path)
+			c.setSymbol(symbols[n.arm][i])
+		}
+		guard := node.Arms[n.arm].Guard
+		if guard == nil {
+			armJumps[n.arm] = append(armJumps[n.arm], c.emit(code.OpJump, 9999))
+			return nil
+		}
+
+		restore := c.symbolTable.shadow(symbols[n.arm])
+		err := c.Compile(guard)
+		restore()
+		if err != nil {
+			return err
+		}
+		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+		armJumps[n.arm] = append(armJumps[n.arm], c.emit(code.OpJump, 9999))
+		c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+		return emitNode(n.no)
+	}
+	if err := emitNode(buildDecisionTree(rows, guarded)); err != nil {
+		return err
+	}
+
+	endJumps := []int{}
+	for i, arm := range node.Arms {
+		for _, pos := range armJumps[i] {
+			c.changeOperand(pos, len(c.currentInstructions()))
+		}
+		restore := c.symbolTable.shadow(symbols[i])
+		err := c.Compile(arm.Body)
+		restore()
+		if err != nil {
+			return err
+		}
+		endJumps = append(endJumps, c.emit(code.OpJump, 9999))
+	}
+	for _, pos := range endJumps {
+		c.changeOperand(pos, len(c.currentInstructions()))
+	}
+	return nil
+}
+
+func (c *Compiler) emitPatternTest(root Symbol, t patternTest) {
+	c.loadPath(root, t.path)
+	switch t.kind {
+	case testArray:
+		c.emit(code.OpMatchArray, int(t.arg.(*object.Integer).Value))
+	case testHash:
+		c.emit(code.OpMatchHash)
+	case testKey:
+		c.emit(code.OpMatchKey, c.addConstant(t.arg))
+	case testLiteral:
+		c.emit(code.OpMatchValue, c.addConstant(t.arg))
+	}
+}
+
+// compileDestructuring binds the identifiers of p to the parts of the value
+// stored in root.
+func (c *Compiler) compileDestructuring(root Symbol, p ast.Pattern) error {
+	bindings, err := collectBindings(p, nil, nil)
+	if err != nil {
+		return err
+	}
+	c.checkDestructuring(root, p, nil)
+	for _, b := range bindings {
+		symbol := c.symbolTable.Define(b.name)
+		c.loadPath(root, b.path)
+		c.setSymbol(symbol)
+	}
+	return nil
+}
+
+// checkDestructuring emits the checks failing the program unless p can
+// destructure the value found at path from root, the checks of containers
+// coming before the checks of their elements.
+func (c *Compiler) checkDestructuring(root Symbol, p ast.Pattern, path []object.Object) {
+	switch p := p.(type) {
+	case *ast.ArrayPattern:
+		c.loadPath(root, path)
+		c.emit(code.OpDestructureArray, len(p.Pattern))
+		for i, e := range p.Pattern {
+			c.checkDestructuring(root, e, extendPath(path, &object.Integer{Value: int64(i)}))
+		}
+	case *ast.HashPattern:
+		keys := make([]object.Object, len(p.Pattern))
+		for i, key := range p.Pattern {
+			keys[i] = &object.String{Value: key.Value}
+		}
+		c.loadPath(root, path)
+		c.emit(code.OpDestructureHash, c.addConstant(&object.Array{Elements: keys}))
+		for i, key := range p.Pattern {
+			c.checkDestructuring(root, p.ValuePattern(i), extendPath(path, &object.String{Value: key.Value}))
+		}
+	}
+}
+
+func (c *Compiler) loadPath(root Symbol, path []object.Object) {
+	c.loadSymbol(root)
+	for _, step := range path {
+		c.emit(code.OpConstant, c.addConstant(step))
+		c.emit(code.OpIndex)
+	}
+}
+
+func (c *Compiler) setSymbol(s Symbol) {
+	if s.Scope == GlobalScope {
+		c.emit(code.OpSetGlobal, s.Index)
+	} else {
+		c.emit(code.OpSetLocal, s.Index)
+	}
+}
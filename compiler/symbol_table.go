@@ -1,5 +1,7 @@
 package compiler
 
+import "github.com/wreulicke/monkey/object"
+
 type SymbolScope string
 
 const (
@@ -22,12 +24,36 @@ type SymbolTable struct {
 	store          map[string]Symbol
 	numDefinitions int
 	FreeSymbols    []Symbol
+
+	// numGlobals counts the globals allocated by a program and the modules
+	// it imports, which share the global slots.
+	numGlobals *int
+
+	// builtins resolve the names the global table does not define.
+	builtins *object.Registry
 }
 
 func NewSymbolTable() *SymbolTable {
 	s := make(map[string]Symbol)
 	free := []Symbol{}
-	return &SymbolTable{store: s, FreeSymbols: free}
+	return &SymbolTable{store: s, FreeSymbols: free, numGlobals: new(int)}
+}
+
+// newModuleSymbolTable returns the global table of a module imported by
+// the program whose tables include s.
+func newModuleSymbolTable(s *SymbolTable) *SymbolTable {
+	module := NewSymbolTable()
+	module.numGlobals = s.globalTable().numGlobals
+	module.builtins = s.globalTable().builtins
+	return module
+}
+
+// globalTable returns the outermost table enclosing s.
+func (s *SymbolTable) globalTable() *SymbolTable {
+	for s.Outer != nil {
+		s = s.Outer
+	}
+	return s
 }
 
 func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
@@ -37,23 +63,67 @@ func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
 }
 
 func (s *SymbolTable) Define(name string) Symbol {
+	symbol := s.allocate(name)
+	s.store[name] = symbol
+	return symbol
+}
+
+// allocate reserves a slot for name without making it resolvable.
+func (s *SymbolTable) allocate(name string) Symbol {
 	symbol := Symbol{Name: name, Index: s.numDefinitions}
 	if s.Outer == nil {
 		symbol.Scope = GlobalScope
+		symbol.Index = *s.numGlobals
+		*s.numGlobals++
 	} else {
 		symbol.Scope = LocalScope
 	}
-	s.store[name] = symbol
 	s.numDefinitions++
 	return symbol
 }
 
+// defineTemporary reserves a slot for a value the compiled code keeps
+// while it runs, such as the value being matched, which no name resolves
+// to.
+func (s *SymbolTable) defineTemporary() Symbol {
+	return s.allocate("")
+}
+
+// shadow makes symbols resolvable until the returned function is called,
+// which restores what their names resolved to before.
+func (s *SymbolTable) shadow(symbols []Symbol) func() {
+	previous := map[string]Symbol{}
+	for _, symbol := range symbols {
+		if _, ok := previous[symbol.Name]; ok {
+			s.store[symbol.Name] = symbol
+			continue
+		}
+		previous[symbol.Name] = s.store[symbol.Name]
+		s.store[symbol.Name] = symbol
+	}
+	return func() {
+		for name, symbol := range previous {
+			if symbol.Scope == "" {
+				delete(s.store, name)
+			} else {
+				s.store[name] = symbol
+			}
+		}
+	}
+}
+
 func (s *SymbolTable) DefineBuiltin(index int, name string) Symbol {
 	symbol := Symbol{Name: name, Index: index, Scope: BuiltinScope}
 	s.store[name] = symbol
 	return symbol
 }
 
+// SetBuiltins makes the names of the builtins of r resolvable in the tables
+// enclosed in the global table of s, unless they define them.
+func (s *SymbolTable) SetBuiltins(r *object.Registry) {
+	s.globalTable().builtins = r
+}
+
 func (s *SymbolTable) DefineFunctionName(name string) Symbol {
 	symbol := Symbol{Name: name, Index: 0, Scope: FunctionScope}
 	s.store[name] = symbol
@@ -83,5 +153,10 @@ func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
 		free := s.defineFree(obj)
 		return free, true
 	}
+	if !ok && s.builtins != nil {
+		if index, found := s.builtins.Index(name); found {
+			return Symbol{Name: name, Index: index, Scope: BuiltinScope}, true
+		}
+	}
 	return obj, ok
 }
@@ -1,6 +1,10 @@
 package compiler
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/wreulicke/monkey/object"
+)
 
 func TestShadowingFunctionName(t *testing.T) {
 	global := NewSymbolTable()
@@ -206,6 +210,39 @@ func TestDefineResolveBuiltins(t *testing.T) {
 	}
 }
 
+func TestResolveRegistryBuiltins(t *testing.T) {
+	registry := object.NewRegistry()
+	registry.Define("a", object.NullValue)
+	registry.Define("b", object.NullValue)
+	global := NewSymbolTable()
+	global.SetBuiltins(registry)
+	global.Define("b")
+	local := NewEnclosedSymbolTable(global)
+	module := newModuleSymbolTable(local)
+	expected := map[string]Symbol{
+		"a": {Name: "a", Scope: BuiltinScope, Index: 0},
+		"b": {Name: "b", Scope: GlobalScope, Index: 0},
+	}
+	for _, table := range []*SymbolTable{global, local} {
+		for name, sym := range expected {
+			result, ok := table.Resolve(name)
+			if !ok || result != sym {
+				t.Errorf("expected %s to resolve to %+v, got=%+v", name, sym, result)
+			}
+		}
+		if _, ok := table.Resolve("c"); ok {
+			t.Errorf("c resolvable")
+		}
+	}
+	if result, ok := module.Resolve("a"); !ok || result != expected["a"] {
+		t.Errorf("expected a to resolve to %+v in a module, got=%+v", expected["a"], result)
+	}
+	registry.Define("c", object.NullValue)
+	if result, ok := local.Resolve("c"); !ok || result.Index != 2 {
+		t.Errorf("expected c defined later to resolve to index 2, got=%+v", result)
+	}
+}
+
 func TestResolveGlobal(t *testing.T) {
 	global := NewSymbolTable()
 	global.Define("a")
@@ -0,0 +1,388 @@
+package monkey
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+
+	"github.com/wreulicke/monkey/object"
+)
+
+var (
+	objectType  = reflect.TypeOf((*object.Object)(nil)).Elem()
+	builtinType = reflect.TypeOf(object.BuiltinFunction(nil))
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+	bytesType   = reflect.TypeOf([]byte(nil))
+)
+
+// ToObject converts a Go value to a Monkey object. Integers, floats,
+// strings, booleans and nil become the objects of the same kind, byte
+// slices strings, slices and arrays arrays, and maps hashes, ordered by
+// their keys. Pointers and interfaces are converted by what they point to,
+// and objects are kept as they are.
+//
+// Functions of the type of object.BuiltinFunction become builtins as they
+// are. Other functions become builtins converting their arguments by FromObject and
+// their results by ToObject. A function returning several values returns
+// an array of them; a last error result is not returned but fails the call
+// when not nil. A function a builtin takes as an argument calls the Monkey
+// function given for it, and may only be called while the builtin runs.
+func ToObject(v interface{}) (object.Object, error) {
+	return toObject(reflect.ValueOf(v))
+}
+
+func toObject(v reflect.Value) (object.Object, error) {
+	if !v.IsValid() {
+		return object.NullValue, nil
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice, reflect.Func:
+		if v.IsNil() {
+			return object.NullValue, nil
+		}
+	}
+	if v.Type().Implements(objectType) {
+		return v.Interface().(object.Object), nil
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		return object.NativeBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &object.Integer{Value: v.Int()}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > math.MaxInt64 {
+			return nil, fmt.Errorf("%d is out of the range of INTEGER", v.Uint())
+		}
+		return &object.Integer{Value: int64(v.Uint())}, nil
+	case reflect.Float32, reflect.Float64:
+		return &object.Float{Value: v.Float()}, nil
+	case reflect.String:
+		return &object.String{Value: v.String()}, nil
+	case reflect.Slice, reflect.Array:
+		if v.Type() == bytesType {
+			return &object.String{Value: string(v.Bytes())}, nil
+		}
+		elements := make([]object.Object, v.Len())
+		for i := range elements {
+			e, err := toObject(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = e
+		}
+		return &object.Array{Elements: elements}, nil
+	case reflect.Map:
+		return mapToHash(v)
+	case reflect.Func:
+		if v.Type().ConvertibleTo(builtinType) {
+			return &object.Builtin{Fn: v.Convert(builtinType).Interface().(object.BuiltinFunction)}, nil
+		}
+		return funcToBuiltin(v), nil
+	case reflect.Ptr, reflect.Interface:
+		return toObject(v.Elem())
+	}
+	return nil, fmt.Errorf("cannot convert %s to a Monkey value", v.Type())
+}
+
+func mapToHash(v reflect.Value) (object.Object, error) {
+	pairs := make([]object.HashPair, 0, v.Len())
+	iter := v.MapRange()
+	for iter.Next() {
+		key, err := toObject(iter.Key())
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := key.(object.Hashable); !ok {
+			return nil, fmt.Errorf("cannot use %s as a hash key", object.TypeName(key))
+		}
+		value, err := toObject(iter.Value())
+		if err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, object.HashPair{Key: key, Value: value})
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].Key.Inspect() < pairs[j].Key.Inspect()
+	})
+	hash := object.NewHash()
+	for _, pair := range pairs {
+		hash.Set(pair.Key.(object.Hashable).HashKey(), pair)
+	}
+	return hash, nil
+}
+
+// builtinOf converts the Go function fn to a builtin, and returns the
+// number of arguments it takes.
+func builtinOf(fn interface{}) (*object.Builtin, object.Arity, error) {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return nil, object.Arity{}, fmt.Errorf("cannot use %T as a function", fn)
+	}
+	o, err := toObject(v)
+	if err != nil {
+		return nil, object.Arity{}, err
+	}
+	t := v.Type()
+	switch {
+	case t.ConvertibleTo(builtinType):
+		return o.(*object.Builtin), object.AnyArity, nil
+	case t.IsVariadic():
+		return o.(*object.Builtin), object.Arity{Min: t.NumIn() - 1, Max: object.Variadic}, nil
+	}
+	return o.(*object.Builtin), object.Arity{Min: t.NumIn(), Max: t.NumIn()}, nil
+}
+
+func funcToBuiltin(fn reflect.Value) *object.Builtin {
+	t := fn.Type()
+	return &object.Builtin{Fn: func(rt object.Runtime, args ...object.Object) object.Object {
+		numIn := t.NumIn()
+		if t.IsVariadic() {
+			if len(args) < numIn-1 {
+				return newError("wrong number of arguments: want at least %d, got=%d", numIn-1, len(args))
+			}
+		} else if len(args) != numIn {
+			return newError("wrong number of arguments: want=%d, got=%d", numIn, len(args))
+		}
+		in := make([]reflect.Value, len(args))
+		for i, arg := range args {
+			var pt reflect.Type
+			if t.IsVariadic() && i >= numIn-1 {
+				pt = t.In(numIn - 1).Elem()
+			} else {
+				pt = t.In(i)
+			}
+			v, err := fromObject(rt, arg, pt)
+			if err != nil {
+				return newError("argument %d: %s", i, err)
+			}
+			in[i] = v
+		}
+		out := fn.Call(in)
+		if n := len(out); n > 0 && t.Out(n-1) == errorType {
+			if err := out[n-1]; !err.IsNil() {
+				return &object.Error{Message: err.Interface().(error).Error(), Err: err.Interface().(error)}
+			}
+			out = out[:n-1]
+		}
+		results := make([]object.Object, len(out))
+		for i, v := range out {
+			o, err := toObject(v)
+			if err != nil {
+				return newError("result %d: %s", i, err)
+			}
+			results[i] = o
+		}
+		switch len(results) {
+		case 0:
+			return nil
+		case 1:
+			return results[0]
+		}
+		return &object.Array{Elements: results}
+	}}
+}
+
+// FromObject converts o to a Go value of type t: the reverse of ToObject,
+// with integers also converting to floats. For interface{}, o is converted
+// by ToGo, and for other interfaces it must implement them. A Go
+// function calls the Monkey function o through rt, and returns the zero
+// values, and the error when it returns one, when the call fails. The
+// error of a canceled program is an *object.CanceledError, which the
+// function should return so that the program stops.
+func FromObject(rt object.Runtime, o object.Object, t reflect.Type) (interface{}, error) {
+	v, err := fromObject(rt, o, t)
+	if err != nil {
+		return nil, err
+	}
+	return v.Interface(), nil
+}
+
+func fromObject(rt object.Runtime, o object.Object, t reflect.Type) (reflect.Value, error) {
+	if t.Kind() == reflect.Interface && t.NumMethod() == 0 {
+		if v := ToGo(o); v != nil {
+			return reflect.ValueOf(v), nil
+		}
+		return reflect.Zero(t), nil
+	}
+	if reflect.TypeOf(o).AssignableTo(t) {
+		return reflect.ValueOf(o), nil
+	}
+	if o == object.NullValue {
+		switch t.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice, reflect.Func:
+			return reflect.Zero(t), nil
+		}
+	}
+	v := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.Bool:
+		if o, ok := o.(*object.Boolean); ok {
+			v.SetBool(o.Value)
+			return v, nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if o, ok := o.(*object.Integer); ok {
+			if v.OverflowInt(o.Value) {
+				return v, fmt.Errorf("%d is out of the range of %s", o.Value, t)
+			}
+			v.SetInt(o.Value)
+			return v, nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if o, ok := o.(*object.Integer); ok {
+			if o.Value < 0 || v.OverflowUint(uint64(o.Value)) {
+				return v, fmt.Errorf("%d is out of the range of %s", o.Value, t)
+			}
+			v.SetUint(uint64(o.Value))
+			return v, nil
+		}
+	case reflect.Float32, reflect.Float64:
+		switch o := o.(type) {
+		case *object.Integer:
+			v.SetFloat(float64(o.Value))
+			return v, nil
+		case *object.Float:
+			v.SetFloat(o.Value)
+			return v, nil
+		}
+	case reflect.String:
+		if o, ok := o.(*object.String); ok {
+			v.SetString(o.Value)
+			return v, nil
+		}
+	case reflect.Slice:
+		if o, ok := o.(*object.String); ok && t == bytesType {
+			return reflect.ValueOf([]byte(o.Value)), nil
+		}
+		if o, ok := o.(*object.Array); ok {
+			v = reflect.MakeSlice(t, len(o.Elements), len(o.Elements))
+			for i, e := range o.Elements {
+				ev, err := fromObject(rt, e, t.Elem())
+				if err != nil {
+					return v, err
+				}
+				v.Index(i).Set(ev)
+			}
+			return v, nil
+		}
+	case reflect.Map:
+		if o, ok := o.(*object.Hash); ok {
+			v = reflect.MakeMapWithSize(t, len(o.Pairs))
+			for _, pair := range o.OrderedPairs() {
+				key, err := fromObject(rt, pair.Key, t.Key())
+				if err != nil {
+					return v, err
+				}
+				value, err := fromObject(rt, pair.Value, t.Elem())
+				if err != nil {
+					return v, err
+				}
+				v.SetMapIndex(key, value)
+			}
+			return v, nil
+		}
+	case reflect.Func:
+		switch o.Type() {
+		case object.FUNCTION, object.CLOSURE, object.BUILTIN:
+			return callbackOf(rt, o, t), nil
+		}
+	}
+	return v, fmt.Errorf("cannot use %s as %s", object.TypeName(o), t)
+}
+
+// callbackOf returns a Go function of type t calling fn through rt.
+func callbackOf(rt object.Runtime, fn object.Object, t reflect.Type) reflect.Value {
+	return reflect.MakeFunc(t, func(in []reflect.Value) []reflect.Value {
+		out := make([]reflect.Value, t.NumOut())
+		for i := range out {
+			out[i] = reflect.Zero(t.Out(i))
+		}
+		fail := func(err error) []reflect.Value {
+			if n := len(out); n > 0 && t.Out(n-1) == errorType {
+				out[n-1] = reflect.ValueOf(&err).Elem()
+			}
+			return out
+		}
+		args := make([]object.Object, len(in))
+		for i, v := range in {
+			arg, err := toObject(v)
+			if err != nil {
+				return fail(err)
+			}
+			args[i] = arg
+		}
+		result := rt.Call(fn, args...)
+		if err, ok := result.(*object.Error); ok {
+			if err.Canceled() {
+				return fail(err.Err)
+			}
+			rt.Recover()
+			return fail(fmt.Errorf("%s", err.Message))
+		}
+		if len(out) > 0 && t.Out(0) != errorType {
+			v, err := fromObject(rt, result, t.Out(0))
+			if err != nil {
+				return fail(err)
+			}
+			out[0] = v
+		}
+		return out
+	})
+}
+
+// ToGo converts o to a Go value: integers to int64, floats to float64,
+// strings to string, booleans to bool, null to nil, arrays to
+// []interface{}, and hashes to map[string]interface{} when their keys are
+// all strings, map[interface{}]interface{} otherwise. Other objects, such
+// as functions, are returned as they are.
+func ToGo(o object.Object) interface{} {
+	switch o := o.(type) {
+	case *object.Integer:
+		return o.Value
+	case *object.Float:
+		return o.Value
+	case *object.String:
+		return o.Value
+	case *object.Boolean:
+		return o.Value
+	case *object.Null:
+		return nil
+	case *object.Array:
+		elements := make([]interface{}, len(o.Elements))
+		for i, e := range o.Elements {
+			elements[i] = ToGo(e)
+		}
+		return elements
+	case *object.Hash:
+		pairs := o.OrderedPairs()
+		byName := make(map[string]interface{}, len(pairs))
+		for _, pair := range pairs {
+			key, ok := pair.Key.(*object.String)
+			if !ok {
+				return hashToMap(pairs)
+			}
+			byName[key.Value] = ToGo(pair.Value)
+		}
+		return byName
+	}
+	return o
+}
+
+// hashToMap converts the pairs of a hash with keys other than strings.
+// Arrays, which a Go map cannot take as keys, are kept as objects.
+func hashToMap(pairs []object.HashPair) map[interface{}]interface{} {
+	m := make(map[interface{}]interface{}, len(pairs))
+	for _, pair := range pairs {
+		var key interface{} = pair.Key
+		if _, ok := pair.Key.(*object.Array); !ok {
+			key = ToGo(pair.Key)
+		}
+		m[key] = ToGo(pair.Value)
+	}
+	return m
+}
+
+func newError(format string, a ...interface{}) *object.Error {
+	return &object.Error{Message: fmt.Sprintf(format, a...)}
+}
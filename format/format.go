@@ -0,0 +1,407 @@
+// Package format prints Monkey source in its canonical form.
+//
+// Blocks are indented with tabs, expressions get only the parentheses they
+// need, and argument lists, arrays and hashes not fitting in MaxWidth
+// columns are wrapped one element per line. Comments and single blank lines
+// between statements are kept. Formatting formatted source does not change
+// it.
+package format
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/wreulicke/monkey/ast"
+	"github.com/wreulicke/monkey/lexer"
+	"github.com/wreulicke/monkey/parser"
+	"github.com/wreulicke/monkey/token"
+)
+
+// MaxWidth is the column after which lists are wrapped.
+const MaxWidth = 80
+
+// tabWidth is the number of columns a tab counts as when measuring lines.
+const tabWidth = 4
+
+// Source formats the Monkey program src.
+func Source(src []byte) ([]byte, error) {
+	p := parser.New(lexer.New(bytes.NewReader(src)))
+	program := p.Parse()
+	if errs := p.Errors(); len(errs) != 0 {
+		return nil, errs[0]
+	}
+
+	pr := &printer{
+		lines:    strings.Split(string(src), "\n"),
+		comments: program.Comments,
+	}
+	out := pr.statements(program.Statements, token.Position{}, true)
+	if out == "" {
+		return nil, nil
+	}
+	return []byte(out + "\n"), nil
+}
+
+type printer struct {
+	// lines are the lines of the source, used to find blank lines and
+	// comments following code on the same line.
+	lines []string
+	// comments are the comments not printed yet.
+	comments []*ast.Comment
+	// depth is the number of blocks around what is being printed.
+	depth int
+	// col is the number of columns taken on the current line by the
+	// statement before the expression being printed.
+	col int
+}
+
+// statements prints a statement list followed by the comments before end,
+// one per line. The last statement of a block ends without a semicolon.
+func (p *printer) statements(stmts []ast.Statement, end token.Position, semicolonLast bool) string {
+	col := p.col
+	defer func() { p.col = col }()
+
+	var out bytes.Buffer
+	for i, s := range stmts {
+		pos := startOf(s)
+		for _, c := range p.commentsBefore(pos) {
+			p.writeLine(&out, c.Token.Pos.Line, c.Text())
+		}
+
+		text := p.statement(s)
+		if _, ok := s.(*ast.ExpressionStatement); !ok || semicolonLast || i < len(stmts)-1 {
+			text += ";"
+		}
+		next := end
+		if i < len(stmts)-1 {
+			next = startOf(stmts[i+1])
+		}
+		if len(p.comments) > 0 && before(p.comments[0].Token.Pos, next) && p.isTrailing(p.comments[0]) {
+			text += " " + p.comments[0].Text()
+			p.comments = p.comments[1:]
+		}
+		p.writeLine(&out, pos.Line, text)
+	}
+	for _, c := range p.commentsBefore(end) {
+		p.writeLine(&out, c.Token.Pos.Line, c.Text())
+	}
+	return strings.TrimSuffix(out.String(), "\n")
+}
+
+// writeLine writes text found at line of the source, preceded by a blank
+// line when one precedes it in the source.
+func (p *printer) writeLine(out *bytes.Buffer, line int, text string) {
+	if out.Len() > 0 && line >= 2 && line-2 < len(p.lines) && strings.TrimSpace(p.lines[line-2]) == "" {
+		out.WriteRune('\n')
+	}
+	out.WriteString(text)
+	out.WriteRune('\n')
+}
+
+// commentsBefore removes and returns the comments before pos.
+func (p *printer) commentsBefore(pos token.Position) []*ast.Comment {
+	i := 0
+	for i < len(p.comments) && before(p.comments[i].Token.Pos, pos) {
+		i++
+	}
+	comments := p.comments[:i]
+	p.comments = p.comments[i:]
+	return comments
+}
+
+// isTrailing reports whether c follows code on its line.
+func (p *printer) isTrailing(c *ast.Comment) bool {
+	pos := c.Token.Pos
+	if pos.Line > len(p.lines) {
+		return false
+	}
+	line := p.lines[pos.Line-1]
+	if pos.Column-1 > len(line) {
+		return false
+	}
+	return strings.TrimSpace(line[:pos.Column-1]) != ""
+}
+
+// before reports whether a comes before b. An invalid b is after every
+// position.
+func before(a, b token.Position) bool {
+	if !b.IsValid() {
+		return true
+	}
+	return a.Line < b.Line || a.Line == b.Line && a.Column < b.Column
+}
+
+func startOf(s ast.Statement) token.Position {
+	switch s := s.(type) {
+	case *ast.LetStatement:
+		return s.Token.Pos
+	case *ast.ExportStatement:
+		return s.Token.Pos
+	case *ast.ReturnStatement:
+		return s.Token.Pos
+	case *ast.ExpressionStatement:
+		return s.Token.Pos
+	}
+	return token.Position{}
+}
+
+func (p *printer) statement(s ast.Statement) string {
+	switch s := s.(type) {
+	case *ast.LetStatement:
+		return p.let("let ", s)
+	case *ast.ExportStatement:
+		return p.let("export let ", s.Statement)
+	case *ast.ReturnStatement:
+		p.col = len("return ")
+		return "return " + p.expression(s.ReturnValue)
+	case *ast.ExpressionStatement:
+		p.col = 0
+		return p.expression(s.Expression)
+	case *ast.BlockStatement:
+		return p.block(s)
+	}
+	panic(fmt.Sprintf("format: unexpected statement %T", s))
+}
+
+func (p *printer) let(keyword string, s *ast.LetStatement) string {
+	prefix := keyword + p.pattern(s.Pattern) + " = "
+	p.col = width(prefix)
+	return prefix + p.expression(s.Value)
+}
+
+// block prints a block. Lines after the first are indented relative to the
+// line the block starts on, the caller indents them further.
+func (p *printer) block(b *ast.BlockStatement) string {
+	p.depth++
+	body := p.statements(b.Statements, b.Rbrace, false)
+	p.depth--
+	if body == "" {
+		return "{}"
+	}
+	return "{\n" + indent(body) + "\n}"
+}
+
+func (p *printer) pattern(pattern ast.Pattern) string {
+	switch pattern := pattern.(type) {
+	case *ast.ArrayPattern:
+		elements := []string{}
+		for _, e := range pattern.Pattern {
+			elements = append(elements, p.pattern(e))
+		}
+		return "[" + strings.Join(elements, ", ") + "]"
+	case *ast.HashPattern:
+		keys := []string{}
+		for i, key := range pattern.Pattern {
+			if i < len(pattern.Values) && pattern.Values[i] != nil {
+				keys = append(keys, key.Value+": "+p.pattern(pattern.Values[i]))
+			} else {
+				keys = append(keys, key.Value)
+			}
+		}
+		return "{" + strings.Join(keys, ", ") + "}"
+	case *ast.WildcardPattern:
+		return "_"
+	case ast.Expression:
+		return p.expression(pattern)
+	}
+	panic(fmt.Sprintf("format: unexpected pattern %T", pattern))
+}
+
+func (p *printer) expression(e ast.Expression) string {
+	switch e := e.(type) {
+	case *ast.Identifier:
+		return e.Value
+	case *ast.NumberLiteral:
+		return e.Value
+	case *ast.StringLiteral:
+		return quote(e.Value)
+	case *ast.BooleanLiteral:
+		return strconv.FormatBool(e.Value)
+	case *ast.NullLiteral:
+		return "null"
+	case *ast.PrefixExpression:
+		return e.Operator + p.operand(e.Right, parser.PREFIX)
+	case *ast.InfixExpression:
+		prec := infixPrecedence(e)
+		return p.operand(e.Left, prec) + " " + e.Operator + " " + p.operand(e.Right, prec+1)
+	case *ast.CallExpression:
+		optional := ""
+		if e.Optional {
+			optional = "?."
+		}
+		return p.list(p.operand(e.Function, parser.CALL)+optional+"(", p.expressions(e.Arguments), ")")
+	case *ast.MethodCallExpression:
+		dot := "."
+		if e.Optional {
+			dot = "?."
+		}
+		return p.list(p.operand(e.Receiver, parser.CALL)+dot+e.Method.Value+"(", p.expressions(e.Arguments), ")")
+	case *ast.IndexExpression:
+		left := p.operand(e.Left, parser.CALL)
+		if s, ok := e.Index.(*ast.StringLiteral); ok && s.Token.Type == token.IDENT && isName(s.Value) {
+			if e.Optional {
+				return left + "?." + s.Value
+			}
+			return left + "." + s.Value
+		}
+		if e.Optional {
+			left += "?."
+		}
+		return left + "[" + p.expression(e.Index) + "]"
+	case *ast.SpreadExpression:
+		return "..." + p.expression(e.Value)
+	case *ast.ImportExpression:
+		return "import " + quote(e.Path.Value)
+	case *ast.ArrayLiteral:
+		return p.list("[", p.expressions(e.Elements), "]")
+	case *ast.HashLiteral:
+		pairs := []string{}
+		for _, pair := range e.Pairs {
+			pairs = append(pairs, p.expression(pair.Key)+": "+p.expression(pair.Value))
+		}
+		return p.list("{", pairs, "}")
+	case *ast.FunctionLiteral:
+		params := []string{}
+		for i, param := range e.Parameters {
+			if d := e.Default(i); d != nil {
+				params = append(params, p.pattern(param)+" = "+p.expression(d))
+			} else {
+				params = append(params, p.pattern(param))
+			}
+		}
+		if e.Rest != nil {
+			params = append(params, "..."+e.Rest.Value)
+		}
+		return "fn(" + strings.Join(params, ", ") + ") " + p.block(e.Body)
+	case *ast.MacroLiteral:
+		params := []string{}
+		for _, param := range e.Parameters {
+			params = append(params, param.Value)
+		}
+		return "macro(" + strings.Join(params, ", ") + ") " + p.block(e.Body)
+	case *ast.IfExpression:
+		out := "if (" + p.expression(e.Condition) + ") " + p.block(e.Consequence)
+		if e.Alternative != nil {
+			out += " else " + p.block(e.Alternative)
+		}
+		return out
+	case *ast.MatchExpression:
+		value := p.expression(e.Value)
+		if len(e.Arms) == 0 {
+			return "match (" + value + ") {}"
+		}
+		p.depth++
+		arms := []string{}
+		for _, arm := range e.Arms {
+			text := p.pattern(arm.Pattern)
+			if arm.Guard != nil {
+				text += " if " + p.expression(arm.Guard)
+			}
+			arms = append(arms, text+" => "+p.expression(arm.Body))
+		}
+		p.depth--
+		return "match (" + value + ") {\n" + indent(strings.Join(arms, ",\n")) + "\n}"
+	}
+	panic(fmt.Sprintf("format: unexpected expression %T", e))
+}
+
+func (p *printer) expressions(expressions []ast.Expression) []string {
+	result := []string{}
+	for _, e := range expressions {
+		result = append(result, p.expression(e))
+	}
+	return result
+}
+
+// operand prints e, parenthesized unless it binds at least as tightly as
+// prec.
+func (p *printer) operand(e ast.Expression, prec parser.Precedence) string {
+	if precedenceOf(e) < prec {
+		return "(" + p.expression(e) + ")"
+	}
+	return p.expression(e)
+}
+
+// precedenceOf returns how tightly e binds. Expressions other than operators
+// are never split by an operator around them.
+func precedenceOf(e ast.Expression) parser.Precedence {
+	switch e := e.(type) {
+	case *ast.InfixExpression:
+		return infixPrecedence(e)
+	case *ast.PrefixExpression:
+		return parser.PREFIX
+	case *ast.CallExpression, *ast.MethodCallExpression, *ast.IndexExpression:
+		return parser.CALL
+	}
+	return parser.INDEX + 1
+}
+
+func infixPrecedence(e *ast.InfixExpression) parser.Precedence {
+	t := e.Token.Type
+	if t == token.ILLEGAL || e.Token.Literal != e.Operator {
+		// built without the parser, e.g. by macro expansion
+		t = lexer.New(strings.NewReader(e.Operator)).NextToken().Type
+	}
+	return parser.PrecedenceOf(t)
+}
+
+// list prints elements between open and close, on one line when it fits and
+// one element per line otherwise. Only the last element may span lines
+// without wrapping the list, as in `map(xs, fn(x) { ... })`.
+func (p *printer) list(open string, elements []string, close string) string {
+	oneLine := open + strings.Join(elements, ", ") + close
+	fits := p.depth*tabWidth+p.col+width(oneLine) <= MaxWidth
+	for i := 0; fits && i < len(elements)-1; i++ {
+		fits = !strings.Contains(elements[i], "\n")
+	}
+	if fits || len(elements) == 0 {
+		return oneLine
+	}
+	return open + "\n" + indent(strings.Join(elements, ",\n")) + "\n" + close
+}
+
+// width returns the number of columns of the first line of s.
+func width(s string) int {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		s = s[:i]
+	}
+	return len(s) + strings.Count(s, "\t")*(tabWidth-1)
+}
+
+func indent(s string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = "\t" + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// isName reports whether the lexer reads s as a single identifier or
+// keyword, either of which names a member after a dot.
+func isName(s string) bool {
+	for i, r := range s {
+		if i == 0 && !(r == '_' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z') || i > 0 && !unicode.IsLetter(r) && !('0' <= r && r <= '9') {
+			return false
+		}
+	}
+	return s != ""
+}
+
+var escapes = strings.NewReplacer(
+	`\`, `\\`,
+	`"`, `\"`,
+	"\b", `\b`,
+	"\f", `\f`,
+	"\n", `\n`,
+	"\r", `\r`,
+	"\t", `\t`,
+)
+
+func quote(s string) string {
+	return `"` + escapes.Replace(s) + `"`
+}
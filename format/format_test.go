@@ -0,0 +1,108 @@
+package format
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/wreulicke/monkey/lexer"
+	"github.com/wreulicke/monkey/parser"
+)
+
+func TestSource(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let   x=1", "let x = 1;\n"},
+		{"1 + 2 * 3; (1 + 2) * 3; 1 - (2 - 3); (1 - 2) - 3", "1 + 2 * 3;\n(1 + 2) * 3;\n1 - (2 - 3);\n1 - 2 - 3;\n"},
+		{"-(a + b); (-a)[0]; -a[0]; !(a == b); (a + b)(c)", "-(a + b);\n(-a)[0];\n-a[0];\n!(a == b);\n(a + b)(c);\n"},
+		{"x | f(_, 1) | g; (f >> g)(1); a ?? (b ?? c)", "x | f(_, 1) | g;\n(f >> g)(1);\na ?? (b ?? c);\n"},
+		{`h.a; h?.b(1); h["a b"]; h?.["c"]; h?.c; 'a"b'`, "h.a;\nh?.b(1);\nh[\"a b\"];\nh?.[\"c\"];\nh?.c;\n\"a\\\"b\";\n"},
+		{
+			"let add = fn(a, b = 1, ...rest) { let c = a + b; c }",
+			"let add = fn(a, b = 1, ...rest) {\n\tlet c = a + b;\n\tc\n};\n",
+		},
+		{"fn() {}; if (x) { 1 } else { 2 }", "fn() {};\nif (x) {\n\t1\n} else {\n\t2\n};\n"},
+		{
+			"match (x) { 1 => \"one\", [a, _] if a > 0 => a, {k: v, w} => v, _ => null, }",
+			"match (x) {\n\t1 => \"one\",\n\t[a, _] if a > 0 => a,\n\t{k: v, w} => v,\n\t_ => null\n};\n",
+		},
+		{"let [a, {b, c: [d]}] = [1, {}]; f(...xs, ...[1])", "let [a, {b, c: [d]}] = [1, {}];\nf(...xs, ...[1]);\n"},
+		{"let m = macro(a) { quote(unquote(a)) }", "let m = macro(a) {\n\tquote(unquote(a))\n};\n"},
+		{
+			"let result = someFunction(argumentNumberOne, argumentNumberTwo, argumentNumberThree);",
+			"let result = someFunction(\n\targumentNumberOne,\n\targumentNumberTwo,\n\targumentNumberThree\n);\n",
+		},
+		{
+			"map(xs, fn(x) { x * 2 }); [fn() { 1 }, 2]",
+			"map(xs, fn(x) {\n\tx * 2\n});\n[\n\tfn() {\n\t\t1\n\t},\n\t2\n];\n",
+		},
+		{
+			"// head\n\n\nlet a = 1; // one\n// before b\nlet b = 2;\n\nlet f = fn() {\n  // inside\n  a\n  // end\n};\n// tail",
+			"// head\n\nlet a = 1; // one\n// before b\nlet b = 2;\n\nlet f = fn() {\n\t// inside\n\ta\n\t// end\n};\n// tail\n",
+		},
+		{"if (x) {\n  // only a comment\n}", "if (x) {\n\t// only a comment\n};\n"},
+		{"let m=import 'm'; export  let {a}=m;(import \"n\").b", "let m = import \"m\";\nexport let {a} = m;\nimport \"n\".b;\n"},
+		{`re.match(s); h?.if; h.log10; h["2x"]; "a\\b"`, "re.match(s);\nh?.if;\nh.log10;\nh[\"2x\"];\n\"a\\\\b\";\n"},
+	}
+
+	for _, tt := range tests {
+		out, err := Source([]byte(tt.input))
+		if err != nil {
+			t.Errorf("Source(%q) failed: %s", tt.input, err)
+			continue
+		}
+		if string(out) != tt.expected {
+			t.Errorf("wrong output for %q.\nwant=%q\ngot =%q", tt.input, tt.expected, out)
+		}
+	}
+}
+
+func TestSourceIsIdempotent(t *testing.T) {
+	tests := []string{
+		`let fib = fn(n) { if (n < 2) { return n; } fib(n - 1) + fib(n - 2) }; puts(fib(10))`,
+		"let h = {\"name\": \"monkey\", \"greet\": fn(x) { \"hello \" + x }, \"values\": [1, 2, 3, 4, 5, 6, 7, 8, 9, 10]};\nh.greet(h.name)",
+		"let deep = fn(a) { fn(b) { fn(c) { someFunction(a, b, c, argumentNumberOne, argumentNumberTwo, three) } } };",
+		"// a\nlet x = 1; // b\n\n\n// c\nx | fn(v) { // d\n v } // e\n",
+		`match (f(1)) { {a: [b, _], c} if b > c => [a, b, c], -1 => "minus", _ => match (x) { null => 0 } }`,
+		`let m = macro(a, b) { quote(unquote(b) - unquote(a)) }; m(1, 2) ?? h?.a?.b`,
+		"f(\n1,\n2\n)\n(3)",
+	}
+
+	for _, input := range tests {
+		once, err := Source([]byte(input))
+		if err != nil {
+			t.Fatalf("Source(%q) failed: %s", input, err)
+		}
+		twice, err := Source(once)
+		if err != nil {
+			t.Fatalf("Source(%q) failed: %s", once, err)
+		}
+		if string(once) != string(twice) {
+			t.Errorf("formatting is not idempotent for %q.\nonce =%q\ntwice=%q", input, once, twice)
+		}
+		if parse(t, input) != parse(t, string(once)) {
+			t.Errorf("formatting changed the program %q.\nwant=%s\ngot =%s", input, parse(t, input), parse(t, string(once)))
+		}
+	}
+}
+
+func TestSourceErrors(t *testing.T) {
+	_, err := Source([]byte("let = 1"))
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+	if err.Error() != "expected pattern, got ASSIGN instead" {
+		t.Errorf("wrong error. got=%q", err.Error())
+	}
+}
+
+func parse(t *testing.T, input string) string {
+	t.Helper()
+	p := parser.New(lexer.New(bytes.NewBufferString(input)))
+	program := p.Parse()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser has errors: %v", p.Errors())
+	}
+	return program.String()
+}
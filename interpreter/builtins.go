@@ -4,11 +4,29 @@ import (
 	"github.com/wreulicke/monkey/object"
 )
 
-var builtins = map[string]*object.Builtin{
-	"len":   object.GetBuiltinByName("len"),
-	"first": object.GetBuiltinByName("first"),
-	"last":  object.GetBuiltinByName("last"),
-	"rest":  object.GetBuiltinByName("rest"),
-	"push":  object.GetBuiltinByName("push"),
-	"puts":  object.GetBuiltinByName("puts"),
+// runtime lets builtins call functions in the interpreter. depth is the
+// number of nested calls of functions the builtin is called in.
+type runtime struct {
+	ctx   *object.Context
+	depth int
+}
+
+// runtimeOf returns the runtime of the code evaluated in env.
+func runtimeOf(env *object.Environment) runtime {
+	return runtime{ctx: env.Context(), depth: env.Depth()}
+}
+
+func (rt runtime) Call(fn object.Object, args ...object.Object) object.Object {
+	if result := callFunction(fn, args, rt); result != nil {
+		return result
+	}
+	return NULL
+}
+
+// Recover does nothing, as a failed call only returns an *Error in the
+// interpreter.
+func (rt runtime) Recover() {}
+
+func (rt runtime) Context() *object.Context {
+	return rt.ctx
 }
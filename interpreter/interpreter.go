@@ -1,19 +1,38 @@
 package interpreter
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"strconv"
 
 	"github.com/wreulicke/monkey/ast"
 	"github.com/wreulicke/monkey/object"
 )
 
+// MaxCallDepth is the number of nested calls of functions a program can
+// make before it fails with a stack overflow, as many as the frames of the
+// VM.
+const MaxCallDepth = 1024
+
 var (
-	TRUE  = &object.Boolean{Value: true}
-	FALSE = &object.Boolean{Value: false}
-	NULL  = &object.Null{}
+	TRUE  = object.True
+	FALSE = object.False
+	NULL  = object.NullValue
 )
 
+// EvalContext evaluates node like Eval, but stops with a
+// *object.CanceledError when ctx is done before the evaluation ends.
+func EvalContext(ctx context.Context, node ast.Node, env *object.Environment) (object.Object, error) {
+	outer := env.Context()
+	env.SetContext(outer.WithDone(ctx))
+	defer env.SetContext(outer)
+	result := Eval(node, env)
+	if err, ok := result.(*object.Error); ok && err.Canceled() {
+		return nil, err.Err
+	}
+	return result, nil
+}
+
 func Eval(node ast.Node, env *object.Environment) object.Object {
 	switch node := node.(type) {
 	case *ast.Program:
@@ -33,8 +52,14 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if isError(val) {
 			return val
 		}
-		bindPattern(env, node.Pattern, val)
+		if err := bindPattern(env, node.Pattern, val); err != nil {
+			return err
+		}
 		return val
+	case *ast.ExportStatement:
+		return newError("export is only allowed at the top level")
+	case *ast.ImportExpression:
+		return evalImportExpression(node, env)
 	case *ast.PrefixExpression:
 		right := Eval(node.Right, env)
 		if isError(right) {
@@ -43,32 +68,42 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		return evalPrefixExpression(node.Operator, right)
 	case *ast.IfExpression:
 		return evalIfExpression(node, env)
+	case *ast.MatchExpression:
+		return evalMatchExpression(node, env)
 	case *ast.InfixExpression:
+		if call, ok := node.PipelineCall(); ok {
+			return Eval(call, env)
+		}
 		left := Eval(node.Left, env)
 		if isError(left) {
 			return left
 		}
+		if node.Operator == "??" {
+			if left.Type() != object.NULL {
+				return left
+			}
+			return Eval(node.Right, env)
+		}
 		right := Eval(node.Right, env)
 		if isError(right) {
 			return right
 		}
-		return evalInfixExpression(node.Operator, left, right)
+		return evalInfixExpression(node.Operator, left, right, runtimeOf(env))
 	case *ast.CallExpression:
-		fn := Eval(node.Function, env)
-		if isError(fn) {
-			return fn
+		if node.Function.TokenLiteral() == "quote" {
+			if len(node.Arguments) != 1 {
+				return newError("wrong number of arguments to quote. got=%d, want=1", len(node.Arguments))
+			}
+			return quote(node.Arguments[0], env)
 		}
-		return evalCallExpression(fn, node.Arguments, env)
+		result, _ := evalChain(node, env)
+		return result
+	case *ast.MethodCallExpression:
+		result, _ := evalChain(node, env)
+		return result
 	case *ast.IndexExpression:
-		left := Eval(node.Left, env)
-		if isError(left) {
-			return left
-		}
-		index := Eval(node.Index, env)
-		if isError(index) {
-			return index
-		}
-		return evalIndexExpression(left, index)
+		result, _ := evalChain(node, env)
+		return result
 	case *ast.ArrayLiteral:
 		elements, err := evalExpressions(node.Elements, env)
 		if err != nil {
@@ -82,18 +117,22 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	case *ast.Identifier:
 		return evalIdentifier(node, env)
 	case *ast.NumberLiteral:
-		i, err := strconv.ParseInt(node.Value, 10, 64)
+		number, err := object.ParseNumber(node.Value)
 		if err != nil {
-			return newError("cannot convert int. %s", node.Value)
+			return newError("cannot convert number. %s", node.Value)
 		}
-		return &object.Integer{Value: i}
+		return number
 	case *ast.BooleanLiteral:
 		return nativeBoolToBooleanObject(node.Value)
+	case *ast.NullLiteral:
+		return NULL
 	case *ast.StringLiteral:
 		return &object.String{Value: node.Value}
 	case *ast.FunctionLiteral:
 		f := &object.Function{
 			Parameters: node.Parameters,
+			Defaults:   node.Defaults,
+			Rest:       node.Rest,
 			Body:       node.Body,
 			Env:        env,
 		}
@@ -106,37 +145,116 @@ func bindPattern(env *object.Environment, pattern ast.Pattern, val object.Object
 	switch node := pattern.(type) {
 	case *ast.Identifier:
 		env.Set(node.Value, val)
+	case *ast.WildcardPattern:
+	case *ast.ArrayPattern:
+		if err := object.CheckArrayPattern(val, len(node.Pattern)); err != nil {
+			return err
+		}
+		array := val.(*object.Array)
+		for idx, v := range node.Pattern {
+			if err := bindPattern(env, v, array.Elements[idx]); err != nil {
+				return err
+			}
+		}
+	case *ast.HashPattern:
+		keys := make([]string, len(node.Pattern))
+		for i, key := range node.Pattern {
+			keys[i] = key.Value
+		}
+		if err := object.CheckHashPattern(val, keys); err != nil {
+			return err
+		}
+		hash := val.(*object.Hash)
+		for i, key := range keys {
+			pair := hash.Pairs[(&object.String{Value: key}).HashKey()]
+			if err := bindPattern(env, node.ValuePattern(i), pair.Value); err != nil {
+				return err
+			}
+		}
+	default:
+		return newError("unsupported pattern: %s", pattern.String())
+	}
+	return nil
+}
+
+// matchPattern reports whether val matches pattern, binding the
+// pattern's identifiers into env as it goes.
+func matchPattern(env *object.Environment, pattern ast.Pattern, val object.Object) bool {
+	switch node := pattern.(type) {
+	case *ast.Identifier:
+		env.Set(node.Value, val)
+		return true
+	case *ast.WildcardPattern:
+		return true
+	case *ast.NumberLiteral, *ast.StringLiteral, *ast.BooleanLiteral, *ast.NullLiteral:
+		return object.Equal(Eval(node, env), val)
 	case *ast.ArrayPattern:
 		array, ok := val.(*object.Array)
-		if !ok {
-			return newError("initializer is not Array. cannot destruct. got=%T", val)
+		if !ok || len(array.Elements) != len(node.Pattern) {
+			return false
 		}
 		for idx, v := range node.Pattern {
-			if idx < len(array.Elements) {
-				bindPattern(env, v, array.Elements[idx])
+			if !matchPattern(env, v, array.Elements[idx]) {
+				return false
 			}
 		}
+		return true
 	case *ast.HashPattern:
 		hash, ok := val.(*object.Hash)
 		if !ok {
-			return newError("initializer is not Hash. cannot destruct. got=%T", val)
-		}
-		for _, v := range node.Pattern {
-			string := object.String{Value: v.String()}
-			hashKey := string.HashKey()
-			pair, ok := hash.Pairs[hashKey]
-			if ok {
-				bindPattern(env, v, pair.Value)
+			return false
+		}
+		for i, v := range node.Pattern {
+			key := object.String{Value: v.Value}
+			pair, ok := hash.Pairs[key.HashKey()]
+			if !ok || !matchPattern(env, node.ValuePattern(i), pair.Value) {
+				return false
 			}
 		}
+		return true
+	}
+	return false
+}
 
+func evalMatchExpression(me *ast.MatchExpression, env *object.Environment) object.Object {
+	val := Eval(me.Value, env)
+	if isError(val) {
+		return val
 	}
-	return nil
+	for _, arm := range me.Arms {
+		armEnv := env.NewEnclosedEnvironment()
+		if !matchPattern(armEnv, arm.Pattern, val) {
+			continue
+		}
+		if arm.Guard != nil {
+			guard := Eval(arm.Guard, armEnv)
+			if isError(guard) {
+				return guard
+			}
+			if !isTruthy(guard) {
+				continue
+			}
+		}
+		return Eval(arm.Body, armEnv)
+	}
+	return newError("no match arm matched value: %s", val.Inspect())
 }
 
 func evalExpressions(expressions []ast.Expression, env *object.Environment) ([]object.Object, object.Object) {
 	var result []object.Object
 	for _, e := range expressions {
+		if spread, ok := e.(*ast.SpreadExpression); ok {
+			evaluated := Eval(spread.Value, env)
+			if isError(evaluated) {
+				return nil, evaluated
+			}
+			array, ok := evaluated.(*object.Array)
+			if !ok {
+				return nil, newError("cannot spread %s", evaluated.Type())
+			}
+			result = append(result, array.Elements...)
+			continue
+		}
 		evaluated := Eval(e, env)
 		if isError(evaluated) {
 			return nil, evaluated
@@ -146,21 +264,101 @@ func evalExpressions(expressions []ast.Expression, env *object.Environment) ([]o
 	return result, nil
 }
 
+// evalChain evaluates node, a link of a chain of calls and indexes such as
+// `a?.b.c()`, and reports whether an optional link found null, which makes
+// the whole chain null rather than failing the links after it.
+func evalChain(node ast.Expression, env *object.Environment) (object.Object, bool) {
+	switch node := node.(type) {
+	case *ast.CallExpression:
+		if node.Function.TokenLiteral() == "quote" {
+			return Eval(node, env), false
+		}
+		fn, null := evalChainLink(node.Function, node.Optional, env)
+		if null || isError(fn) {
+			return fn, null
+		}
+		return evalCallExpression(fn, node.Arguments, env), false
+	case *ast.MethodCallExpression:
+		receiver, null := evalChainLink(node.Receiver, node.Optional, env)
+		if null || isError(receiver) {
+			return receiver, null
+		}
+		return evalMethodCallExpression(receiver, node.Method.Value, node.Arguments, env), false
+	case *ast.IndexExpression:
+		left, null := evalChainLink(node.Left, node.Optional, env)
+		if null || isError(left) {
+			return left, null
+		}
+		index := Eval(node.Index, env)
+		if isError(index) {
+			return index, false
+		}
+		return evalIndexExpression(left, index), false
+	}
+	return Eval(node, env), false
+}
+
+// evalChainLink evaluates the expression a link of a chain applies to, and
+// reports whether the chain is null, because of an earlier link or because
+// the link is optional and the value null.
+func evalChainLink(node ast.Expression, optional bool, env *object.Environment) (object.Object, bool) {
+	value, null := evalChain(node, env)
+	if null {
+		return NULL, true
+	}
+	if optional && value.Type() == object.NULL {
+		return NULL, true
+	}
+	return value, false
+}
+
 func evalCallExpression(fn object.Object, arguments []ast.Expression, env *object.Environment) object.Object {
 	args, err := evalExpressions(arguments, env)
 	if err != nil {
 		return err
 	}
-	return callFunction(fn, args)
+	return callFunction(fn, args, runtimeOf(env))
 }
 
-func callFunction(fn object.Object, args []object.Object) object.Object {
+func evalMethodCallExpression(receiver object.Object, name string, arguments []ast.Expression, env *object.Environment) object.Object {
+	args, err := evalExpressions(arguments, env)
+	if err != nil {
+		return err
+	}
+	fn, args, methodErr := object.ResolveMethod(receiver, name, args)
+	if methodErr != nil {
+		return methodErr
+	}
+	return callFunction(fn, args, runtimeOf(env))
+}
+
+// Apply calls fn with args from outside of a program, giving builtins the
+// context ctx.
+func Apply(fn object.Object, args []object.Object, ctx *object.Context) object.Object {
+	if result := callFunction(fn, args, runtime{ctx: ctx}); result != nil {
+		return result
+	}
+	return NULL
+}
+
+// callFunction calls fn with args from the code of rt, which builtins are
+// given.
+func callFunction(fn object.Object, args []object.Object, rt runtime) object.Object {
+	if err := rt.ctx.Err(); err != nil {
+		return &object.Error{Message: err.Error(), Err: err}
+	}
 	switch fn := fn.(type) {
 	case *object.Function:
-		functionEnv := extendFunctionEnv(fn, args)
+		if rt.depth >= MaxCallDepth {
+			return newError("stack overflow")
+		}
+		functionEnv, err := extendFunctionEnv(fn, args, rt)
+		if err != nil {
+			return err
+		}
 		return unwrapReturnValue(Eval(fn.Body, functionEnv))
 	case *object.Builtin:
-		if result := fn.Fn(args...); result != nil {
+		if result := fn.Fn(rt, args...); result != nil {
 			return result
 		}
 		return NULL
@@ -168,13 +366,46 @@ func callFunction(fn object.Object, args []object.Object) object.Object {
 	return newError("not a function: %s", fn.Type())
 }
 
-func extendFunctionEnv(function *object.Function, args []object.Object) *object.Environment {
+// extendFunctionEnv returns the environment of the body of function called
+// with args from the code of rt.
+func extendFunctionEnv(function *object.Function, args []object.Object, rt runtime) (*object.Environment, *object.Error) {
 	env := function.Env.NewEnclosedEnvironment()
+	env.SetDepth(rt.depth + 1)
+	env.SetContext(function.Env.Context().WithDoneOf(rt.ctx))
+
+	required := 0
+	for paramIdx := range function.Parameters {
+		if function.Default(paramIdx) == nil {
+			required++
+		}
+	}
+	optional := len(function.Parameters) - required
+	if err := object.CheckArity(required, optional, function.Rest != nil, len(args)); err != nil {
+		return nil, err
+	}
 
 	for paramIdx, param := range function.Parameters {
-		bindPattern(env, param, args[paramIdx])
+		var arg object.Object
+		if paramIdx < len(args) {
+			arg = args[paramIdx]
+		} else {
+			arg = Eval(function.Default(paramIdx), env)
+			if err, ok := arg.(*object.Error); ok {
+				return nil, err
+			}
+		}
+		if err := bindPattern(env, param, arg); err != nil {
+			return nil, err
+		}
 	}
-	return env
+	if function.Rest != nil {
+		rest := []object.Object{}
+		if len(args) > len(function.Parameters) {
+			rest = append(rest, args[len(function.Parameters):]...)
+		}
+		env.Set(function.Rest.Value, &object.Array{Elements: rest})
+	}
+	return env, nil
 }
 
 func unwrapReturnValue(o object.Object) object.Object {
@@ -190,6 +421,9 @@ func evalProgram(stmts []ast.Statement, env *object.Environment) object.Object {
 	var result object.Object
 
 	for _, s := range stmts {
+		if export, ok := s.(*ast.ExportStatement); ok {
+			s = export.Statement
+		}
 		result = Eval(s, env)
 		switch v := result.(type) {
 		case *object.ReturnValue:
@@ -203,6 +437,49 @@ func evalProgram(stmts []ast.Statement, env *object.Environment) object.Object {
 
 }
 
+func evalImportExpression(node *ast.ImportExpression, env *object.Environment) object.Object {
+	ctx := env.Context()
+	if ctx == nil || ctx.Modules == nil {
+		return newError("cannot import %q: modules are not available", node.Path.Value)
+	}
+	v, err := ctx.Modules.Import(node.Path.Value, ctx.File, func(file string, program *ast.Program) (interface{}, error) {
+		moduleCtx := ctx.ForModule(file)
+		moduleEnv := object.NewEnvironment()
+		moduleEnv.SetContext(moduleCtx.WithDoneOf(ctx))
+		result := evalProgram(program.Statements, moduleEnv)
+		moduleEnv.SetContext(moduleCtx)
+		if isError(result) {
+			return nil, errors.New(result.(*object.Error).Message)
+		}
+		return exports(program, moduleEnv), nil
+	})
+	if err != nil {
+		return newError("%s", err)
+	}
+	return v.(*object.Hash)
+}
+
+// exports returns a hash of the names the top-level export statements of
+// program bind in env.
+func exports(program *ast.Program, env *object.Environment) *object.Hash {
+	hash := object.NewHash()
+	for _, s := range program.Statements {
+		export, ok := s.(*ast.ExportStatement)
+		if !ok {
+			continue
+		}
+		for _, name := range export.Names() {
+			value, ok := env.Get(name.Value)
+			if !ok {
+				value = NULL
+			}
+			key := &object.String{Value: name.Value}
+			hash.Set(key.HashKey(), object.HashPair{Key: key, Value: value})
+		}
+	}
+	return hash
+}
+
 func evalBlockStatements(stmts []ast.Statement, env *object.Environment) object.Object {
 	var result object.Object
 
@@ -247,18 +524,22 @@ func isTruthy(o object.Object) bool {
 	}
 }
 
-func evalInfixExpression(operator string, left object.Object, right object.Object) object.Object {
+func evalInfixExpression(operator string, left object.Object, right object.Object, rt runtime) object.Object {
 	switch {
+	case operator == "==":
+		return nativeBoolToBooleanObject(object.Equal(left, right))
+	case operator == "!=":
+		return nativeBoolToBooleanObject(!object.Equal(left, right))
+	case operator == "|":
+		return evalPipelineOperator(left, right, rt)
+	case operator == ">>":
+		return evalComposeOperator(left, right)
 	case left.Type() == object.INTEGER && right.Type() == object.INTEGER:
 		return evalIntegerInfixExpression(operator, left, right)
+	case isNumber(left) && isNumber(right):
+		return evalFloatInfixExpression(operator, left, right)
 	case left.Type() == object.STRING && right.Type() == object.STRING:
 		return evalStringInfixExpression(operator, left, right)
-	case right.Type() == object.FUNCTION || right.Type() == object.BUILTIN:
-		return evalPipelineOperator(left, right)
-	case operator == "==":
-		return nativeBoolToBooleanObject(left == right)
-	case operator == "!=":
-		return nativeBoolToBooleanObject(left != right)
 	case left.Type() != right.Type():
 		return newError("type mismatch: %s %s %s", left.Type(), operator, right.Type())
 	default:
@@ -266,8 +547,30 @@ func evalInfixExpression(operator string, left object.Object, right object.Objec
 	}
 }
 
-func evalPipelineOperator(left object.Object, right object.Object) object.Object {
-	return callFunction(right, []object.Object{left})
+func evalPipelineOperator(left object.Object, right object.Object, rt runtime) object.Object {
+	return callFunction(right, []object.Object{left}, rt)
+}
+
+// evalComposeOperator returns a function that passes its argument to f and
+// the result to g.
+func evalComposeOperator(f object.Object, g object.Object) object.Object {
+	if !isCallable(f) || !isCallable(g) {
+		return newError("cannot compose %s and %s", f.Type(), g.Type())
+	}
+	return &object.Builtin{Fn: func(rt object.Runtime, args ...object.Object) object.Object {
+		if len(args) != 1 {
+			return newError("wrong number of arguments. got=%d, want=1", len(args))
+		}
+		result := rt.Call(f, args...)
+		if isError(result) {
+			return result
+		}
+		return rt.Call(g, result)
+	}}
+}
+
+func isCallable(o object.Object) bool {
+	return o.Type() == object.FUNCTION || o.Type() == object.BUILTIN
 }
 
 func evalStringInfixExpression(operator string, left object.Object, right object.Object) object.Object {
@@ -296,6 +599,9 @@ func evalIntegerInfixExpression(operator string, left object.Object, right objec
 	case "*":
 		return &object.Integer{Value: leftValue * rightValue}
 	case "/":
+		if rightValue == 0 {
+			return newError("division by zero: %d / 0", leftValue)
+		}
 		return &object.Integer{Value: leftValue / rightValue}
 	case ">":
 		return nativeBoolToBooleanObject(leftValue > rightValue)
@@ -310,6 +616,40 @@ func evalIntegerInfixExpression(operator string, left object.Object, right objec
 	}
 }
 
+func isNumber(o object.Object) bool {
+	return o.Type() == object.INTEGER || o.Type() == object.FLOAT
+}
+
+func toFloat(o object.Object) float64 {
+	if i, ok := o.(*object.Integer); ok {
+		return float64(i.Value)
+	}
+	return o.(*object.Float).Value
+}
+
+// evalFloatInfixExpression evaluates an operator with a float operand, the
+// other one converted to a float if it is an integer.
+func evalFloatInfixExpression(operator string, left object.Object, right object.Object) object.Object {
+	leftValue := toFloat(left)
+	rightValue := toFloat(right)
+	switch operator {
+	case "+":
+		return &object.Float{Value: leftValue + rightValue}
+	case "-":
+		return &object.Float{Value: leftValue - rightValue}
+	case "*":
+		return &object.Float{Value: leftValue * rightValue}
+	case "/":
+		return &object.Float{Value: leftValue / rightValue}
+	case ">":
+		return nativeBoolToBooleanObject(leftValue > rightValue)
+	case "<":
+		return nativeBoolToBooleanObject(leftValue < rightValue)
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
 func evalPrefixExpression(operator string, right object.Object) object.Object {
 	switch operator {
 	case "!":
@@ -322,11 +662,14 @@ func evalPrefixExpression(operator string, right object.Object) object.Object {
 }
 
 func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
-	if right.Type() != object.INTEGER {
+	switch right := right.(type) {
+	case *object.Integer:
+		return &object.Integer{Value: -right.Value}
+	case *object.Float:
+		return &object.Float{Value: -right.Value}
+	default:
 		return newError("unknown operator: -%s", right.Type())
 	}
-	value := right.(*object.Integer).Value
-	return &object.Integer{Value: -value}
 }
 
 func evalIndexExpression(left object.Object, index object.Object) object.Object {
@@ -376,10 +719,10 @@ func evalBangOperatorExpression(right object.Object) object.Object {
 }
 
 func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Object {
-	pairs := map[object.HashKey]object.HashPair{}
+	hash := object.NewHash()
 
-	for keyNode, valueNode := range node.Pairs {
-		key := Eval(keyNode, env)
+	for _, p := range node.Pairs {
+		key := Eval(p.Key, env)
 		if isError(key) {
 			return key
 		}
@@ -388,25 +731,24 @@ func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Obje
 		if !ok {
 			return newError("unusable as hash key: %s", key.Type())
 		}
-		value := Eval(valueNode, env)
+		value := Eval(p.Value, env)
 		if isError(value) {
 			return value
 		}
 
-		hashed := hashKey.HashKey()
-		pairs[hashed] = object.HashPair{
+		hash.Set(hashKey.HashKey(), object.HashPair{
 			Key:   key,
 			Value: value,
-		}
+		})
 	}
-	return &object.Hash{Pairs: pairs}
+	return hash
 }
 
 func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object {
 	if v, ok := env.Get(node.Value); ok {
 		return v
 	}
-	if builtin, ok := builtins[node.Value]; ok {
+	if builtin, ok := env.Context().BuiltinRegistry().Lookup(node.Value); ok {
 		return builtin
 	}
 	return newError("identifier is not found: %s", node.Value)
@@ -1,11 +1,22 @@
 package interpreter
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/wreulicke/monkey/ast"
 	"github.com/wreulicke/monkey/lexer"
+	"github.com/wreulicke/monkey/module"
 	"github.com/wreulicke/monkey/object"
 	"github.com/wreulicke/monkey/parser"
 )
@@ -32,6 +43,20 @@ func TestPipelineOperatorExpressions(t *testing.T) {
 		let _first = fn(x) { x[0] };
 		[1, 2] | _first
 		`, 1},
+
+		// extra arguments and placeholders
+		{`10 | fn(x, y) { x - y }(3)`, 7},
+		{`10 | fn(x, y) { x - y }(3, _)`, -7},
+		{`let sub = fn(a, b, c) { a - b - c }; 1 | sub(10, _, 2)`, 7},
+		{`[1] | push(2) | len`, 2},
+		{`3 | math.pow(2, _)`, 8},
+		{`2 | math.pow(3)`, 8},
+		{`"[1, 2]" | json.parse() | len`, 2},
+
+		// composition
+		{`let inc = fn(x) { x + 1 }; let double = fn(x) { x * 2 }; (inc >> double)(3)`, 8},
+		{`let inc = fn(x) { x + 1 }; let double = fn(x) { x * 2 }; 3 | double >> inc`, 7},
+		{`[1, 2] | rest >> first`, 2},
 	}
 
 	for _, tt := range tests {
@@ -41,6 +66,191 @@ func TestPipelineOperatorExpressions(t *testing.T) {
 
 }
 
+func TestDefaultRestAndSpread(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`let f = fn(a, b = 10) { a + b }; f(1)`, 11},
+		{`let f = fn(a, b = 10) { a + b }; f(1, 2)`, 3},
+		{`let f = fn(a, b = a * 2) { a + b }; f(3)`, 9},
+		{`let f = fn(a = 1, b = 2) { a * 10 + b }; f()`, 12},
+		{`let f = fn([a, b], c = a + b) { c }; f([1, 2])`, 3},
+		{`let f = fn(...xs) { len(xs) }; f()`, 0},
+		{`let f = fn(a, ...xs) { xs[1] }; f(1, 2, 3)`, 3},
+		{`let f = fn(a, b = 5, ...xs) { a + b + len(xs) }; f(1)`, 6},
+		{`let f = fn(a, b = 5, ...xs) { a + b + len(xs) }; f(1, 2, 3, 4)`, 5},
+		{`let add = fn(a, b) { a + b }; let xs = [1, 2]; add(...xs)`, 3},
+		{`let f = fn(...xs) { len(xs) }; f(0, ...[1, 2], 3, ...[])`, 4},
+		{`let a = [1, 2]; len([...a, ...a, 3])`, 5},
+		{`len(...["abc"])`, 3},
+		{`fn() { 1 }(1)`, "wrong number of arguments: want=0, got=1"},
+		{`fn(a, b) { a + b }(1)`, "wrong number of arguments: want=2, got=1"},
+		{`fn(a, b = 1) { a + b }()`, "wrong number of arguments: want=1 to 2, got=0"},
+		{`fn(a, ...rest) { a }()`, "wrong number of arguments: want at least 1, got=0"},
+		{`let f = fn(a) { a }; f(...1)`, "cannot spread INTEGER"},
+		{`fn(a = 1 + true) { a }()`, "type mismatch: INTEGER + BOOLEAN"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			testErrorObject(t, evaluated, expected)
+		}
+	}
+}
+
+func TestPipelineOperatorErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`1 | 2`, "not a function: INTEGER"},
+		{`let f = fn(x) { x }; f + f`, "unknown operator: FUNCTION + FUNCTION"},
+		{`len >> 1`, "cannot compose BUILTIN and INTEGER"},
+		{`(len >> len)(1, 2)`, "wrong number of arguments. got=2, want=1"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testErrorObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestMatchExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`match (1) { 1 => 10, _ => 20 }`, 10},
+		{`match (2) { 1 => 10, _ => 20 }`, 20},
+		{`match (-1) { -1 => 10, _ => 20 }`, 10},
+		{`match ("b") { "a" => 1, "b" => 2 }`, 2},
+		{`match (true) { false => 1, true => 2 }`, 2},
+		{`match (5) { x => x * 2 }`, 10},
+		{`match ([1, 2]) { [x] => x, [x, y] => x + y }`, 3},
+		{`match ([1, [2, 3]]) { [a, [b, c]] => a + b + c }`, 6},
+		{`match ([1, 2]) { [2, x] => x, [1, x] => x * 10 }`, 20},
+		{`match ({"x": 1, "y": 2}) { {z} => z, {x, y} => x + y }`, 3},
+		{`match ({"kind": "sq", "w": 3}) { {kind: "circle", w} => 0, {kind: "sq", w} => w * w }`, 9},
+		{`match (3) { x if x > 5 => 1, x if x > 2 => 2, _ => 3 }`, 2},
+		{`match ([1, 2]) { [x, _] if x > 1 => x, [_, y] => y }`, 2},
+		{`let x = 1; match (2) { x => x }; x`, 1},
+		{`match ("x") { 1 => 1 }`, "no match arm matched value: x"},
+		{`match (1) { x if y => 1 }`, "identifier is not found: y"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			testErrorObject(t, evaluated, expected)
+		}
+	}
+}
+
+func TestPatternMismatch(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let [x] = 1`, "cannot destructure INTEGER with an array pattern"},
+		{`let {x} = [1]`, "cannot destructure ARRAY with a hash pattern"},
+		{`let f = fn([x]) { x }; f(1)`, "cannot destructure INTEGER with an array pattern"},
+		{`let [a, b] = [1]; b`, "cannot destructure an array of length 1 with an array pattern of length 2"},
+		{`let {a, b} = {"a": 1}; b`, `cannot destructure a hash without the key "b"`},
+		{`let [a, {b}] = [1, 2]; b`, "cannot destructure INTEGER with a hash pattern"},
+		{`let f = fn({a}) { a }; f({})`, `cannot destructure a hash without the key "a"`},
+	}
+
+	for _, tt := range tests {
+		testErrorObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestNullAndOptionalChaining(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"null", nil},
+		{"null == null", true},
+		{"null ?? 1", 1},
+		{"2 ?? 1", 2},
+		{"false ?? 1", false},
+		{`{"a": 1}["b"] ?? 3`, 3},
+		{`{"a": {"b": 2}}["a"]?.["b"]`, 2},
+		{`{"a": {"b": 2}}["x"]?.["b"]`, nil},
+		{`{"a": {"b": 2}}["x"]?.["b"] ?? 5`, 5},
+		{`let f = fn(x) { x * 2 }; f?.(3)`, 6},
+		{`let h = {}; h["f"]?.(3)`, nil},
+		{`null?.(1 + true)`, nil},
+		{`1 ?? (1 + true)`, 1},
+		{`match (null) { null => 1, _ => 2 }`, 1},
+		{`{"a": 1}["b"]["c"]`, "index operator not supported: NULL"},
+		{`null?.["a"]["b"]`, nil},
+		{`let h = {"a": null}; h.a?.b.c`, nil},
+		{`let h = {"a": null}; h.a?.b.c() ?? 5`, 5},
+		{`let h = {"a": {"b": {"c": 3}}}; h.a?.b.c`, 3},
+		{`let h = {}; h.f?.(1)(2)`, nil},
+		{`null?.x.len()`, nil},
+		{`let calls = fn() { 1 + true }; null?.["a"][calls()]`, nil},
+		{`let h = {"a": {}}; h.a?.b.c`, "index operator not supported: NULL"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			testErrorObject(t, evaluated, expected)
+		case nil:
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestDotAndMethodCalls(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`let h = {"name": "monkey"}; h.name == "monkey"`, true},
+		{`let h = {"a": {"b": 2}}; h.a.b`, 2},
+		{`{}.missing`, nil},
+		{`let h = null; h?.a?.b`, nil},
+		{`"abc".len()`, 3},
+		{`[1, 2].push(3).len()`, 3},
+		{`[1, 2, 3].rest().first()`, 2},
+		{`let h = {"double": fn(x) { x * 2 }}; h.double(4)`, 8},
+		{`let h = null; h?.len()`, nil},
+		{`let n = 1; n.len()`, "undefined method len for INTEGER"},
+		{`"abc".len(1)`, "wrong number of arguments. got=2, want=1"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			testErrorObject(t, evaluated, expected)
+		case nil:
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
 func TestHashIndexExpressions(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -104,6 +314,22 @@ func TestHashLiterals(t *testing.T) {
 	}
 }
 
+func TestHashLiteralOrder(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`{"b": 1, "a": 2, 3: 3}`, "{b: 1, a: 2, 3: 3}"},
+		{`{"b": 1, "a": 2, "b": 3}`, "{b: 3, a: 2}"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong hash order. want=%q, got=%q", tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
 func TestMap(t *testing.T) {
 	input := `
 	let map = fn(arr, f) {
@@ -175,6 +401,7 @@ func TestBuiltinFunction(t *testing.T) {
 		{`len("")`, 0},
 		{`len("four")`, 4},
 		{`len("hello world")`, 11},
+		{`len("日本語")`, 3},
 		{`len(1)`, "argument to `len` not supported, got INTEGER"},
 		{`len("one", "two")`, "wrong number of arguments. got=2, want=1"},
 	}
@@ -190,6 +417,574 @@ func TestBuiltinFunction(t *testing.T) {
 
 }
 
+func TestStringBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`split("a,b,,c", ",")`, []string{"a", "b", "", "c"}},
+		{`"日本語".split("")`, []string{"日", "本", "語"}},
+		{`join(["a", "b", "c"], "-")`, "a-b-c"},
+		{`join([], "-")`, ""},
+		{`trim("  a b \n")`, "a b"},
+		{`upper("abc")`, "ABC"},
+		{`"ÀB".lower()`, "àb"},
+		{`replace("a-b-c", "-", "+")`, "a+b+c"},
+		{`contains("hello", "ell")`, true},
+		{`"hello".contains("x")`, false},
+		{`startsWith("hello", "he")`, true},
+		{`endsWith("hello", "he")`, false},
+		{`indexOf("日本語", "語")`, 2},
+		{`indexOf("abc", "x")`, -1},
+		{`substr("日本語です", 1, 2)`, "本語"},
+		{`substr("日本語です", 3)`, "です"},
+		{`substr("abc", 1, 10)`, "bc"},
+		{`substr("abc", 3)`, ""},
+		{`repeat("ab", 3)`, "ababab"},
+		{`"日本".chars()`, []string{"日", "本"}},
+		{`"a,b".split(",").len()`, 2},
+		{`join(["a", 1], "")`, &object.Error{Message: "argument to `join` must be ARRAY of STRING, got INTEGER in it"}},
+		{`split("a", 1)`, &object.Error{Message: "argument to `split` must be STRING, got INTEGER"}},
+		{`upper("a", "b")`, &object.Error{Message: "wrong number of arguments. got=2, want=1"}},
+		{`substr("abc", 4)`, &object.Error{Message: "start of `substr` out of range: 4"}},
+		{`substr("abc", 0, -1)`, &object.Error{Message: "length of `substr` must not be negative, got -1"}},
+		{`repeat("a", -1)`, &object.Error{Message: "count of `repeat` must not be negative, got -1"}},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			str, ok := evaluated.(*object.String)
+			if !ok || str.Value != expected {
+				t.Errorf("wrong result for %s. want=%q, got=%s", tt.input, expected, evaluated.Inspect())
+			}
+		case []string:
+			array, ok := evaluated.(*object.Array)
+			if !ok || len(array.Elements) != len(expected) {
+				t.Errorf("wrong result for %s. want=%q, got=%s", tt.input, expected, evaluated.Inspect())
+				continue
+			}
+			for i, e := range expected {
+				if str, ok := array.Elements[i].(*object.String); !ok || str.Value != e {
+					t.Errorf("wrong element %d for %s. want=%q, got=%s", i, tt.input, e, array.Elements[i].Inspect())
+				}
+			}
+		case *object.Error:
+			testErrorObject(t, evaluated, expected.Message)
+		}
+	}
+}
+
+func TestCollectionBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`map([1, 2, 3], fn(x) { x * 2 })`, []int64{2, 4, 6}},
+		{`[1, 2, 3].map(fn(x) { x + 1 })`, []int64{2, 3, 4}},
+		{`filter(range(10), fn(x) { x / 3 * 3 == x })`, []int64{0, 3, 6, 9}},
+		{`reduce([1, 2, 3, 4], fn(acc, x) { acc + x })`, 10},
+		{`reduce([], fn(acc, x) { acc + x }, 5)`, 5},
+		{`find([1, 2, 3], fn(x) { x > 1 })`, 2},
+		{`find([1, 2, 3], fn(x) { x > 5 })`, nil},
+		{`[any([1, 2], fn(x) { x > 1 }), any([], fn(x) { true }), all([1, 2], fn(x) { x > 1 }), all([], fn(x) { false })]`, []bool{true, false, false, true}},
+		{`sort([3, 1, 2])`, []int64{1, 2, 3}},
+		{`sort([3, 1, 2], fn(a, b) { a > b })`, []int64{3, 2, 1}},
+		{`sort(["b", "c", "a"]) | join(_, "")`, "abc"},
+		{`str(sort([2.5, 1.5]))`, "[1.5, 2.5]"},
+		{`str(sort([3, 1.5, 2, -0.5]))`, "[-0.5, 1.5, 2, 3]"},
+		{`zip([1, 2, 3], [4, 5]) | flatten`, []int64{1, 4, 2, 5}},
+		{`range(3)`, []int64{0, 1, 2}},
+		{`range(1, 3)`, []int64{1, 2}},
+		{`range(5, 0, -2)`, []int64{5, 3, 1}},
+		{`reverse([1, 2, 3])`, []int64{3, 2, 1}},
+		{`flatten([1, [2, [3]], []]).len()`, 3},
+		{`uniq([1, 2, 1, 3, 2])`, []int64{1, 2, 3}},
+		{`uniq([[1], [1], null, null, "a", "a"]).len()`, 3},
+		{`uniq([[{"a": 1}], [{"a": 1}], [{"a": 2}]]).len()`, 2},
+		{`map([1, 2], len)`, &object.Error{Message: "argument to `len` not supported, got INTEGER"}},
+		{`map([1], fn(x) { x + true })`, &object.Error{Message: "type mismatch: INTEGER + BOOLEAN"}},
+		{`map(1, fn(x) { x })`, &object.Error{Message: "argument to `map` must be ARRAY, got INTEGER"}},
+		{`sort([1, "a"])`, &object.Error{Message: "cannot compare STRING and INTEGER in `sort`"}},
+		{`reduce([], fn(acc, x) { acc })`, &object.Error{Message: "`reduce` of empty ARRAY with no initial value"}},
+		{`range(1, 2, 0)`, &object.Error{Message: "step of `range` must not be 0"}},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case nil:
+			testNullObject(t, evaluated)
+		case string:
+			str, ok := evaluated.(*object.String)
+			if !ok || str.Value != expected {
+				t.Errorf("wrong result for %s. want=%q, got=%s", tt.input, expected, evaluated.Inspect())
+			}
+		case []int64:
+			array, ok := evaluated.(*object.Array)
+			if !ok || len(array.Elements) != len(expected) {
+				t.Errorf("wrong result for %s. want=%v, got=%s", tt.input, expected, evaluated.Inspect())
+				continue
+			}
+			for i, e := range expected {
+				testIntegerObject(t, array.Elements[i], e)
+			}
+		case []bool:
+			array, ok := evaluated.(*object.Array)
+			if !ok || len(array.Elements) != len(expected) {
+				t.Errorf("wrong result for %s. want=%v, got=%s", tt.input, expected, evaluated.Inspect())
+				continue
+			}
+			for i, e := range expected {
+				testBooleanObject(t, array.Elements[i], e)
+			}
+		case *object.Error:
+			testErrorObject(t, evaluated, expected.Message)
+		}
+	}
+}
+
+func TestHashBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`keys({"b": 1, "a": 2, 3: 3})`, `[b, a, 3]`},
+		{`{"b": 1, "a": 2}.values()`, `[1, 2]`},
+		{`entries({"b": 1, "a": 2})`, `[[b, 1], [a, 2]]`},
+		{`[has({"a": 1}, "a"), has({"a": 1}, "b"), {1: null}.has(1)]`, `[true, false, true]`},
+		{`let h = {"a": 1, "b": 2, "c": 3}; [delete(h, "b"), h]`, `[{a: 1, c: 3}, {a: 1, b: 2, c: 3}]`},
+		{`delete({"a": 1}, "x")`, `{a: 1}`},
+		{`let h = {"a": 1, "b": 2}; [merge(h, {"c": 3, "a": 4}, {"d": 5}), h]`, `[{a: 4, b: 2, c: 3, d: 5}, {a: 1, b: 2}]`},
+		{`fromEntries([["b", 1], ["a", 2]])`, `{b: 1, a: 2}`},
+		{`let h = {"x": 1, "y": 2}; fromEntries(entries(h)) == h`, `true`},
+		{`{"keys": fn() { "own" }}.keys()`, `own`},
+		{`has({}, fn() {})`, `ERROR: unusable as hash key: FUNCTION`},
+		{`keys([])`, "ERROR: argument to `keys` must be HASH, got ARRAY"},
+		{`merge({}, 1)`, "ERROR: argument to `merge` must be HASH, got INTEGER"},
+		{`fromEntries([[1]])`, "ERROR: argument to `fromEntries` must be ARRAY of [key, value], got [1] in it"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %s. want=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestJSONBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`json.parse("{\"b\": [1, 2.5, 1e3], \"a\": {\"c\": null, \"d\": true}}")`, `{b: [1, 2.5, 1000.0], a: {c: null, d: true}}`},
+		{`json.parse(" \"x\" ")`, `x`},
+		{`json.parse("null") == null`, `true`},
+		{`json.stringify({"b": [1, "<\"q\">"], "a": null, 3: false})`, `{"b":[1,"<\"q\">"],"a":null,"3":false}`},
+		{`json.stringify({"a": [1], "b": {}}, 2)`, "{\n  \"a\": [\n    1\n  ],\n  \"b\": {}\n}"},
+		{`json.stringify([[]], "\t")`, "[\n\t[]\n]"},
+		{`let h = {"x": [1, {"y": "z"}], "n": true}; json.parse(json.stringify(h)) == h`, `true`},
+		{`json.parse("{")`, `ERROR: json.parse: unexpected end of JSON input`},
+		{`json.parse("[1] 2")`, `ERROR: json.parse: invalid value after top-level value`},
+		{`json.stringify(fn() {})`, `ERROR: json.stringify: cannot encode FUNCTION`},
+		{`json.stringify({[1]: 1})`, `ERROR: json.stringify: cannot encode hash key of type ARRAY`},
+		{`json.stringify(1, -1)`, "ERROR: indent of `json.stringify` must not be negative, got -1"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %s. want=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestMathBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`[math.abs(-3), math.abs(-2.5), math.abs(4)]`, `[3, 2.5, 4]`},
+		{`[math.min(3, 1.5, 2), math.max(1, 2), math.max(2, 2.0)]`, `[1.5, 2, 2]`},
+		{`[math.pow(2, 10), math.pow(2, -1), math.pow(4, 0.5)]`, `[1024, 0.5, 2.0]`},
+		{`[math.sqrt(16), math.exp(0), math.log(math.E), math.log2(8), math.log10(1000)]`, `[4.0, 1.0, 1.0, 3.0, 3.0]`},
+		{`[math.floor(2.7), math.ceil(2.1), math.round(-2.5), math.round(2)]`, `[2, 3, -3, 2]`},
+		{`[math.sin(0), math.cos(0), math.atan2(1, 1) * 4 == math.PI]`, `[0.0, 1.0, true]`},
+		{`let r = math.random(); [r < 0, r < 1]`, `[false, true]`},
+		{`range(100).map(fn(_) { math.randInt(2, 4) }).uniq().sort()`, `[2, 3]`},
+		{`math.sqrt("x")`, "ERROR: argument to `math.sqrt` must be INTEGER or FLOAT, got STRING"},
+		{`math.max()`, `ERROR: wrong number of arguments. got=0, want at least 1`},
+		{`math.randInt(3, 3)`, "ERROR: empty range in `math.randInt`: 3 to 3"},
+		{`math.floor(1e300)`, "ERROR: `math.floor` of 1e+300 is out of the range of INTEGER"},
+		{`[math.pow(2, 62), math.pow(-2, 63), math.pow(-1, 9223372036854775807), math.pow(0, 100)]`, `[4611686018427387904, -9223372036854775808, -1, 0]`},
+		{`math.pow(2, 63)`, "ERROR: `math.pow` of 2 and 63 is out of the range of INTEGER"},
+		{`math.pow(2, 64)`, "ERROR: `math.pow` of 2 and 64 is out of the range of INTEGER"},
+		{`math.pow(3, 40)`, "ERROR: `math.pow` of 3 and 40 is out of the range of INTEGER"},
+		{`math.abs(-9223372036854775807)`, "9223372036854775807"},
+		{`math.abs(-9223372036854775807 - 1)`, "ERROR: `math.abs` of -9223372036854775808 is out of the range of INTEGER"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %s. want=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestSeededRandom(t *testing.T) {
+	input := `[math.random(), math.randInt(1000000), [1, 2].map(fn(_) { math.randInt(1000000) })]`
+	run := func() string {
+		env := object.NewEnvironment()
+		env.SetContext(&object.Context{Rand: rand.New(rand.NewSource(42))})
+		return Eval(testParseProgram(input), env).Inspect()
+	}
+	first, second := run(), run()
+	if first != second {
+		t.Errorf("same seed gives different numbers: %s and %s", first, second)
+	}
+}
+
+func TestIOBuiltins(t *testing.T) {
+	dir := writeModules(t, map[string]string{"a.txt": "hello\n", "sub/b.txt": ""})
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`readFile("DIR/a.txt")`, "hello\n"},
+		{`writeFile("DIR/c.txt", "x"); appendFile("DIR/c.txt", "y"); readFile("DIR/c.txt")`, "xy"},
+		{`writeFile("DIR/a.txt", "new"); readFile("DIR/a.txt")`, "new"},
+		{`listDir("DIR").filter(fn(name) { name != "c.txt" })`, "[a.txt, sub]"},
+		{`[exists("DIR/sub"), exists("DIR/none")]`, "[true, false]"},
+		{`let s = stat("DIR/sub/b.txt"); [s.name, s.size, s.isDir, s.mode]`, "[b.txt, 0, false, -rw-r--r--]"},
+		{`stat("DIR").isDir`, "true"},
+		{`[readLine(), readLine(), readLine(), readLine()]`, "[first, second, third, null]"},
+		{`readFile("DIR/none")`, "ERROR: readFile: open DIR/none: no such file or directory"},
+		{`try(fn() { readFile("DIR/none") }).error`, "readFile: open DIR/none: no such file or directory"},
+		{`try(fn(x) { x + true }, 1)`, "{ok: false, value: null, error: type mismatch: INTEGER + BOOLEAN}"},
+		{`try(fn() { 1 / 0 })`, "{ok: false, value: null, error: division by zero: 1 / 0}"},
+		{`try(listDir, "DIR/sub")`, "{ok: true, value: [b.txt], error: null}"},
+	}
+	for _, tt := range tests {
+		input := strings.ReplaceAll(tt.input, "DIR", dir)
+		expected := strings.ReplaceAll(tt.expected, "DIR", dir)
+		env := object.NewEnvironment()
+		env.SetContext(&object.Context{
+			Capabilities: object.CapFileSystem | object.CapStdin,
+			Stdin:        bufio.NewReader(strings.NewReader("first\nsecond\r\nthird")),
+		})
+		evaluated := Eval(testParseProgram(input), env)
+		if evaluated.Inspect() != expected {
+			t.Errorf("wrong result for %s. want=%s, got=%s", input, expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestIOBuiltinsWithoutCapability(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`readFile("a.txt")`, "ERROR: `readFile` is not allowed: the host does not grant access to the file system"},
+		{`exists("a.txt")`, "ERROR: `exists` is not allowed: the host does not grant access to the file system"},
+		{`readLine()`, "ERROR: `readLine` is not allowed: the host does not grant access to the standard input"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %s. want=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+
+	env := object.NewEnvironment()
+	env.SetContext(&object.Context{Capabilities: object.CapStdin})
+	evaluated := Eval(testParseProgram(`writeFile("a.txt", "")`), env)
+	if evaluated.Inspect() != "ERROR: `writeFile` is not allowed: the host does not grant access to the file system" {
+		t.Errorf("writeFile is allowed with the standard input only. got=%s", evaluated.Inspect())
+	}
+}
+
+func TestRegexBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`regex.compile("a+\\d")`, `/a+\d/`},
+		{`let re = regex.compile("b+"); [re.test("abbc"), re.test("ac")]`, `[true, false]`},
+		{`regex.compile("(?P<key>\\w+)=(\\d+)?").match("é x=12")`, `{text: x=12, index: 2, groups: [x, 12], named: {key: x}}`},
+		{`regex.compile("(\\w)=(\\d)?").match("x=")`, `{text: x=, index: 0, groups: [x, null], named: {}}`},
+		{`regex.compile("z").match("abc")`, `null`},
+		{`regex.compile("(\\w)=(\\d)").findAll("x=1 y=2").map(fn(m) { m.groups })`, `[[x, 1], [y, 2]]`},
+		{`regex.compile("z").findAll("abc")`, `[]`},
+		{`regex.compile("(?P<k>\\w)=(\\d)").replaceAll("x=1 y=2", "${k}:$2")`, `x:1 y:2`},
+		{`regex.compile("\\d+").replaceAll("a1b22", fn(m) { "<" + m.text + ">" })`, `a<1>b<22>`},
+		{`regex.compile("a") == regex.compile("a")`, `true`},
+		{`regex.compile("(")`, "ERROR: regex.compile: error parsing regexp: missing closing ): `(`"},
+		{`try(regex.compile, "[").ok`, `false`},
+		{`regex.compile("a").replaceAll("a", fn(m) { 1 })`, "ERROR: function given to `replaceAll` must return STRING, got INTEGER"},
+		{`regex.compile("a").test(1)`, "ERROR: argument to `test` must be STRING, got INTEGER"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %s. want=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestOutputBuiltins(t *testing.T) {
+	tests := []struct {
+		input  string
+		stdout string
+		stderr string
+	}{
+		{`puts("a", 1); puts([2])`, "a\n1\n[2]\n", ""},
+		{`print("a", 1, {"b": 2}); print("!")`, "a 1 {b: 2}!", ""},
+		{`eprint("e", 1); puts("o")`, "o\n", "e 1"},
+		{`printf("%d|%5.2f|%-3s|%q|%x|%t|%T|%v|%%\n", 42, 3, "ab", "q", "hi", true, 1.5, [1])`, "42| 3.00|ab |\"q\"|6869|true|FLOAT|[1]|%\n", ""},
+	}
+	for _, tt := range tests {
+		var stdout, stderr bytes.Buffer
+		env := object.NewEnvironment()
+		env.SetContext(&object.Context{Stdout: &stdout, Stderr: &stderr})
+		if result := Eval(testParseProgram(tt.input), env); isError(result) {
+			t.Fatalf("%s failed: %s", tt.input, result.Inspect())
+		}
+		if stdout.String() != tt.stdout || stderr.String() != tt.stderr {
+			t.Errorf("wrong output for %s. want=%q %q, got=%q %q", tt.input, tt.stdout, tt.stderr, stdout.String(), stderr.String())
+		}
+	}
+}
+
+func TestSprintf(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`sprintf("%03d %g %.1e", 7, 0.5, 1234)`, "007 0.5 1.2e+03"},
+		{`sprintf("%s and %v", "x", null)`, "x and null"},
+		{`sprintf("%c%c", 72, 105)`, "Hi"},
+		{`sprintf("no verbs")`, "no verbs"},
+		{`sprintf("%d", "x")`, "ERROR: sprintf: cannot format STRING with %d"},
+		{`sprintf("%5f", true)`, "ERROR: sprintf: cannot format BOOLEAN with %5f"},
+		{`sprintf("%d")`, "ERROR: sprintf: missing argument for %d"},
+		{`sprintf("%d", 1, 2)`, `ERROR: sprintf: "%d" uses 1 of 2 arguments`},
+		{`sprintf("%y", 1)`, "ERROR: sprintf: unknown verb %y"},
+		{`sprintf("100%")`, `ERROR: sprintf: missing verb at the end of "100%"`},
+		{`sprintf(1)`, "ERROR: first argument to `sprintf` must be STRING"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %s. want=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestTypeBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`[type(1), type(1.5), type("s"), type(true), type(null), type([]), type({})]`, `[INTEGER, FLOAT, STRING, BOOLEAN, NULL, ARRAY, HASH]`},
+		{`[type(fn() {}), type(len), type(regex.compile("a")), type(json)]`, `[FUNCTION, BUILTIN, REGEX, HASH]`},
+		{`[int(42), int("-42"), int(2.7), int(-2.7), int(true), int(false)]`, `[42, -42, 2, -2, 1, 0]`},
+		{`[float(2), float("1e3"), float(true)]`, `[2.0, 1000.0, 1.0]`},
+		{`float(" 1")`, `ERROR: float: cannot parse " 1" as FLOAT`},
+		{`[str(1.5), str([1, "a"]), str("s"), str(null)]`, `[1.5, [1, a], s, null]`},
+		{`[bool(0), bool(""), bool([]), bool(false), bool(null)]`, `[true, true, true, false, false]`},
+		{`[isInteger(1), isFloat(1), isNumber(1.5), isString("s"), isBoolean(false), isNull(null)]`, `[true, false, true, true, true, true]`},
+		{`[isArray([]), isHash([]), isFunction(fn() {}), isFunction(puts), isFunction({}), isRegex(regex.compile(""))]`, `[true, false, true, true, false, true]`},
+		{`int("1.5")`, `ERROR: int: cannot parse "1.5" as INTEGER`},
+		{`int(fn() {})`, `ERROR: int: cannot convert FUNCTION to INTEGER`},
+		{`int(1e300)`, `ERROR: int: 1e+300 is out of the range of INTEGER`},
+		{`float([])`, `ERROR: float: cannot convert ARRAY to FLOAT`},
+		{`type()`, `ERROR: wrong number of arguments. got=0, want=1`},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %s. want=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+// fakeClock starts at a fixed time and advances only when the program
+// sleeps.
+type fakeClock struct {
+	now   time.Time
+	start time.Time
+}
+
+func newFakeClock() *fakeClock {
+	start := time.Date(2024, 2, 29, 12, 0, 0, 0, time.UTC)
+	return &fakeClock{now: start, start: start}
+}
+
+func (c *fakeClock) Now() time.Time           { return c.now }
+func (c *fakeClock) Monotonic() time.Duration { return c.now.Sub(c.start) }
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.now = c.now.Add(d)
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+func TestTimeBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`formatTime(now())`, "2024-02-29T12:00:00Z"},
+		{`let t = now(); sleep(1500); now() - t`, "1500"},
+		{`let start = monotonic(); sleep(2); monotonic() - start`, "2.0"},
+		{`formatTime(now() + duration("36h30m"), "Jan 2 15:04")`, "Mar 2 00:30"},
+		{`formatTime(1500)`, "1970-01-01T00:00:01.5Z"},
+		{`parseTime("1970-01-01T00:00:01.5Z")`, "1500"},
+		{`parseTime("2024-03-01", "2006-01-02") - now()`, "43200000"},
+		{`[duration("250ms"), formatDuration(duration("1h30m") + 250)]`, "[250, 1h30m0.25s]"},
+		{`parseTime("x")`, `ERROR: parseTime: parsing time "x" as "2006-01-02T15:04:05.999999999Z07:00": cannot parse "x" as "2006"`},
+		{`duration("5 parsecs")`, `ERROR: duration: time: unknown unit " parsecs" in duration "5 parsecs"`},
+		{`sleep(-1)`, "ERROR: duration of `sleep` must not be negative, got -1"},
+	}
+	for _, tt := range tests {
+		env := object.NewEnvironment()
+		env.SetContext(&object.Context{Clock: newFakeClock()})
+		evaluated := Eval(testParseProgram(tt.input), env)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %s. want=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+// stuckClock never wakes the program up, and cancels it when it sleeps.
+type stuckClock struct {
+	*fakeClock
+	cancel context.CancelFunc
+}
+
+func (c stuckClock) After(d time.Duration) <-chan time.Time {
+	c.cancel()
+	return nil
+}
+
+func TestSleepCanceled(t *testing.T) {
+	tests := []string{
+		`sleep(1000)`,
+		`sleep(1000); 1`,
+		`try(fn() { sleep(1000) })`,
+		`[1, 2].map(fn(x) { sleep(x) })`,
+	}
+	for _, input := range tests {
+		ctx, cancel := context.WithCancel(context.Background())
+		env := object.NewEnvironment()
+		env.SetContext(&object.Context{Clock: stuckClock{newFakeClock(), cancel}})
+		_, err := EvalContext(ctx, testParseProgram(input), env)
+		var canceled *object.CanceledError
+		if !errors.As(err, &canceled) || !errors.Is(err, context.Canceled) {
+			t.Errorf("expected %s to be canceled, got %v", input, err)
+		}
+	}
+}
+
+func TestRegistryBuiltins(t *testing.T) {
+	registry := object.NewDefaultRegistry()
+	registry.Register("twice", object.Arity{Min: 1, Max: 1}, func(rt object.Runtime, args ...object.Object) object.Object {
+		return rt.Call(args[0], rt.Call(args[0]))
+	})
+	registry.Define("answer", &object.Integer{Value: 42})
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`twice(fn(x = 1) { x * 3 })`, "9"},
+		{`answer + len("a")`, "43"},
+		{`twice()`, "ERROR: wrong number of arguments: want=1, got=0"},
+		{`let answer = 1; answer`, "1"},
+	}
+	for _, tt := range tests {
+		env := object.NewEnvironment()
+		env.SetContext(&object.Context{Builtins: registry})
+		evaluated := Eval(testParseProgram(tt.input), env)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %s. want=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+	if evaluated := testEval(`answer`); evaluated.Inspect() != "ERROR: identifier is not found: answer" {
+		t.Errorf("expected answer to be undefined without the registry, got %s", evaluated.Inspect())
+	}
+}
+
+func TestEvalContext(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`cancel(); 1`, "1"},
+		{`let f = fn() { 1 }; cancel(); f()`, ""},
+		{`cancel(); [1, 2].map(fn(x) { x })`, ""},
+		{`try(fn() { cancel(); len("a") })`, ""},
+		{`[1, 2].map(fn(x) { try(fn() { cancel(); x }) })`, ""},
+	}
+	for _, tt := range tests {
+		ctx, cancel := context.WithCancel(context.Background())
+		registry := object.NewDefaultRegistry()
+		registry.Register("cancel", object.Arity{}, func(rt object.Runtime, args ...object.Object) object.Object {
+			cancel()
+			return nil
+		})
+		objectCtx := &object.Context{Builtins: registry}
+		env := object.NewEnvironment()
+		env.SetContext(objectCtx)
+		evaluated, err := EvalContext(ctx, testParseProgram(tt.input), env)
+		if env.Context() != objectCtx {
+			t.Errorf("expected the context of the environment to be restored")
+		}
+		if tt.expected != "" {
+			if err != nil || evaluated.Inspect() != tt.expected {
+				t.Errorf("wrong result for %s. want=%s, got=%v, %v", tt.input, tt.expected, evaluated, err)
+			}
+			continue
+		}
+		var canceled *object.CanceledError
+		if !errors.As(err, &canceled) || !errors.Is(err, context.Canceled) {
+			t.Errorf("expected %s to be canceled, got %v", tt.input, err)
+		}
+	}
+}
+
+func TestStackOverflow(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let x = fn() { x() }; x()`, "ERROR: stack overflow"},
+		{`let f = fn(n) { f(n + 1) + 1 }; f(0)`, "ERROR: stack overflow"},
+		{`map([1], fn(v) { let f = fn() { f() }; f() })`, "ERROR: stack overflow"},
+		{`let f = fn(n) { n | f }; f(0)`, "ERROR: stack overflow"},
+		{`let x = fn() { x() }; try(x).error`, "stack overflow"},
+		{`let f = fn(n) { if (n == 0) { 0 } else { f(n - 1) } }; f(1000)`, "0"},
+	}
+	for _, tt := range tests {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		env := object.NewEnvironment()
+		env.SetContext(&object.Context{})
+		evaluated, err := EvalContext(ctx, testParseProgram(tt.input), env)
+		cancel()
+		if err != nil {
+			t.Fatalf("%s: %s", tt.input, err)
+		}
+		if got := evaluated.Inspect(); got != tt.expected {
+			t.Errorf("wrong result for %s. want=%s, got=%s", tt.input, tt.expected, got)
+		}
+	}
+}
+
 func TestFibbo(t *testing.T) {
 	input := `
 	let fibb = fn(x) { 
@@ -310,6 +1105,7 @@ func TestLetStatements(t *testing.T) {
 		{"let a = 5; let b = a; let c = a + b + 5; c", 15},
 
 		{"let [x, y] = [15, 0]; x", 15},
+		{"let [x] = [15, 0]; x", 15},
 
 		// {`
 		// 	let x = 15
@@ -347,6 +1143,10 @@ func TestErrorHandling(t *testing.T) {
 			"-true",
 			"unknown operator: -BOOLEAN",
 		},
+		{
+			"let x = 0; 7 / x",
+			"division by zero: 7 / 0",
+		},
 		{
 			"true + false",
 			"unknown operator: BOOLEAN + BOOLEAN",
@@ -491,6 +1291,20 @@ func TestEvalBooleanExpression(t *testing.T) {
 		{"(1 < 2) == false", false},
 		{"(1 > 2) == true", false},
 		{"(1 > 2) == false", true},
+		{`"a" == "a"`, true},
+		{`"a" != "b"`, true},
+		{"[1, 2] == [1, 2]", true},
+		{"[1, 2] == [2, 1]", false},
+		{"[1, [2, 3]] == [1, [2, 3]]", true},
+		{"[1] != [1, 2]", true},
+		{`{"a": 1, "b": [2]} == {"b": [2], "a": 1}`, true},
+		{`{"a": 1} == {"a": 2}`, false},
+		{`{"a": 1} == {"b": 1}`, false},
+		{`[1] == {"a": 1}`, false},
+		{`1 == "1"`, false},
+		{`{[1, 2]: 3}[[1, 2]] == 3`, true},
+		{`let f = fn() { 1 }; f == f`, true},
+		{`fn() { 1 } == fn() { 1 }`, false},
 	}
 	for _, tt := range tests {
 		evaluated := testEval(tt.input)
@@ -528,6 +1342,28 @@ func TestEvalIntegerExpression(t *testing.T) {
 	}
 }
 
+func TestEvalFloatExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1.5", "1.5"},
+		{"-2.5", "-2.5"},
+		{"1e3", "1000.0"},
+		{"1.5 + 2", "3.5"},
+		{"3 / 2.0", "1.5"},
+		{"2 * 0.25 - 1", "-0.5"},
+		{"[1 < 1.5, 2.5 > 3, 1.5 == 1.5, 1 == 1.0]", "[true, false, true, false]"},
+		{"1.5 + true", "ERROR: type mismatch: FLOAT + BOOLEAN"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %s. want=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
 func testBooleanObject(t *testing.T, obj object.Object, expected bool) bool {
 	result, ok := obj.(*object.Boolean)
 	if !ok {
@@ -541,6 +1377,195 @@ func testBooleanObject(t *testing.T, obj object.Object, expected bool) bool {
 	return true
 }
 
+func TestQuoteUnquote(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`quote(5)`, `5`},
+		{`quote(5 + 8)`, `(5 + 8)`},
+		{`quote(foobar + barfoo)`, `(foobar + barfoo)`},
+		{`quote(unquote(4 + 4))`, `8`},
+		{`quote(8 + unquote(4 + 4))`, `(8 + 8)`},
+		{`quote(unquote(0 - 3))`, `(-3)`},
+		{`quote(unquote(-9223372036854775807 - 1))`, `((-9223372036854775807) - 1)`},
+		{`quote(unquote(true == false))`, `false`},
+		{`quote(unquote("a" + "b"))`, `ab`},
+		{`quote(unquote(quote(4 + 4)))`, `(4 + 4)`},
+		{`let quotedInfixExpression = quote(4 + 4);
+		quote(unquote(4 + 4) + unquote(quotedInfixExpression))`, `(8 + (4 + 4))`},
+		{`let x = 2; quote(f(unquote(x), [unquote(x)]))`, `f(2, [2])`},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		quote, ok := evaluated.(*object.Quote)
+		if !ok {
+			t.Fatalf("expected *object.Quote. got=%T (%+v)", evaluated, evaluated)
+		}
+		if quote.Node == nil {
+			t.Fatalf("quote.Node is nil")
+		}
+		if quote.Node.String() != tt.expected {
+			t.Errorf("not equal. got=%q, want=%q", quote.Node.String(), tt.expected)
+		}
+	}
+}
+
+func TestUnquoteMinInt64(t *testing.T) {
+	input := `
+	let min = macro() { quote(unquote(-9223372036854775807 - 1)) };
+	[min(), min() + 1]`
+	program := testParseProgram(input)
+	env := object.NewEnvironment()
+	DefineMacros(program, env)
+	expanded, err := ExpandMacros(program, env)
+	if err != nil {
+		t.Fatalf("expansion failed: %s", err.Message)
+	}
+	evaluated := Eval(expanded, object.NewEnvironment())
+	if evaluated.Inspect() != "[-9223372036854775808, -9223372036854775807]" {
+		t.Errorf("wrong result. got=%s", evaluated.Inspect())
+	}
+}
+
+func TestUnquoteErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`quote(unquote(fn(x) { x }))`, "cannot unquote FUNCTION"},
+		{`quote(unquote(1 + true))`, "type mismatch: INTEGER + BOOLEAN"},
+		{`quote(1, 2)`, "wrong number of arguments to quote. got=2, want=1"},
+	}
+
+	for _, tt := range tests {
+		testErrorObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestDefineMacros(t *testing.T) {
+	input := `
+	let number = 1;
+	let function = fn(x, y) { x + y };
+	let mymacro = macro(x, y) { x + y; };
+	`
+
+	env := object.NewEnvironment()
+	program := testParseProgram(input)
+
+	DefineMacros(program, env)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("Wrong number of statements. got=%d", len(program.Statements))
+	}
+	if _, ok := env.Get("number"); ok {
+		t.Fatalf("number should not be defined")
+	}
+	if _, ok := env.Get("function"); ok {
+		t.Fatalf("function should not be defined")
+	}
+
+	obj, ok := env.Get("mymacro")
+	if !ok {
+		t.Fatalf("macro not in environment.")
+	}
+	macro, ok := obj.(*object.Macro)
+	if !ok {
+		t.Fatalf("object is not Macro. got=%T (%+v)", obj, obj)
+	}
+	if len(macro.Parameters) != 2 {
+		t.Fatalf("Wrong number of macro parameters. got=%d", len(macro.Parameters))
+	}
+	if macro.Parameters[0].String() != "x" || macro.Parameters[1].String() != "y" {
+		t.Fatalf("parameters wrong. got=%v", macro.Parameters)
+	}
+	expectedBody := "(x + y)"
+	if macro.Body.String() != expectedBody {
+		t.Fatalf("body is not %q. got=%q", expectedBody, macro.Body.String())
+	}
+}
+
+func TestExpandMacros(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			`
+			let infixExpression = macro() { quote(1 + 2); };
+			infixExpression();
+			`,
+			`(1 + 2)`,
+		},
+		{
+			`
+			let reverse = macro(a, b) { quote(unquote(b) - unquote(a)); };
+			reverse(2 + 2, 10 - 5);
+			`,
+			`(10 - 5) - (2 + 2)`,
+		},
+		{
+			`
+			let unless = macro(cond, consequence, alternative) {
+				quote(if (!(unquote(cond))) {
+					unquote(consequence);
+				} else {
+					unquote(alternative);
+				});
+			};
+			unless(10 > 5, puts("not greater"), puts("greater"));
+			`,
+			`if (!(10 > 5)) { puts("not greater") } else { puts("greater") }`,
+		},
+	}
+
+	for _, tt := range tests {
+		expected := testParseProgram(tt.expected)
+		program := testParseProgram(tt.input)
+
+		env := object.NewEnvironment()
+		DefineMacros(program, env)
+		expanded, err := ExpandMacros(program, env)
+		if err != nil {
+			t.Fatalf("expansion failed: %s", err.Message)
+		}
+
+		if expanded.String() != expected.String() {
+			t.Errorf("not equal. want=%q, got=%q", expected.String(), expanded.String())
+		}
+	}
+}
+
+func TestExpandMacrosErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let m = macro(a) { 1 }; m(2)`, "macro must return a quote, got INTEGER"},
+		{`let m = macro(a) { quote(a) }; m()`, "wrong number of arguments to macro: want=1, got=0"},
+	}
+
+	for _, tt := range tests {
+		program := testParseProgram(tt.input)
+		env := object.NewEnvironment()
+		DefineMacros(program, env)
+		_, err := ExpandMacros(program, env)
+		if err == nil {
+			t.Fatalf("expected error for %q", tt.input)
+		}
+		if err.Message != tt.expected {
+			t.Errorf("wrong error. want=%q, got=%q", tt.expected, err.Message)
+		}
+	}
+}
+
+func testParseProgram(input string) *ast.Program {
+	l := lexer.New(bytes.NewBufferString(input))
+	p := parser.New(l)
+	return p.Parse()
+}
+
 func testEval(input string) object.Object {
 	l := lexer.New(bytes.NewBufferString(input))
 	p := parser.New(l)
@@ -582,3 +1607,98 @@ func testNullObject(t *testing.T, obj object.Object) bool {
 	t.Errorf("object is not NULL. got=%T (%+v)", obj, obj)
 	return false
 }
+
+func TestImport(t *testing.T) {
+	dir := writeModules(t, map[string]string{
+		"util.mk": `
+			let secret = 40;
+			export let add = fn(a, b) { a + b };
+			export let answer = add(secret, 2);
+			export let [first, {second}] = [1, {"second": 2}];
+		`,
+		"nested/a.mk":   `export let value = (import "../util").answer + 1;`,
+		"lib/search.mk": `export let value = "found";`,
+		"cycle/a.mk":    `import "./b";`,
+		"cycle/b.mk":    `import "./a";`,
+		"fail.mk":       `let x = 1 + true;`,
+		"inner.mk":      `if (true) { export let x = 1; }`,
+	})
+
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`let u = import "./util"; u.add(u.answer, 1)`, 43},
+		{`let u = import "./util.mk"; u.first + u.second`, 3},
+		{`let u = import "./util"; u.secret`, nil},
+		{`(import "./nested/a").value`, 43},
+		{`let f = fn() { import "search" }; f().value`, "found"},
+		{`import "./cycle/a"`, "import cycle: " + filepath.Join(dir, "cycle/a.mk") + " -> " + filepath.Join(dir, "cycle/b.mk") + " -> " + filepath.Join(dir, "cycle/a.mk")},
+		{`import "./missing"`, `cannot find module "./missing.mk"`},
+		{`import "./fail"`, "type mismatch: INTEGER + BOOLEAN"},
+		{`import "./inner"`, "export is only allowed at the top level"},
+		{`fn() { export let x = 1; }()`, "export is only allowed at the top level"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEvalFile(tt.input, filepath.Join(dir, "main.mk"), filepath.Join(dir, "lib"))
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case nil:
+			testNullObject(t, evaluated)
+		case string:
+			if err, ok := evaluated.(*object.Error); ok {
+				testErrorObject(t, err, expected)
+				continue
+			}
+			str, ok := evaluated.(*object.String)
+			if !ok || str.Value != expected {
+				t.Errorf("wrong result for %q. want=%q, got=%s", tt.input, expected, evaluated.Inspect())
+			}
+		}
+	}
+}
+
+func TestImportEvaluatesModulesOnce(t *testing.T) {
+	dir := writeModules(t, map[string]string{
+		"m.mk":   `export let f = fn() { 1 };`,
+		"a.mk":   `export let m = import "./m";`,
+		"lib.mk": `export let m = import "./m";`,
+	})
+	evaluated := testEvalFile(`[import "./m", (import "./a").m, (import "lib").m]`, filepath.Join(dir, "main.mk"), dir)
+	array, ok := evaluated.(*object.Array)
+	if !ok {
+		t.Fatalf("object is not Array. got=%T (%+v)", evaluated, evaluated)
+	}
+	for _, e := range array.Elements[1:] {
+		if e != array.Elements[0] {
+			t.Errorf("module is evaluated more than once. got=%s, want=%s", e.Inspect(), array.Elements[0].Inspect())
+		}
+	}
+}
+
+func writeModules(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "monkey-interpreter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	for name, src := range files {
+		file := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func testEvalFile(input, file string, path ...string) object.Object {
+	env := object.NewEnvironment()
+	env.SetContext(&object.Context{File: file, Modules: module.NewLoader(path...)})
+	return Eval(testParseProgram(input), env)
+}
@@ -0,0 +1,109 @@
+package interpreter
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/wreulicke/monkey/ast"
+	"github.com/wreulicke/monkey/object"
+	"github.com/wreulicke/monkey/token"
+)
+
+func quote(node ast.Node, env *object.Environment) object.Object {
+	node, err := evalUnquoteCalls(node, env)
+	if err != nil {
+		return err
+	}
+	return &object.Quote{Node: node}
+}
+
+// evalUnquoteCalls replaces every `unquote(e)` below quoted with the syntax
+// tree of the value e evaluates to in env.
+func evalUnquoteCalls(quoted ast.Node, env *object.Environment) (ast.Node, *object.Error) {
+	var err *object.Error
+	node := ast.Modify(quoted, func(node ast.Node) ast.Node {
+		if err != nil || !isUnquoteCall(node) {
+			return node
+		}
+		call := node.(*ast.CallExpression)
+		if len(call.Arguments) != 1 {
+			err = newError("wrong number of arguments to unquote. got=%d, want=1", len(call.Arguments))
+			return node
+		}
+		unquoted := Eval(call.Arguments[0], env)
+		if e, ok := unquoted.(*object.Error); ok {
+			err = e
+			return node
+		}
+		converted := convertObjectToASTNode(unquoted)
+		if converted == nil {
+			err = newError("cannot unquote %s", unquoted.Type())
+			return node
+		}
+		return converted
+	})
+	return node, err
+}
+
+func isUnquoteCall(node ast.Node) bool {
+	call, ok := node.(*ast.CallExpression)
+	if !ok {
+		return false
+	}
+	return call.Function.TokenLiteral() == "unquote"
+}
+
+func convertObjectToASTNode(obj object.Object) ast.Node {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		if obj.Value == math.MinInt64 {
+			// the magnitude of MinInt64 is no INTEGER, so it is written
+			// as (MinInt64 + 1) - 1
+			one := token.Token{Type: token.NUMBER, Literal: "1"}
+			return &ast.InfixExpression{
+				Token:    token.Token{Type: token.MINUS, Literal: "-"},
+				Left:     convertObjectToASTNode(&object.Integer{Value: math.MinInt64 + 1}).(ast.Expression),
+				Operator: "-",
+				Right:    &ast.NumberLiteral{Token: one, Value: one.Literal},
+			}
+		}
+		if obj.Value < 0 {
+			t := token.Token{Type: token.MINUS, Literal: "-"}
+			return &ast.PrefixExpression{
+				Token:    t,
+				Operator: "-",
+				Right:    convertObjectToASTNode(&object.Integer{Value: -obj.Value}).(ast.Expression),
+			}
+		}
+		t := token.Token{Type: token.NUMBER, Literal: strconv.FormatInt(obj.Value, 10)}
+		return &ast.NumberLiteral{Token: t, Value: t.Literal}
+	case *object.Float:
+		if obj.Value < 0 {
+			t := token.Token{Type: token.MINUS, Literal: "-"}
+			return &ast.PrefixExpression{
+				Token:    t,
+				Operator: "-",
+				Right:    convertObjectToASTNode(&object.Float{Value: -obj.Value}).(ast.Expression),
+			}
+		}
+		t := token.Token{Type: token.NUMBER, Literal: obj.Inspect()}
+		return &ast.NumberLiteral{Token: t, Value: t.Literal}
+	case *object.Boolean:
+		var t token.Token
+		if obj.Value {
+			t = token.Token{Type: token.TRUE, Literal: "true"}
+		} else {
+			t = token.Token{Type: token.FALSE, Literal: "false"}
+		}
+		return &ast.BooleanLiteral{Token: t, Value: obj.Value}
+	case *object.String:
+		t := token.Token{Type: token.STRING, Literal: obj.Value}
+		return &ast.StringLiteral{Token: t, Value: obj.Value}
+	case *object.Null:
+		return &ast.NullLiteral{Token: token.Token{Type: token.NULL, Literal: "null"}}
+	case *object.Quote:
+		return obj.Node
+	default:
+		return nil
+	}
+}
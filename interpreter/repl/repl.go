@@ -2,17 +2,24 @@ package repl
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
 
 	"github.com/c-bata/go-prompt"
+	"github.com/wreulicke/monkey/ast"
 	"github.com/wreulicke/monkey/interpreter"
 	"github.com/wreulicke/monkey/lexer"
+	"github.com/wreulicke/monkey/module"
 	"github.com/wreulicke/monkey/object"
 	"github.com/wreulicke/monkey/parser"
 )
 
 func Start() {
+	macroEnv := object.NewEnvironment()
+	// the prompt reads the standard input, so programs may not
+	ctx := &object.Context{Modules: module.NewLoader(module.SearchPath()...), Capabilities: object.CapFileSystem}
 	p := prompt.New(func(str string) {
 		switch str {
 		case "exit":
@@ -26,8 +33,19 @@ func Start() {
 				printParseError(p.Errors())
 				return
 			}
+			interpreter.DefineMacros(program, macroEnv)
+			expanded, err := interpreter.ExpandMacros(program, macroEnv)
+			if err != nil {
+				fmt.Println(err.Inspect())
+				return
+			}
 			env := object.NewEnvironment()
-			o := interpreter.Eval(program, env)
+			env.SetContext(ctx)
+			o, evalErr := eval(expanded, env)
+			if evalErr != nil {
+				fmt.Println("Interrupted")
+				return
+			}
 			if o != nil {
 				fmt.Println(o.Inspect())
 			}
@@ -38,6 +56,14 @@ func Start() {
 	p.Run()
 }
 
+// eval evaluates node until it ends or Ctrl-C interrupts it. The prompt
+// leaves raw mode while it runs, so Ctrl-C sends an interrupt.
+func eval(node ast.Node, env *object.Environment) (object.Object, error) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	return interpreter.EvalContext(ctx, node, env)
+}
+
 func printParseError(errors []error) {
 	for _, msg := range errors {
 		fmt.Println("\t", msg)
@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"strings"
 	"unicode"
 	"unicode/utf8"
 
@@ -13,27 +14,26 @@ import (
 
 const eof = -1
 
-type Position struct {
-	line   int
-	column int
-}
-
 type Lexer struct {
-	input    *bufio.Reader
-	buffer   bytes.Buffer
-	position *Position
+	input  *bufio.Reader
+	buffer bytes.Buffer
+	// position is the line and the number of bytes read on it so far.
+	position token.Position
+	// start is the position of the token being read.
+	start    token.Position
+	comments []token.Token
 	offset   int
 	error    error
 }
 
 func New(input io.Reader) *Lexer {
 	l := &Lexer{input: bufio.NewReader(input)}
-	l.position = &Position{line: 1}
+	l.position = token.Position{Line: 1}
 	return l
 }
 
 func (l *Lexer) Error(e string) {
-	err := fmt.Errorf("%s in %d:%d", e, (*l).position.line, (*l).position.column)
+	err := fmt.Errorf("%s in %s", e, l.position)
 	l.error = err
 }
 
@@ -46,11 +46,7 @@ func (l *Lexer) Next() rune {
 	if err == io.EOF {
 		return eof
 	}
-	if r == '\n' {
-		l.position = &Position{line: l.position.line + 1}
-	}
-	l.position.column += w
-	l.offset += w
+	l.advance(r, w)
 	l.buffer.WriteRune(r)
 	return r
 }
@@ -60,12 +56,17 @@ func (l *Lexer) Skip() rune {
 	if err == io.EOF {
 		return eof
 	}
+	l.advance(r, w)
+	return r
+}
+
+func (l *Lexer) advance(r rune, w int) {
 	if r == '\n' {
-		l.position = &Position{line: l.position.line + 1}
+		l.position = token.Position{Line: l.position.Line + 1}
+	} else {
+		l.position.Column += w
 	}
-	l.position.column += w
 	l.offset += w
-	return r
 }
 
 func (l *Lexer) Peek() rune {
@@ -90,9 +91,11 @@ func (l *Lexer) Peek() rune {
 	return ruNe
 }
 
+// readIdentifier reads the rest of an identifier, which may have digits
+// after its first letter.
 func (l *Lexer) readIdentifier() {
 	next := l.Peek()
-	for unicode.IsLetter(next) {
+	for unicode.IsLetter(next) || isDigit(next) {
 		l.Next()
 		next = l.Peek()
 	}
@@ -168,6 +171,9 @@ func (l *Lexer) readString(start rune) {
 			next := l.Peek()
 			if next == start {
 				l.Next()
+			} else if next == '\\' {
+				l.Skip()
+				l.buffer.WriteRune('\\')
 			} else if next == 'b' {
 				l.Skip()
 				l.buffer.WriteRune('\b')
@@ -199,17 +205,45 @@ func (l *Lexer) readString(start rune) {
 	}
 }
 
+// Comments returns the comments skipped so far as COMMENT tokens.
+func (l *Lexer) Comments() []token.Token {
+	return l.comments
+}
+
 func (l *Lexer) skipWhitespace() {
-	ruNe := l.Peek()
-	for unicode.IsSpace(ruNe) {
-		l.Next()
-		ruNe = l.Peek()
+	for {
+		ruNe := l.Peek()
+		for unicode.IsSpace(ruNe) {
+			l.Next()
+			ruNe = l.Peek()
+		}
+		if !l.peekComment() {
+			break
+		}
+		l.readComment()
 	}
 	l.buffer.Reset()
 }
 
+func (l *Lexer) peekComment() bool {
+	p, err := l.input.Peek(2)
+	return err == nil && string(p) == "//"
+}
+
+// readComment reads a comment up to the end of the line.
+func (l *Lexer) readComment() {
+	l.buffer.Reset()
+	pos := token.Position{Line: l.position.Line, Column: l.position.Column + 1}
+	for next := l.Peek(); next != '\n' && next != eof; next = l.Peek() {
+		l.Next()
+	}
+	text := strings.TrimRightFunc(l.TokenText(), unicode.IsSpace)
+	l.comments = append(l.comments, token.Token{Type: token.COMMENT, Literal: text, Pos: pos})
+}
+
 func (l *Lexer) NextToken() token.Token {
 	l.skipWhitespace()
+	l.start = token.Position{Line: l.position.Line, Column: l.position.Column + 1}
 	next := l.Peek()
 	switch next {
 	case '"':
@@ -227,6 +261,9 @@ func (l *Lexer) NextToken() token.Token {
 		if l.Peek() == '=' {
 			l.Next()
 			return l.newToken(token.EQ)
+		} else if l.Peek() == '>' {
+			l.Next()
+			return l.newToken(token.ARROW)
 		}
 		return l.newToken(token.ASSIGN)
 	case '+':
@@ -246,6 +283,10 @@ func (l *Lexer) NextToken() token.Token {
 	case '<':
 		return l.newToken(token.LT)
 	case '>':
+		if l.Peek() == '>' {
+			l.Next()
+			return l.newToken(token.COMPOSE)
+		}
 		return l.newToken(token.GT)
 	case ':':
 		return l.newToken(token.COLON)
@@ -257,6 +298,16 @@ func (l *Lexer) NextToken() token.Token {
 		return l.newToken(token.RPAREN)
 	case ',':
 		return l.newToken(token.COMMA)
+	case '.':
+		if l.Peek() == '.' {
+			l.Next()
+			if l.Peek() != '.' {
+				return l.newToken(token.ILLEGAL)
+			}
+			l.Next()
+			return l.newToken(token.ELLIPSIS)
+		}
+		return l.newToken(token.DOT)
 	case '{':
 		return l.newToken(token.LBRACE)
 	case '}':
@@ -267,6 +318,15 @@ func (l *Lexer) NextToken() token.Token {
 		return l.newToken(token.RBRACKET)
 	case '|':
 		return l.newToken(token.PIPELINE)
+	case '?':
+		if l.Peek() == '?' {
+			l.Next()
+			return l.newToken(token.NULLISH)
+		} else if l.Peek() == '.' {
+			l.Next()
+			return l.newToken(token.OPTIONAL)
+		}
+		return l.newToken(token.ILLEGAL)
 	case eof:
 		return l.newToken(token.EOF)
 	default:
@@ -285,6 +345,7 @@ func (l *Lexer) newToken(tokenType token.TokenType) token.Token {
 	return token.Token{
 		Type:    tokenType,
 		Literal: l.TokenText(),
+		Pos:     l.start,
 	}
 }
 
@@ -7,6 +7,44 @@ import (
 	"github.com/wreulicke/monkey/token"
 )
 
+func TestNullTokens(t *testing.T) {
+	input := `null ?? a?.[0]?.() ? match x => y`
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.NULL, "null"},
+		{token.NULLISH, "??"},
+		{token.IDENT, "a"},
+		{token.OPTIONAL, "?."},
+		{token.LBRACKET, "["},
+		{token.NUMBER, "0"},
+		{token.RBRACKET, "]"},
+		{token.OPTIONAL, "?."},
+		{token.LPAREN, "("},
+		{token.RPAREN, ")"},
+		{token.ILLEGAL, "?"},
+		{token.MATCH, "match"},
+		{token.IDENT, "x"},
+		{token.ARROW, "=>"},
+		{token.IDENT, "y"},
+		{token.EOF, ""},
+	}
+	l := New(bytes.NewBufferString(input))
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - token type wrong. expected=%s, got=%s", i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
 func TestNextToken(t *testing.T) {
 	input := `let five = 5;
 let ten = 10;
@@ -30,6 +68,8 @@ if (5 < 10) {
 "a" | fn(x) { x + "2" };
 fn([x]) { x };
 fn({x}) { x };
+f >> g > h;
+f(...xs);
 `
 	tests := []struct {
 		expectedType    token.TokenType
@@ -176,6 +216,20 @@ fn({x}) { x };
 		{token.IDENT, "x"},
 		{token.RBRACE, "}"},
 		{token.SEMICOLON, ";"},
+		//
+		{token.IDENT, "f"},
+		{token.COMPOSE, ">>"},
+		{token.IDENT, "g"},
+		{token.GT, ">"},
+		{token.IDENT, "h"},
+		{token.SEMICOLON, ";"},
+		//
+		{token.IDENT, "f"},
+		{token.LPAREN, "("},
+		{token.ELLIPSIS, "..."},
+		{token.IDENT, "xs"},
+		{token.RPAREN, ")"},
+		{token.SEMICOLON, ";"},
 
 		//
 		{token.EOF, ""},
@@ -194,3 +248,93 @@ fn({x}) { x };
 		}
 	}
 }
+
+func TestIdentifiersWithDigits(t *testing.T) {
+	l := New(bytes.NewBufferString("log10 x2y 2x"))
+
+	expected := []token.Token{
+		{Type: token.IDENT, Literal: "log10"},
+		{Type: token.IDENT, Literal: "x2y"},
+		{Type: token.NUMBER, Literal: "2"},
+		{Type: token.IDENT, Literal: "x"},
+		{Type: token.EOF, Literal: ""},
+	}
+	for i, want := range expected {
+		tok := l.NextToken()
+		if tok.Type != want.Type || tok.Literal != want.Literal {
+			t.Fatalf("tests[%d] - token wrong. expected=%s %q, got=%s %q", i, want.Type, want.Literal, tok.Type, tok.Literal)
+		}
+	}
+}
+
+func TestStringEscapes(t *testing.T) {
+	l := New(bytes.NewBufferString(`"a\\d\"\n" 'b\''`))
+
+	for i, want := range []string{"a\\d\"\n", "b'"} {
+		tok := l.NextToken()
+		if tok.Type != token.STRING || tok.Literal != want {
+			t.Fatalf("tests[%d] - token wrong. expected=STRING %q, got=%s %q", i, want, tok.Type, tok.Literal)
+		}
+	}
+}
+
+func TestTokenPositions(t *testing.T) {
+	input := "let x = \"ab\";\n\tx ?? 10\n"
+	tests := []struct {
+		expectedType token.TokenType
+		expectedPos  token.Position
+	}{
+		{token.LET, token.Position{Line: 1, Column: 1}},
+		{token.IDENT, token.Position{Line: 1, Column: 5}},
+		{token.ASSIGN, token.Position{Line: 1, Column: 7}},
+		{token.STRING, token.Position{Line: 1, Column: 9}},
+		{token.SEMICOLON, token.Position{Line: 1, Column: 13}},
+		{token.IDENT, token.Position{Line: 2, Column: 2}},
+		{token.NULLISH, token.Position{Line: 2, Column: 4}},
+		{token.NUMBER, token.Position{Line: 2, Column: 7}},
+		{token.EOF, token.Position{Line: 3, Column: 1}},
+	}
+	l := New(bytes.NewBufferString(input))
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - token type wrong. expected=%s, got=%s", i, tt.expectedType, tok.Type)
+		}
+		if tok.Pos != tt.expectedPos {
+			t.Fatalf("tests[%d] - position wrong. expected=%s, got=%s", i, tt.expectedPos, tok.Pos)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := "// head\nlet x = 1 / 2; // half  \n//\nx"
+	l := New(bytes.NewBufferString(input))
+
+	expectedTypes := []token.TokenType{
+		token.LET, token.IDENT, token.ASSIGN, token.NUMBER, token.SLASH, token.NUMBER,
+		token.SEMICOLON, token.IDENT, token.EOF,
+	}
+	for i, expected := range expectedTypes {
+		tok := l.NextToken()
+		if tok.Type != expected {
+			t.Fatalf("tests[%d] - token type wrong. expected=%s, got=%s", i, expected, tok.Type)
+		}
+	}
+
+	expected := []token.Token{
+		{Type: token.COMMENT, Literal: "// head", Pos: token.Position{Line: 1, Column: 1}},
+		{Type: token.COMMENT, Literal: "// half", Pos: token.Position{Line: 2, Column: 16}},
+		{Type: token.COMMENT, Literal: "//", Pos: token.Position{Line: 3, Column: 1}},
+	}
+	comments := l.Comments()
+	if len(comments) != len(expected) {
+		t.Fatalf("wrong number of comments. expected=%d, got=%d", len(expected), len(comments))
+	}
+	for i, c := range comments {
+		if c != expected[i] {
+			t.Errorf("comments[%d] wrong. expected=%+v, got=%+v", i, expected[i], c)
+		}
+	}
+}
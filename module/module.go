@@ -0,0 +1,123 @@
+// Package module finds, parses and caches the modules Monkey programs import.
+//
+// An import path starting with "./" or "../" is relative to the directory
+// of the importing file, or to the working directory when the importing
+// program was not read from a file. Other paths are looked up in each
+// directory of the search path in turn, which the REPLs and commands take
+// from MONKEYPATH. The extension Ext is added to paths without one.
+package module
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/wreulicke/monkey/ast"
+	"github.com/wreulicke/monkey/lexer"
+	"github.com/wreulicke/monkey/parser"
+)
+
+// Ext is the extension of Monkey source files.
+const Ext = ".mk"
+
+// LoadFunc turns the parsed module read from file into the value importing
+// it evaluates to.
+type LoadFunc func(file string, program *ast.Program) (interface{}, error)
+
+// Loader loads each module once and reports import cycles. The values it
+// caches are the ones returned by the LoadFunc, so a Loader is meant to be
+// used by a single engine.
+type Loader struct {
+	// Path lists the directories searched for paths that are not relative.
+	Path []string
+
+	modules map[string]interface{}
+	// loading lists the modules being loaded, innermost last.
+	loading []string
+}
+
+// SearchPath returns the directories listed in the MONKEYPATH environment
+// variable.
+func SearchPath() []string {
+	return filepath.SplitList(os.Getenv("MONKEYPATH"))
+}
+
+func NewLoader(path ...string) *Loader {
+	return &Loader{Path: path, modules: map[string]interface{}{}}
+}
+
+// Import returns the value of the module path imported from the file from,
+// calling load the first time the module is imported.
+func (l *Loader) Import(path, from string, load LoadFunc) (interface{}, error) {
+	file, err := l.Resolve(path, from)
+	if err != nil {
+		return nil, err
+	}
+	if v, ok := l.modules[file]; ok {
+		return v, nil
+	}
+	if len(l.loading) == 0 && from != "" {
+		// the importing program is the first module of any cycle
+		if root, err := filepath.Abs(from); err == nil {
+			l.loading = append(l.loading, root)
+			defer func() { l.loading = l.loading[:0] }()
+		}
+	}
+	for i, f := range l.loading {
+		if f == file {
+			return nil, fmt.Errorf("import cycle: %s", strings.Join(append(l.loading[i:], file), " -> "))
+		}
+	}
+
+	src, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	p := parser.New(lexer.New(bytes.NewReader(src)))
+	program := p.Parse()
+	if errs := p.Errors(); len(errs) != 0 {
+		return nil, fmt.Errorf("%s: %s", file, errs[0])
+	}
+
+	l.loading = append(l.loading, file)
+	v, err := load(file, program)
+	l.loading = l.loading[:len(l.loading)-1]
+	if err != nil {
+		return nil, err
+	}
+	l.modules[file] = v
+	return v, nil
+}
+
+// Resolve returns the absolute path of the file the module path imported
+// from the file from refers to.
+func (l *Loader) Resolve(path, from string) (string, error) {
+	if filepath.Ext(path) == "" {
+		path += Ext
+	}
+
+	var candidates []string
+	if strings.HasPrefix(path, "./") || strings.HasPrefix(path, "../") {
+		dir := "."
+		if from != "" {
+			dir = filepath.Dir(from)
+		}
+		candidates = append(candidates, filepath.Join(dir, path))
+	} else if filepath.IsAbs(path) {
+		candidates = append(candidates, path)
+	} else {
+		for _, dir := range l.Path {
+			candidates = append(candidates, filepath.Join(dir, path))
+		}
+	}
+
+	for _, c := range candidates {
+		if info, err := os.Stat(c); err == nil && !info.IsDir() {
+			return filepath.Abs(c)
+		}
+	}
+	return "", fmt.Errorf("cannot find module %q", path)
+}
@@ -0,0 +1,131 @@
+package module
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/wreulicke/monkey/ast"
+)
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "monkey-module")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	for name, src := range files {
+		file := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestResolve(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.mk":     "",
+		"a.mk":        "",
+		"sub/b.mk":    "",
+		"lib/c.mk":    "",
+		"lib/sub/d.x": "",
+	})
+	l := NewLoader(filepath.Join(dir, "lib"))
+	from := filepath.Join(dir, "sub", "main.mk")
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"./b", "sub/b.mk"},
+		{"./b.mk", "sub/b.mk"},
+		{"../a", "a.mk"},
+		{"c", "lib/c.mk"},
+		{"sub/d.x", "lib/sub/d.x"},
+		{filepath.Join(dir, "a.mk"), "a.mk"},
+	}
+	for _, tt := range tests {
+		file, err := l.Resolve(tt.path, from)
+		if err != nil {
+			t.Errorf("Resolve(%q) failed: %s", tt.path, err)
+			continue
+		}
+		if file != filepath.Join(dir, tt.expected) {
+			t.Errorf("wrong file for %q. want=%s, got=%s", tt.path, filepath.Join(dir, tt.expected), file)
+		}
+	}
+
+	for _, path := range []string{"./c", "a", "../missing"} {
+		_, err := l.Resolve(path, from)
+		if err == nil {
+			t.Errorf("Resolve(%q) should fail", path)
+			continue
+		}
+		if !strings.HasPrefix(err.Error(), "cannot find module") {
+			t.Errorf("wrong error for %q. got=%q", path, err)
+		}
+	}
+}
+
+func TestImport(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.mk": "",
+		"a.mk":    `import "./b"`,
+		"b.mk":    `import "./a"`,
+		"c.mk":    `let = 1`,
+		"d.mk":    `1`,
+	})
+	main := filepath.Join(dir, "main.mk")
+	l := NewLoader()
+
+	var loaded []string
+	var load LoadFunc
+	load = func(file string, program *ast.Program) (interface{}, error) {
+		loaded = append(loaded, filepath.Base(file))
+		for _, s := range program.Statements {
+			if e, ok := s.(*ast.ExpressionStatement); ok {
+				if i, ok := e.Expression.(*ast.ImportExpression); ok {
+					if _, err := l.Import(i.Path.Value, file, load); err != nil {
+						return nil, err
+					}
+				}
+			}
+		}
+		return len(loaded), nil
+	}
+
+	for i := 0; i < 2; i++ {
+		v, err := l.Import("./d", main, load)
+		if err != nil {
+			t.Fatalf("Import failed: %s", err)
+		}
+		if v != 1 {
+			t.Errorf("wrong value. want=1, got=%v", v)
+		}
+	}
+
+	_, err := l.Import("./a", main, load)
+	expected := "import cycle: " + filepath.Join(dir, "a.mk") + " -> " + filepath.Join(dir, "b.mk") + " -> " + filepath.Join(dir, "a.mk")
+	if err == nil || err.Error() != expected {
+		t.Errorf("wrong error. want=%q, got=%v", expected, err)
+	}
+
+	_, err = l.Import("./c", main, load)
+	expected = filepath.Join(dir, "c.mk") + ": expected pattern, got ASSIGN instead"
+	if err == nil || err.Error() != expected {
+		t.Errorf("wrong error. want=%q, got=%v", expected, err)
+	}
+
+	_, err = l.Import("./main", main, load)
+	expected = "import cycle: " + main + " -> " + main
+	if err == nil || err.Error() != expected {
+		t.Errorf("wrong error. want=%q, got=%v", expected, err)
+	}
+}
@@ -0,0 +1,258 @@
+// Package monkey embeds Monkey programs in Go. An Engine runs programs on
+// the interpreter or the VM, and converts the Go values a host passes in
+// and out to and from Monkey objects.
+package monkey
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/wreulicke/monkey/ast"
+	"github.com/wreulicke/monkey/compiler"
+	"github.com/wreulicke/monkey/interpreter"
+	"github.com/wreulicke/monkey/lexer"
+	"github.com/wreulicke/monkey/object"
+	"github.com/wreulicke/monkey/parser"
+	"github.com/wreulicke/monkey/vm"
+)
+
+// Kind is the kind of engine running the programs.
+type Kind int
+
+const (
+	// Interpreter evaluates the syntax tree of a program.
+	Interpreter Kind = iota
+	// VM compiles a program to bytecode and runs it on the virtual machine.
+	VM
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Interpreter:
+		return "interpreter"
+	case VM:
+		return "vm"
+	}
+	return fmt.Sprintf("Kind(%d)", int(k))
+}
+
+// Engine runs programs one after another, like a REPL: the globals a
+// program defines, and the ones the host sets, are seen by the programs
+// run after it. An Engine is not safe for concurrent use.
+type Engine struct {
+	kind     Kind
+	ctx      *object.Context
+	macroEnv *object.Environment
+
+	// env holds the globals of the interpreter.
+	env *object.Environment
+
+	// symbolTable, constants and globals hold the globals of the VM.
+	symbolTable *compiler.SymbolTable
+	constants   []object.Object
+	globals     []object.Object
+}
+
+// NewEngine returns an engine of kind giving programs ctx. A nil ctx is an
+// empty context, which grants programs no capabilities. Without Builtins
+// in ctx, the engine gets its own registry of the default builtins, to
+// which Register adds.
+func NewEngine(kind Kind, ctx *object.Context) *Engine {
+	if ctx == nil {
+		ctx = &object.Context{}
+	}
+	if ctx.Builtins == nil {
+		copied := *ctx
+		copied.Builtins = object.NewDefaultRegistry()
+		ctx = &copied
+	}
+	e := &Engine{kind: kind, ctx: ctx, macroEnv: object.NewEnvironment()}
+	switch kind {
+	case VM:
+		e.symbolTable = compiler.NewSymbolTable()
+		e.symbolTable.SetBuiltins(ctx.BuiltinRegistry())
+		e.constants = []object.Object{}
+		e.globals = make([]object.Object, vm.GlobalsSize)
+	default:
+		e.kind = Interpreter
+		e.env = object.NewEnvironment()
+		e.env.SetContext(ctx)
+	}
+	return e
+}
+
+// Kind returns the kind of the engine.
+func (e *Engine) Kind() Kind {
+	return e.kind
+}
+
+// Builtins returns the registry of the builtins of the engine.
+func (e *Engine) Builtins() *object.Registry {
+	return e.ctx.Builtins
+}
+
+// Register defines the builtin name as the Go function fn, converted as
+// ToObject converts functions. It takes as many arguments as fn does, and
+// fails with another number of arguments. A function of the type of
+// object.BuiltinFunction is registered as it is, checking its arguments
+// itself. Programs run from then on can call the builtin unless they
+// define name, and programs compiled before call the new function when
+// they refer to a builtin of that name.
+func (e *Engine) Register(name string, fn interface{}) error {
+	builtin, arity, err := builtinOf(fn)
+	if err != nil {
+		return fmt.Errorf("builtin %s: %w", name, err)
+	}
+	_, err = e.ctx.Builtins.Register(name, arity, builtin.Fn)
+	return err
+}
+
+// Eval runs src and returns the value of its last statement, or null when
+// that is not an expression. A program failing to parse, to expand its
+// macros, to compile or to run is an error. The program stops with an
+// *object.CanceledError when ctx is done before it ends. A panic in the
+// engine, or in a function the host registered, is an error too.
+func (e *Engine) Eval(ctx context.Context, src string) (result object.Object, err error) {
+	defer recoverPanic(&result, &err)
+	if err := ctx.Err(); err != nil {
+		return nil, &object.CanceledError{Err: err}
+	}
+	p := parser.New(lexer.New(bytes.NewBufferString(src)))
+	program := p.Parse()
+	if errs := p.Errors(); len(errs) != 0 {
+		messages := make([]string, len(errs))
+		for i, err := range errs {
+			messages[i] = err.Error()
+		}
+		return nil, fmt.Errorf("cannot parse: %s", strings.Join(messages, "; "))
+	}
+	interpreter.DefineMacros(program, e.macroEnv)
+	expanded, expandErr := interpreter.ExpandMacros(program, e.macroEnv)
+	if expandErr != nil {
+		return nil, errors.New(expandErr.Message)
+	}
+
+	switch e.kind {
+	case VM:
+		comp := compiler.NewWithState(e.symbolTable, e.constants)
+		comp.SetContext(e.ctx)
+		err := comp.Compile(expanded)
+		// keep the constants of modules loaded before a failure, which
+		// the module loader refers to from then on
+		bytecode := comp.Bytecode()
+		e.constants = bytecode.Constants
+		if err != nil {
+			return nil, err
+		}
+		machine := vm.NewWithGlobalsStore(bytecode, e.globals)
+		machine.SetContext(e.ctx)
+		if err := machine.RunContext(ctx); err != nil {
+			return nil, err
+		}
+		result = machine.LastPoppedStackElem()
+	default:
+		var err error
+		result, err = interpreter.EvalContext(ctx, expanded, e.env)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err, ok := result.(*object.Error); ok {
+		return nil, errors.New(err.Message)
+	}
+	if !endsWithExpression(expanded) || result == nil {
+		return object.NullValue, nil
+	}
+	return result, nil
+}
+
+func endsWithExpression(node ast.Node) bool {
+	program, ok := node.(*ast.Program)
+	if !ok || len(program.Statements) == 0 {
+		return false
+	}
+	_, ok = program.Statements[len(program.Statements)-1].(*ast.ExpressionStatement)
+	return ok
+}
+
+// SetGlobal binds name to value, converted by ToObject, for the programs
+// run from then on.
+func (e *Engine) SetGlobal(name string, value interface{}) error {
+	o, err := ToObject(value)
+	if err != nil {
+		return fmt.Errorf("global %s: %w", name, err)
+	}
+	if e.kind == VM {
+		symbol, ok := e.symbolTable.Resolve(name)
+		if !ok || symbol.Scope != compiler.GlobalScope {
+			symbol = e.symbolTable.Define(name)
+		}
+		e.globals[symbol.Index] = o
+		return nil
+	}
+	e.env.Set(name, o)
+	return nil
+}
+
+// GetGlobal returns the value of the global name, and whether it is
+// defined.
+func (e *Engine) GetGlobal(name string) (object.Object, bool) {
+	if e.kind == VM {
+		symbol, ok := e.symbolTable.Resolve(name)
+		if !ok || symbol.Scope != compiler.GlobalScope || e.globals[symbol.Index] == nil {
+			return nil, false
+		}
+		return e.globals[symbol.Index], true
+	}
+	return e.env.Get(name)
+}
+
+// Call calls fn like CallContext, running until the call returns.
+func (e *Engine) Call(fn object.Object, args ...interface{}) (object.Object, error) {
+	return e.CallContext(context.Background(), fn, args...)
+}
+
+// CallContext calls fn, a function of a program run by the engine or a
+// builtin, with args converted by ToObject. A failing or panicking call is
+// an error. The call stops with an *object.CanceledError when ctx is done
+// before it returns.
+func (e *Engine) CallContext(ctx context.Context, fn object.Object, args ...interface{}) (result object.Object, err error) {
+	defer recoverPanic(&result, &err)
+	if err := ctx.Err(); err != nil {
+		return nil, &object.CanceledError{Err: err}
+	}
+	objects := make([]object.Object, len(args))
+	for i, arg := range args {
+		o, err := ToObject(arg)
+		if err != nil {
+			return nil, fmt.Errorf("argument %d: %w", i, err)
+		}
+		objects[i] = o
+	}
+	if e.kind == VM {
+		machine := vm.NewWithGlobalsStore(&compiler.Bytecode{Constants: e.constants}, e.globals)
+		machine.SetContext(e.ctx.WithDone(ctx))
+		result = machine.Call(fn, objects...)
+	} else {
+		result = interpreter.Apply(fn, objects, e.ctx.WithDone(ctx))
+	}
+	if err, ok := result.(*object.Error); ok {
+		if err.Canceled() {
+			return nil, err.Err
+		}
+		return nil, errors.New(err.Message)
+	}
+	return result, nil
+}
+
+// recoverPanic turns a panic into the error of the function deferring it,
+// so that a bug in the engine or in a host function does not bring down
+// the host.
+func recoverPanic(result *object.Object, err *error) {
+	if r := recover(); r != nil {
+		*result, *err = nil, fmt.Errorf("panic: %v", r)
+	}
+}
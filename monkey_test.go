@@ -0,0 +1,389 @@
+package monkey
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wreulicke/monkey/module"
+	"github.com/wreulicke/monkey/object"
+)
+
+var kinds = []Kind{Interpreter, VM}
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		input    []string
+		expected string
+	}{
+		{[]string{"1 + 2"}, "3"},
+		{[]string{"let x = 2;", "x * 3"}, "6"},
+		{[]string{"let add = fn(a, b) { a + b };", "add(1, 2)"}, "3"},
+		{[]string{"let unless = macro(c, x) { quote(if (!unquote(c)) { unquote(x) }) };", "unless(false, 10)"}, "10"},
+		{[]string{"let x = 1;"}, "null"},
+		{[]string{""}, "null"},
+	}
+	for _, kind := range kinds {
+		for _, tt := range tests {
+			e := NewEngine(kind, nil)
+			var result object.Object
+			for _, src := range tt.input {
+				var err error
+				result, err = e.Eval(context.Background(), src)
+				if err != nil {
+					t.Fatalf("%s: %q: %s", kind, src, err)
+				}
+			}
+			if result.Inspect() != tt.expected {
+				t.Errorf("%s: %q: expected %s, got %s", kind, tt.input, tt.expected, result.Inspect())
+			}
+		}
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let = 1", "cannot parse"},
+		{`int("z")`, "cannot parse \"z\" as INTEGER"},
+		{"readFile(\"x\")", "readFile"},
+		{"1 / 0", "division by zero: 1 / 0"},
+		{"let [a, b] = [1]; b", "cannot destructure an array of length 1 with an array pattern of length 2"},
+		{`let {a, b} = {"a": 1}; b`, `cannot destructure a hash without the key "b"`},
+		{"let [a, b] = 5", "cannot destructure INTEGER with an array pattern"},
+		{"let {a} = fn() {}", "cannot destructure FUNCTION with a hash pattern"},
+		{`let h = {"a": {}}; h.a?.b.c`, "index operator not supported: NULL"},
+		{`json.stringify(fn() {})`, "json.stringify: cannot encode FUNCTION"},
+	}
+	for _, kind := range kinds {
+		for _, tt := range tests {
+			_, err := NewEngine(kind, nil).Eval(context.Background(), tt.input)
+			if err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("%s: %q: expected an error containing %q, got %v", kind, tt.input, tt.expected, err)
+			}
+		}
+	}
+}
+
+func TestTryDivisionByZero(t *testing.T) {
+	for _, kind := range kinds {
+		result, err := NewEngine(kind, nil).Eval(context.Background(), `try(fn() { 1 / 0 }).error`)
+		if err != nil {
+			t.Fatalf("%s: %s", kind, err)
+		}
+		if result.Inspect() != "division by zero: 1 / 0" {
+			t.Errorf("%s: expected try to catch the division by zero, got %s", kind, result.Inspect())
+		}
+	}
+}
+
+func TestStackOverflow(t *testing.T) {
+	for _, kind := range kinds {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		_, err := NewEngine(kind, nil).Eval(ctx, `let x = fn() { x() }; x()`)
+		cancel()
+		if err == nil || err.Error() != "stack overflow" {
+			t.Errorf("%s: expected a stack overflow, got %v", kind, err)
+		}
+	}
+}
+
+func TestPanics(t *testing.T) {
+	for _, kind := range kinds {
+		e := NewEngine(kind, nil)
+		if err := e.Register("boom", func() { panic("boom") }); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := e.Eval(context.Background(), `boom()`); err == nil || err.Error() != "panic: boom" {
+			t.Errorf("%s: expected the panic to be an error, got %v", kind, err)
+		}
+		boom, _ := e.Builtins().Lookup("boom")
+		if _, err := e.Call(boom); err == nil || err.Error() != "panic: boom" {
+			t.Errorf("%s: expected the panic of a call to be an error, got %v", kind, err)
+		}
+		if result, err := e.Eval(context.Background(), "1 + 1"); err != nil || result.Inspect() != "2" {
+			t.Errorf("%s: expected the engine to run after a panic, got %v, %v", kind, result, err)
+		}
+	}
+}
+
+func TestEvalCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, kind := range kinds {
+		_, err := NewEngine(kind, nil).Eval(ctx, "1")
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("%s: expected context.Canceled, got %v", kind, err)
+		}
+	}
+}
+
+func TestEvalTimeout(t *testing.T) {
+	for _, kind := range kinds {
+		e := NewEngine(kind, nil)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		_, err := e.Eval(ctx, `range(0, 1000).map(fn(i) { range(0, 1000).map(fn(j) { i * j }) })`)
+		cancel()
+		var canceled *object.CanceledError
+		if !errors.As(err, &canceled) || !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("%s: expected the deadline to be exceeded, got %v", kind, err)
+		}
+		if result, err := e.Eval(context.Background(), "1 + 1"); err != nil || result.Inspect() != "2" {
+			t.Errorf("%s: expected the engine to run after a timeout, got %v, %v", kind, result, err)
+		}
+	}
+}
+
+func TestModuleAfterCancel(t *testing.T) {
+	dir := t.TempDir()
+	src := `let double = fn(x) { x * 2 }; export let f = fn(x) { double(x) }; export let spin = fn(n) { range(0, n).map(fn(i) { range(0, n).map(f) }) };`
+	if err := ioutil.WriteFile(filepath.Join(dir, "m"+module.Ext), []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, kind := range kinds {
+		e := NewEngine(kind, &object.Context{File: filepath.Join(dir, "main"+module.Ext), Modules: module.NewLoader()})
+		ctx, cancel := context.WithCancel(context.Background())
+		if result, err := e.Eval(ctx, `(import "./m").f(1)`); err != nil || result.Inspect() != "2" {
+			t.Fatalf("%s: expected 2, got %v, %v", kind, result, err)
+		}
+		cancel()
+		if result, err := e.Eval(context.Background(), `(import "./m").f(2)`); err != nil || result.Inspect() != "4" {
+			t.Errorf("%s: expected the module to run after the program importing it is canceled, got %v, %v", kind, result, err)
+		}
+		ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+		_, err := e.Eval(ctx, `(import "./m").spin(1000)`)
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("%s: expected the module to stop with the program calling it, got %v", kind, err)
+		}
+	}
+}
+
+func TestCanceledCallback(t *testing.T) {
+	for _, kind := range kinds {
+		e := NewEngine(kind, nil)
+		ctx, cancel := context.WithCancel(context.Background())
+		e.Register("cancel", cancel)
+		e.Register("each", func(xs []int, f func(int) error) error {
+			for _, x := range xs {
+				if err := f(x); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		_, err := e.Eval(ctx, `each([1, 2], fn(x) { cancel() })`)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("%s: expected the program to be canceled, got %v", kind, err)
+		}
+	}
+}
+
+func TestGlobals(t *testing.T) {
+	tests := []struct {
+		value    interface{}
+		input    string
+		expected string
+	}{
+		{42, "x + 1", "43"},
+		{uint8(7), "x", "7"},
+		{1.5, "x * 2", "3.0"},
+		{"go", "x.upper()", "GO"},
+		{true, "!x", "false"},
+		{nil, "x ?? 1", "1"},
+		{[]int{1, 2, 3}, "x.map(fn(v) { v * 2 })", "[2, 4, 6]"},
+		{[]byte("abc"), "len(x)", "3"},
+		{map[string]int{"b": 2, "a": 1}, "x", "{a: 1, b: 2}"},
+		{map[string]interface{}{"xs": []string{"p"}}, "x.xs[0]", "p"},
+		{&object.Integer{Value: 5}, "x", "5"},
+		{strings.Repeat, "x(\"ab\", 2)", "abab"},
+		{strconv.Atoi, "x(\"12\") + 1", "13"},
+		{func(xs ...int) int { return len(xs) }, "x(1, 2, 3)", "3"},
+		{func(a, b int) (int, int) { return b, a }, "x(1, 2)", "[2, 1]"},
+		{func(m map[string]interface{}) interface{} { return m["k"] }, "x({\"k\": [1]})", "[1]"},
+		{func(f func(int) int) int { return f(2) + 1 }, "x(fn(v) { v * 10 })", "21"},
+	}
+	for _, kind := range kinds {
+		for _, tt := range tests {
+			e := NewEngine(kind, nil)
+			if err := e.SetGlobal("x", tt.value); err != nil {
+				t.Fatalf("%s: %T: %s", kind, tt.value, err)
+			}
+			result, err := e.Eval(context.Background(), tt.input)
+			if err != nil {
+				t.Errorf("%s: %T: %q: %s", kind, tt.value, tt.input, err)
+				continue
+			}
+			if result.Inspect() != tt.expected {
+				t.Errorf("%s: %T: %q: expected %s, got %s", kind, tt.value, tt.input, tt.expected, result.Inspect())
+			}
+		}
+	}
+}
+
+func TestGoFunctionErrors(t *testing.T) {
+	tests := []struct {
+		value    interface{}
+		input    string
+		expected string
+	}{
+		{strconv.Atoi, "x(\"z\")", "invalid syntax"},
+		{strings.Repeat, "x(\"a\")", "wrong number of arguments: want=2, got=1"},
+		{strings.Repeat, "x(1, 2)", "argument 0: cannot use INTEGER as string"},
+		{func(b int8) int8 { return b }, "x(300)", "out of the range of int8"},
+		{func(f func() (int, error)) error { _, err := f(); return err }, "x(fn() { int(\"z\") })", "cannot parse"},
+	}
+	for _, kind := range kinds {
+		for _, tt := range tests {
+			e := NewEngine(kind, nil)
+			if err := e.SetGlobal("x", tt.value); err != nil {
+				t.Fatalf("%s: %T: %s", kind, tt.value, err)
+			}
+			_, err := e.Eval(context.Background(), tt.input)
+			if err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("%s: %q: expected an error containing %q, got %v", kind, tt.input, tt.expected, err)
+			}
+		}
+	}
+}
+
+func TestSetGlobalUnsupported(t *testing.T) {
+	for _, kind := range kinds {
+		if err := NewEngine(kind, nil).SetGlobal("x", struct{}{}); err == nil {
+			t.Errorf("%s: expected an error", kind)
+		}
+	}
+}
+
+func TestGetGlobalAndCall(t *testing.T) {
+	for _, kind := range kinds {
+		e := NewEngine(kind, nil)
+		if _, ok := e.GetGlobal("add"); ok {
+			t.Fatalf("%s: add is defined before the program runs", kind)
+		}
+		if _, err := e.Eval(context.Background(), "let add = fn(a, b = 10) { a + b }; let total = add(1, 2);"); err != nil {
+			t.Fatalf("%s: %s", kind, err)
+		}
+		total, ok := e.GetGlobal("total")
+		if !ok || total.Inspect() != "3" {
+			t.Errorf("%s: expected total 3, got %v", kind, total)
+		}
+		add, _ := e.GetGlobal("add")
+		result, err := e.Call(add, 5)
+		if err != nil {
+			t.Fatalf("%s: %s", kind, err)
+		}
+		if result.Inspect() != "15" {
+			t.Errorf("%s: expected 15, got %s", kind, result.Inspect())
+		}
+		if _, err := e.Call(add, "a", 1); err == nil {
+			t.Errorf("%s: expected adding a string and an integer to fail", kind)
+		}
+		if _, err := e.Call(add); err == nil {
+			t.Errorf("%s: expected calling with too few arguments to fail", kind)
+		}
+	}
+}
+
+func TestCallContext(t *testing.T) {
+	for _, kind := range kinds {
+		e := NewEngine(kind, nil)
+		if _, err := e.Eval(context.Background(), `let loop = fn(n) { range(0, n).map(fn(i) { range(0, n).map(fn(j) { i * j }) }) };`); err != nil {
+			t.Fatalf("%s: %s", kind, err)
+		}
+		loop, _ := e.GetGlobal("loop")
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		_, err := e.CallContext(ctx, loop, 1000)
+		cancel()
+		var canceled *object.CanceledError
+		if !errors.As(err, &canceled) || !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("%s: expected the deadline to be exceeded, got %v", kind, err)
+		}
+		if _, err := e.CallContext(ctx, loop, 1); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("%s: expected a call with a done context not to run, got %v", kind, err)
+		}
+		if result, err := e.Call(loop, 2); err != nil || result.Inspect() != "[[0, 0], [0, 1]]" {
+			t.Errorf("%s: expected the engine to call after a timeout, got %v, %v", kind, result, err)
+		}
+	}
+}
+
+func TestContext(t *testing.T) {
+	for _, kind := range kinds {
+		var out bytes.Buffer
+		e := NewEngine(kind, &object.Context{Stdout: &out})
+		if _, err := e.Eval(context.Background(), `puts("hi")`); err != nil {
+			t.Fatalf("%s: %s", kind, err)
+		}
+		if out.String() != "hi\n" {
+			t.Errorf("%s: expected hi, got %q", kind, out.String())
+		}
+	}
+}
+
+func TestToGo(t *testing.T) {
+	e := NewEngine(Interpreter, nil)
+	result, err := e.Eval(context.Background(), `[1, 1.5, "s", true, null, {"a": [1]}, {1: 2}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []interface{}{
+		int64(1), 1.5, "s", true, nil,
+		map[string]interface{}{"a": []interface{}{int64(1)}},
+		map[interface{}]interface{}{int64(1): int64(2)},
+	}
+	if got := ToGo(result); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %#v, got %#v", expected, got)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	for _, kind := range kinds {
+		e := NewEngine(kind, nil)
+		if err := e.Register("greet", func(name string) string { return "hi " + name }); err != nil {
+			t.Fatal(err)
+		}
+		if err := e.Register("sum", func(xs ...int) int {
+			total := 0
+			for _, x := range xs {
+				total += x
+			}
+			return total
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if err := e.Register("count", func(rt object.Runtime, args ...object.Object) object.Object {
+			return &object.Integer{Value: int64(len(args))}
+		}); err != nil {
+			t.Fatal(err)
+		}
+		result, err := e.Eval(context.Background(), `[greet("bob"), sum(), sum(1, 2), count(1, 2, 3)]`)
+		if err != nil {
+			t.Fatalf("%s: %s", kind, err)
+		}
+		if result.Inspect() != "[hi bob, 0, 3, 3]" {
+			t.Errorf("%s: expected [hi bob, 0, 3, 3], got %s", kind, result.Inspect())
+		}
+		if def, _ := e.Builtins().Def("greet"); def.Arity != (object.Arity{Min: 1, Max: 1}) {
+			t.Errorf("%s: wrong arity of greet: %+v", kind, def.Arity)
+		}
+		if _, err := e.Eval(context.Background(), `greet()`); err == nil || err.Error() != "wrong number of arguments: want=1, got=0" {
+			t.Errorf("%s: expected an arity error, got %v", kind, err)
+		}
+		if err := e.Register("bad", 1); err == nil {
+			t.Errorf("%s: expected registering a non-function to fail", kind)
+		}
+		if _, err := NewEngine(kind, nil).Eval(context.Background(), `greet("bob")`); err == nil {
+			t.Errorf("%s: expected greet to be undefined in another engine", kind)
+		}
+	}
+}
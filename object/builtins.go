@@ -1,15 +1,25 @@
 package object
 
-import "fmt"
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
 
+// Builtins are the values predefined in both engines, in the order
+// OpGetBuiltin refers to them. Most are functions, namespaces such as
+// `json`, `math` and `regex` are hashes of their members.
 var Builtins = []struct {
 	Name    string
-	Builtin *Builtin
+	Builtin Object
 }{
 	{
 		"len",
 		&Builtin{
-			Fn: func(args ...Object) Object {
+			Fn: func(rt Runtime, args ...Object) Object {
 				if len(args) != 1 {
 					return newError("wrong number of arguments. got=%d, want=1",
 						len(args))
@@ -18,7 +28,7 @@ var Builtins = []struct {
 				case *Array:
 					return &Integer{Value: int64(len(arg.Elements))}
 				case *String:
-					return &Integer{Value: int64(len(arg.Value))}
+					return &Integer{Value: int64(utf8.RuneCountInString(arg.Value))}
 				default:
 					return newError("argument to `len` not supported, got %s",
 						args[0].Type())
@@ -28,9 +38,10 @@ var Builtins = []struct {
 	},
 	{
 		"puts",
-		&Builtin{Fn: func(args ...Object) Object {
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			w := stdout(rt)
 			for _, arg := range args {
-				fmt.Println(arg.Inspect())
+				fmt.Fprintln(w, arg.Inspect())
 			}
 			return nil
 		},
@@ -38,7 +49,7 @@ var Builtins = []struct {
 	},
 	{
 		"first",
-		&Builtin{Fn: func(args ...Object) Object {
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
 			if len(args) != 1 {
 				return newError("wrong number of arguments. got=%d, want=1",
 					len(args))
@@ -56,7 +67,7 @@ var Builtins = []struct {
 		},
 	}, {
 		"last",
-		&Builtin{Fn: func(args ...Object) Object {
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
 			if len(args) != 1 {
 				return newError("wrong number of arguments. got=%d, want=1",
 					len(args))
@@ -76,7 +87,7 @@ var Builtins = []struct {
 	},
 	{
 		"rest",
-		&Builtin{Fn: func(args ...Object) Object {
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
 			if len(args) != 1 {
 				return newError("wrong number of arguments. got=%d, want=1",
 					len(args))
@@ -98,7 +109,7 @@ var Builtins = []struct {
 	},
 	{
 		"push",
-		&Builtin{Fn: func(args ...Object) Object {
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
 			if len(args) != 2 {
 				return newError("wrong number of arguments. got=%d, want=2",
 					len(args))
@@ -116,12 +127,182 @@ var Builtins = []struct {
 		},
 		},
 	},
+	{"split", &Builtin{Fn: stringSplit}},
+	{"join", &Builtin{Fn: stringJoin}},
+	{"trim", &Builtin{Fn: stringFunc("trim", strings.TrimSpace)}},
+	{"upper", &Builtin{Fn: stringFunc("upper", strings.ToUpper)}},
+	{"lower", &Builtin{Fn: stringFunc("lower", strings.ToLower)}},
+	{"replace", &Builtin{Fn: stringReplace}},
+	{"contains", &Builtin{Fn: stringPredicate("contains", strings.Contains)}},
+	{"startsWith", &Builtin{Fn: stringPredicate("startsWith", strings.HasPrefix)}},
+	{"endsWith", &Builtin{Fn: stringPredicate("endsWith", strings.HasSuffix)}},
+	{"indexOf", &Builtin{Fn: stringIndexOf}},
+	{"substr", &Builtin{Fn: stringSubstr}},
+	{"repeat", &Builtin{Fn: stringRepeat}},
+	{"chars", &Builtin{Fn: stringChars}},
+	{"map", &Builtin{Fn: arrayMap}},
+	{"filter", &Builtin{Fn: arrayFilter}},
+	{"reduce", &Builtin{Fn: arrayReduce}},
+	{"find", &Builtin{Fn: arrayFind}},
+	{"any", &Builtin{Fn: arrayTest("any", true)}},
+	{"all", &Builtin{Fn: arrayTest("all", false)}},
+	{"sort", &Builtin{Fn: arraySort}},
+	{"zip", &Builtin{Fn: arrayZip}},
+	{"range", &Builtin{Fn: integerRange}},
+	{"reverse", &Builtin{Fn: arrayReverse}},
+	{"flatten", &Builtin{Fn: arrayFlatten}},
+	{"uniq", &Builtin{Fn: arrayUniq}},
+	{"keys", &Builtin{Fn: hashKeys}},
+	{"values", &Builtin{Fn: hashValues}},
+	{"entries", &Builtin{Fn: hashEntries}},
+	{"has", &Builtin{Fn: hashHas}},
+	{"delete", &Builtin{Fn: hashDelete}},
+	{"merge", &Builtin{Fn: hashMerge}},
+	{"fromEntries", &Builtin{Fn: hashFromEntries}},
+	{"json", namespace(map[string]Object{
+		"parse":     &Builtin{Fn: jsonParse},
+		"stringify": &Builtin{Fn: jsonStringify},
+	})},
+	{"math", namespace(map[string]Object{
+		"PI":      &Float{Value: math.Pi},
+		"E":       &Float{Value: math.E},
+		"abs":     &Builtin{Fn: mathAbs},
+		"min":     &Builtin{Fn: mathExtreme("math.min", -1)},
+		"max":     &Builtin{Fn: mathExtreme("math.max", 1)},
+		"pow":     &Builtin{Fn: mathPow},
+		"sqrt":    &Builtin{Fn: mathFunc("math.sqrt", math.Sqrt)},
+		"floor":   &Builtin{Fn: mathRounding("math.floor", math.Floor)},
+		"ceil":    &Builtin{Fn: mathRounding("math.ceil", math.Ceil)},
+		"round":   &Builtin{Fn: mathRounding("math.round", math.Round)},
+		"sin":     &Builtin{Fn: mathFunc("math.sin", math.Sin)},
+		"cos":     &Builtin{Fn: mathFunc("math.cos", math.Cos)},
+		"tan":     &Builtin{Fn: mathFunc("math.tan", math.Tan)},
+		"asin":    &Builtin{Fn: mathFunc("math.asin", math.Asin)},
+		"acos":    &Builtin{Fn: mathFunc("math.acos", math.Acos)},
+		"atan":    &Builtin{Fn: mathFunc("math.atan", math.Atan)},
+		"atan2":   &Builtin{Fn: mathAtan2},
+		"exp":     &Builtin{Fn: mathFunc("math.exp", math.Exp)},
+		"log":     &Builtin{Fn: mathFunc("math.log", math.Log)},
+		"log2":    &Builtin{Fn: mathFunc("math.log2", math.Log2)},
+		"log10":   &Builtin{Fn: mathFunc("math.log10", math.Log10)},
+		"random":  &Builtin{Fn: mathRandom},
+		"randInt": &Builtin{Fn: mathRandInt},
+	})},
+	{"readFile", &Builtin{Fn: readFile}},
+	{"writeFile", &Builtin{Fn: writeTo("writeFile", os.O_TRUNC)}},
+	{"appendFile", &Builtin{Fn: writeTo("appendFile", os.O_APPEND)}},
+	{"listDir", &Builtin{Fn: listDir}},
+	{"exists", &Builtin{Fn: exists}},
+	{"stat", &Builtin{Fn: stat}},
+	{"readLine", &Builtin{Fn: readLine}},
+	{"try", &Builtin{Fn: tryCall}},
+	{"now", &Builtin{Fn: now}},
+	{"monotonic", &Builtin{Fn: monotonic}},
+	{"sleep", &Builtin{Fn: sleep}},
+	{"formatTime", &Builtin{Fn: formatTime}},
+	{"parseTime", &Builtin{Fn: parseTime}},
+	{"duration", &Builtin{Fn: duration}},
+	{"formatDuration", &Builtin{Fn: formatDuration}},
+	{"regex", namespace(map[string]Object{
+		"compile": &Builtin{Fn: regexCompile},
+	})},
+	{"print", &Builtin{Fn: printTo(stdout)}},
+	{"eprint", &Builtin{Fn: printTo(stderr)}},
+	{"sprintf", &Builtin{Fn: sprintf}},
+	{"printf", &Builtin{Fn: printf}},
+	{"type", &Builtin{Fn: typeOf}},
+	{"int", &Builtin{Fn: toInt}},
+	{"float", &Builtin{Fn: toFloat}},
+	{"str", &Builtin{Fn: toStr}},
+	{"bool", &Builtin{Fn: toBool}},
+	{"isInteger", &Builtin{Fn: typePredicate("isInteger", INTEGER)}},
+	{"isFloat", &Builtin{Fn: typePredicate("isFloat", FLOAT)}},
+	{"isNumber", &Builtin{Fn: typePredicate("isNumber", INTEGER, FLOAT)}},
+	{"isString", &Builtin{Fn: typePredicate("isString", STRING)}},
+	{"isBoolean", &Builtin{Fn: typePredicate("isBoolean", BOOLEAN)}},
+	{"isNull", &Builtin{Fn: typePredicate("isNull", NULL)}},
+	{"isArray", &Builtin{Fn: typePredicate("isArray", ARRAY)}},
+	{"isHash", &Builtin{Fn: typePredicate("isHash", HASH)}},
+	{"isFunction", &Builtin{Fn: typePredicate("isFunction", FUNCTION, CLOSURE, BUILTIN)}},
+	{"isRegex", &Builtin{Fn: typePredicate("isRegex", REGEX)}},
 }
 
+// anyType accepts an argument of any type, and numberType an INTEGER or a
+// FLOAT, in checkArgs.
+const (
+	anyType    ObjectType = -1
+	numberType ObjectType = -2
+)
+
+// checkArgs reports an error unless args holds values of the types want.
+func checkArgs(name string, args []Object, want ...ObjectType) *Error {
+	if len(args) != len(want) {
+		return newError("wrong number of arguments. got=%d, want=%d", len(args), len(want))
+	}
+	for i, t := range want {
+		switch {
+		case t == anyType:
+		case t == numberType:
+			if !isNumber(args[i]) {
+				return newError("argument to `%s` must be INTEGER or FLOAT, got %s", name, args[i].Type())
+			}
+		case args[i].Type() != t:
+			return newError("argument to `%s` must be %s, got %s", name, t, args[i].Type())
+		}
+	}
+	return nil
+}
+
+// namespace returns a hash holding members under their names, in the order
+// of the names, so that they are used as `namespace.name`.
+func namespace(members map[string]Object) *Hash {
+	names := make([]string, 0, len(members))
+	for name := range members {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	hash := NewHash()
+	for _, name := range names {
+		key := &String{Value: name}
+		hash.Set(key.HashKey(), HashPair{Key: key, Value: members[name]})
+	}
+	return hash
+}
+
+// tryCall calls fn with args and returns a hash telling how it went: ok is
+// true and value holds the result when it succeeds, ok is false and error
+// holds the message it failed with otherwise. The cancellation of the
+// program is not caught.
+func tryCall(rt Runtime, args ...Object) Object {
+	if len(args) == 0 {
+		return newError("wrong number of arguments. got=0, want at least 1")
+	}
+	var value, message Object = rt.Call(args[0], args[1:]...), NullValue
+	if err, ok := value.(*Error); ok {
+		if err.Canceled() {
+			return err
+		}
+		rt.Recover()
+		value, message = NullValue, &String{Value: err.Message}
+	}
+	result := NewHash()
+	for _, pair := range []HashPair{
+		{Key: &String{Value: "ok"}, Value: NativeBool(message == NullValue)},
+		{Key: &String{Value: "value"}, Value: value},
+		{Key: &String{Value: "error"}, Value: message},
+	} {
+		result.Set(pair.Key.(*String).HashKey(), pair)
+	}
+	return result
+}
+
+// GetBuiltinByName returns the builtin function name, or nil when there
+// is no such function.
 func GetBuiltinByName(name string) *Builtin {
 	for _, def := range Builtins {
 		if def.Name == name {
-			return def.Builtin
+			builtin, _ := def.Builtin.(*Builtin)
+			return builtin
 		}
 	}
 	return nil
@@ -0,0 +1,289 @@
+package object
+
+import "sort"
+
+// The collection builtins call the functions they are given through the
+// Runtime, so they work the same with closures of either engine and do not
+// use up frames however long the array is. Each but `range` is also a
+// method of arrays, so `map(xs, f)` can be written `xs.map(f)`.
+
+var collectionMethods = []string{
+	"map", "filter", "reduce", "find", "any", "all", "sort", "zip",
+	"reverse", "flatten", "uniq",
+}
+
+func init() {
+	for _, name := range collectionMethods {
+		RegisterMethod(ARRAY, name, GetBuiltinByName(name))
+	}
+}
+
+func truthy(o Object) bool {
+	switch o := o.(type) {
+	case *Boolean:
+		return o.Value
+	case *Null:
+		return false
+	default:
+		return true
+	}
+}
+
+func isError(o Object) bool {
+	_, ok := o.(*Error)
+	return ok
+}
+
+func arrayMap(rt Runtime, args ...Object) Object {
+	if err := checkArgs("map", args, ARRAY, anyType); err != nil {
+		return err
+	}
+	elements := args[0].(*Array).Elements
+	result := make([]Object, len(elements))
+	for i, e := range elements {
+		v := rt.Call(args[1], e)
+		if isError(v) {
+			return v
+		}
+		result[i] = v
+	}
+	return &Array{Elements: result}
+}
+
+func arrayFilter(rt Runtime, args ...Object) Object {
+	if err := checkArgs("filter", args, ARRAY, anyType); err != nil {
+		return err
+	}
+	result := []Object{}
+	for _, e := range args[0].(*Array).Elements {
+		v := rt.Call(args[1], e)
+		if isError(v) {
+			return v
+		}
+		if truthy(v) {
+			result = append(result, e)
+		}
+	}
+	return &Array{Elements: result}
+}
+
+// arrayReduce folds the array with f, starting from initial or, without
+// it, from the first element.
+func arrayReduce(rt Runtime, args ...Object) Object {
+	want := []ObjectType{ARRAY, anyType, anyType}
+	if len(args) == 2 {
+		want = want[:2]
+	}
+	if err := checkArgs("reduce", args, want...); err != nil {
+		return err
+	}
+	elements := args[0].(*Array).Elements
+	var acc Object
+	if len(args) == 3 {
+		acc = args[2]
+	} else if len(elements) == 0 {
+		return newError("`reduce` of empty ARRAY with no initial value")
+	} else {
+		acc, elements = elements[0], elements[1:]
+	}
+	for _, e := range elements {
+		acc = rt.Call(args[1], acc, e)
+		if isError(acc) {
+			return acc
+		}
+	}
+	return acc
+}
+
+func arrayFind(rt Runtime, args ...Object) Object {
+	if err := checkArgs("find", args, ARRAY, anyType); err != nil {
+		return err
+	}
+	for _, e := range args[0].(*Array).Elements {
+		v := rt.Call(args[1], e)
+		if isError(v) {
+			return v
+		}
+		if truthy(v) {
+			return e
+		}
+	}
+	return nil
+}
+
+// arrayTest returns a builtin reporting whether f returns want for any
+// element, so `any` looks for a truthy result and `all` for a falsy one.
+func arrayTest(name string, want bool) BuiltinFunction {
+	return func(rt Runtime, args ...Object) Object {
+		if err := checkArgs(name, args, ARRAY, anyType); err != nil {
+			return err
+		}
+		for _, e := range args[0].(*Array).Elements {
+			v := rt.Call(args[1], e)
+			if isError(v) {
+				return v
+			}
+			if truthy(v) == want {
+				return NativeBool(want)
+			}
+		}
+		return NativeBool(!want)
+	}
+}
+
+// arraySort returns the array sorted stably, by less when it is given and
+// in ascending order of numbers or strings otherwise.
+func arraySort(rt Runtime, args ...Object) Object {
+	want := []ObjectType{ARRAY, anyType}
+	if len(args) == 1 {
+		want = want[:1]
+	}
+	if err := checkArgs("sort", args, want...); err != nil {
+		return err
+	}
+	elements := make([]Object, len(args[0].(*Array).Elements))
+	copy(elements, args[0].(*Array).Elements)
+
+	var err Object
+	less := func(a, b Object) bool {
+		if err != nil {
+			return false
+		}
+		if len(args) == 2 {
+			v := rt.Call(args[1], a, b)
+			if isError(v) {
+				err = v
+			}
+			return truthy(v)
+		}
+		if isNumber(a) && isNumber(b) {
+			return compareNumbers(a, b) < 0
+		}
+		switch a := a.(type) {
+		case *String:
+			if b, ok := b.(*String); ok {
+				return a.Value < b.Value
+			}
+		}
+		err = newError("cannot compare %s and %s in `sort`", a.Type(), b.Type())
+		return false
+	}
+	sort.SliceStable(elements, func(i, j int) bool { return less(elements[i], elements[j]) })
+	if err != nil {
+		return err
+	}
+	return &Array{Elements: elements}
+}
+
+// arrayZip pairs up the elements of two arrays, stopping at the end of the
+// shorter one.
+func arrayZip(rt Runtime, args ...Object) Object {
+	if err := checkArgs("zip", args, ARRAY, ARRAY); err != nil {
+		return err
+	}
+	a, b := args[0].(*Array).Elements, args[1].(*Array).Elements
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+	result := make([]Object, n)
+	for i := range result {
+		result[i] = &Array{Elements: []Object{a[i], b[i]}}
+	}
+	return &Array{Elements: result}
+}
+
+// integerRange returns the integers from start up to, but not including,
+// end: `range(end)`, `range(start, end)` or `range(start, end, step)`.
+func integerRange(rt Runtime, args ...Object) Object {
+	want := []ObjectType{INTEGER, INTEGER, INTEGER}
+	if len(args) >= 1 && len(args) <= 3 {
+		want = want[:len(args)]
+	}
+	if err := checkArgs("range", args, want...); err != nil {
+		return err
+	}
+	var start, end, step int64 = 0, 0, 1
+	switch len(args) {
+	case 1:
+		end = args[0].(*Integer).Value
+	case 3:
+		step = args[2].(*Integer).Value
+		fallthrough
+	case 2:
+		start, end = args[0].(*Integer).Value, args[1].(*Integer).Value
+	}
+	if step == 0 {
+		return newError("step of `range` must not be 0")
+	}
+	result := []Object{}
+	for i := start; step > 0 && i < end || step < 0 && i > end; i += step {
+		result = append(result, &Integer{Value: i})
+	}
+	return &Array{Elements: result}
+}
+
+func arrayReverse(rt Runtime, args ...Object) Object {
+	if err := checkArgs("reverse", args, ARRAY); err != nil {
+		return err
+	}
+	elements := args[0].(*Array).Elements
+	result := make([]Object, len(elements))
+	for i, e := range elements {
+		result[len(elements)-1-i] = e
+	}
+	return &Array{Elements: result}
+}
+
+// arrayFlatten replaces the arrays in the array with their elements. It
+// flattens one level only.
+func arrayFlatten(rt Runtime, args ...Object) Object {
+	if err := checkArgs("flatten", args, ARRAY); err != nil {
+		return err
+	}
+	result := []Object{}
+	for _, e := range args[0].(*Array).Elements {
+		if inner, ok := e.(*Array); ok {
+			result = append(result, inner.Elements...)
+		} else {
+			result = append(result, e)
+		}
+	}
+	return &Array{Elements: result}
+}
+
+// arrayUniq returns the elements of the array that are not Equal to an
+// earlier one.
+func arrayUniq(rt Runtime, args ...Object) Object {
+	if err := checkArgs("uniq", args, ARRAY); err != nil {
+		return err
+	}
+	result := []Object{}
+	// seen holds the elements kept so far, by hash key when they have one
+	seen := map[HashKey][]Object{}
+	var unhashable []Object
+	contains := func(candidates []Object, e Object) bool {
+		for _, c := range candidates {
+			if Equal(c, e) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, e := range args[0].(*Array).Elements {
+		if h, ok := e.(Hashable); ok {
+			key := h.HashKey()
+			if contains(seen[key], e) {
+				continue
+			}
+			seen[key] = append(seen[key], e)
+		} else {
+			if contains(unhashable, e) {
+				continue
+			}
+			unhashable = append(unhashable, e)
+		}
+		result = append(result, e)
+	}
+	return &Array{Elements: result}
+}
@@ -0,0 +1,127 @@
+package object
+
+// The hash builtins keep the insertion order of the hashes they are given
+// and return new hashes instead of changing them. Each but `fromEntries` is
+// also a method of hashes, so `keys(h)` can be written `h.keys()` as long
+// as h has no "keys" key.
+
+var hashMethods = []string{"keys", "values", "entries", "has", "delete", "merge"}
+
+func init() {
+	for _, name := range hashMethods {
+		RegisterMethod(HASH, name, GetBuiltinByName(name))
+	}
+}
+
+func hashKeyOf(key Object) (HashKey, *Error) {
+	hashable, ok := key.(Hashable)
+	if !ok {
+		return HashKey{}, newError("unusable as hash key: %s", key.Type())
+	}
+	return hashable.HashKey(), nil
+}
+
+func hashKeys(rt Runtime, args ...Object) Object {
+	if err := checkArgs("keys", args, HASH); err != nil {
+		return err
+	}
+	keys := []Object{}
+	for _, pair := range args[0].(*Hash).OrderedPairs() {
+		keys = append(keys, pair.Key)
+	}
+	return &Array{Elements: keys}
+}
+
+func hashValues(rt Runtime, args ...Object) Object {
+	if err := checkArgs("values", args, HASH); err != nil {
+		return err
+	}
+	values := []Object{}
+	for _, pair := range args[0].(*Hash).OrderedPairs() {
+		values = append(values, pair.Value)
+	}
+	return &Array{Elements: values}
+}
+
+// hashEntries returns the pairs of a hash as `[key, value]` arrays.
+func hashEntries(rt Runtime, args ...Object) Object {
+	if err := checkArgs("entries", args, HASH); err != nil {
+		return err
+	}
+	entries := []Object{}
+	for _, pair := range args[0].(*Hash).OrderedPairs() {
+		entries = append(entries, &Array{Elements: []Object{pair.Key, pair.Value}})
+	}
+	return &Array{Elements: entries}
+}
+
+func hashHas(rt Runtime, args ...Object) Object {
+	if err := checkArgs("has", args, HASH, anyType); err != nil {
+		return err
+	}
+	key, err := hashKeyOf(args[1])
+	if err != nil {
+		return err
+	}
+	_, ok := args[0].(*Hash).Pairs[key]
+	return NativeBool(ok)
+}
+
+func hashDelete(rt Runtime, args ...Object) Object {
+	if err := checkArgs("delete", args, HASH, anyType); err != nil {
+		return err
+	}
+	key, err := hashKeyOf(args[1])
+	if err != nil {
+		return err
+	}
+	hash := args[0].(*Hash)
+	result := NewHash()
+	for _, k := range hash.keys {
+		if k != key {
+			result.Set(k, hash.Pairs[k])
+		}
+	}
+	return result
+}
+
+// hashMerge returns a hash with the pairs of all the hashes given. A key
+// in a later hash replaces the value, but keeps the position, of the key
+// in an earlier one.
+func hashMerge(rt Runtime, args ...Object) Object {
+	if len(args) == 0 {
+		return newError("wrong number of arguments. got=0, want at least 1")
+	}
+	result := NewHash()
+	for _, arg := range args {
+		hash, ok := arg.(*Hash)
+		if !ok {
+			return newError("argument to `merge` must be HASH, got %s", arg.Type())
+		}
+		for _, k := range hash.keys {
+			result.Set(k, hash.Pairs[k])
+		}
+	}
+	return result
+}
+
+// hashFromEntries builds a hash from `[key, value]` arrays, the inverse of
+// `entries`.
+func hashFromEntries(rt Runtime, args ...Object) Object {
+	if err := checkArgs("fromEntries", args, ARRAY); err != nil {
+		return err
+	}
+	result := NewHash()
+	for _, e := range args[0].(*Array).Elements {
+		entry, ok := e.(*Array)
+		if !ok || len(entry.Elements) != 2 {
+			return newError("argument to `fromEntries` must be ARRAY of [key, value], got %s in it", e.Inspect())
+		}
+		key, err := hashKeyOf(entry.Elements[0])
+		if err != nil {
+			return err
+		}
+		result.Set(key, HashPair{Key: entry.Elements[0], Value: entry.Elements[1]})
+	}
+	return result
+}
@@ -0,0 +1,160 @@
+package object
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+)
+
+// The filesystem and I/O builtins fail unless the host grants the program
+// the capability they need, so that a host can run programs it does not
+// trust. Their other failures are errors a program can handle with `try`.
+
+// allow reports an error unless the context of rt grants caps to the
+// builtin name.
+func allow(rt Runtime, name string, caps Capability) *Error {
+	if rt.Context().Allows(caps) {
+		return nil
+	}
+	what := "the file system"
+	if caps == CapStdin {
+		what = "the standard input"
+	}
+	return newError("`%s` is not allowed: the host does not grant access to %s", name, what)
+}
+
+// ioError reports err of the builtin name.
+func ioError(name string, err error) *Error {
+	return newError("%s: %s", name, err)
+}
+
+func readFile(rt Runtime, args ...Object) Object {
+	if err := checkArgs("readFile", args, STRING); err != nil {
+		return err
+	}
+	if err := allow(rt, "readFile", CapFileSystem); err != nil {
+		return err
+	}
+	content, err := ioutil.ReadFile(args[0].(*String).Value)
+	if err != nil {
+		return ioError("readFile", err)
+	}
+	return &String{Value: string(content)}
+}
+
+// writeTo returns a builtin writing its string argument to a file opened
+// with flag, creating it if needed.
+func writeTo(name string, flag int) BuiltinFunction {
+	return func(rt Runtime, args ...Object) Object {
+		if err := checkArgs(name, args, STRING, STRING); err != nil {
+			return err
+		}
+		if err := allow(rt, name, CapFileSystem); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(args[0].(*String).Value, os.O_WRONLY|os.O_CREATE|flag, 0666)
+		if err != nil {
+			return ioError(name, err)
+		}
+		_, err = f.WriteString(args[1].(*String).Value)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return ioError(name, err)
+		}
+		return nil
+	}
+}
+
+// listDir returns the names of the entries of a directory, sorted.
+func listDir(rt Runtime, args ...Object) Object {
+	if err := checkArgs("listDir", args, STRING); err != nil {
+		return err
+	}
+	if err := allow(rt, "listDir", CapFileSystem); err != nil {
+		return err
+	}
+	infos, err := ioutil.ReadDir(args[0].(*String).Value)
+	if err != nil {
+		return ioError("listDir", err)
+	}
+	names := make([]string, len(infos))
+	for i, info := range infos {
+		names[i] = info.Name()
+	}
+	return stringsOf(names)
+}
+
+func exists(rt Runtime, args ...Object) Object {
+	if err := checkArgs("exists", args, STRING); err != nil {
+		return err
+	}
+	if err := allow(rt, "exists", CapFileSystem); err != nil {
+		return err
+	}
+	_, err := os.Stat(args[0].(*String).Value)
+	if err != nil && !os.IsNotExist(err) {
+		return ioError("exists", err)
+	}
+	return NativeBool(err == nil)
+}
+
+// stat returns a hash of the name, size in bytes, isDir, mode such as
+// "-rw-r--r--" and modTime in seconds since the Unix epoch of a file.
+func stat(rt Runtime, args ...Object) Object {
+	if err := checkArgs("stat", args, STRING); err != nil {
+		return err
+	}
+	if err := allow(rt, "stat", CapFileSystem); err != nil {
+		return err
+	}
+	info, err := os.Stat(args[0].(*String).Value)
+	if err != nil {
+		return ioError("stat", err)
+	}
+	result := NewHash()
+	set := func(name string, value Object) {
+		key := &String{Value: name}
+		result.Set(key.HashKey(), HashPair{Key: key, Value: value})
+	}
+	set("name", &String{Value: info.Name()})
+	set("size", &Integer{Value: info.Size()})
+	set("isDir", NativeBool(info.IsDir()))
+	set("mode", &String{Value: info.Mode().String()})
+	set("modTime", &Integer{Value: info.ModTime().Unix()})
+	return result
+}
+
+var (
+	stdinOnce sync.Once
+	stdin     *bufio.Reader
+)
+
+// readLine returns the next line of the standard input without its line
+// ending, or null at the end of the input.
+func readLine(rt Runtime, args ...Object) Object {
+	if err := checkArgs("readLine", args); err != nil {
+		return err
+	}
+	if err := allow(rt, "readLine", CapStdin); err != nil {
+		return err
+	}
+	r := rt.Context().Stdin
+	if r == nil {
+		stdinOnce.Do(func() { stdin = bufio.NewReader(os.Stdin) })
+		r = stdin
+	}
+	line, err := r.ReadString('\n')
+	if err == io.EOF && line == "" {
+		return nil
+	}
+	if err != nil && err != io.EOF {
+		return ioError("readLine", err)
+	}
+	line = strings.TrimSuffix(line, "\n")
+	return &String{Value: strings.TrimSuffix(line, "\r")}
+}
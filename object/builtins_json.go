@@ -0,0 +1,220 @@
+package object
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// jsonParse decodes a JSON document. Objects become hashes keeping the
+// order of their keys, numbers become integers when they are whole and fit
+// in 64 bits, and floats otherwise.
+func jsonParse(rt Runtime, args ...Object) Object {
+	if err := checkArgs("json.parse", args, STRING); err != nil {
+		return err
+	}
+	d := json.NewDecoder(strings.NewReader(args[0].(*String).Value))
+	d.UseNumber()
+	value, err := decodeJSON(d)
+	if err == nil {
+		if _, extra := d.Token(); extra == nil {
+			err = errors.New("invalid value after top-level value")
+		} else if extra != io.EOF {
+			err = extra
+		}
+	}
+	if err != nil {
+		if err == io.EOF {
+			err = errors.New("unexpected end of JSON input")
+		}
+		return newError("json.parse: %s", err)
+	}
+	return value
+}
+
+func decodeJSON(d *json.Decoder) (Object, error) {
+	t, err := d.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t := t.(type) {
+	case json.Delim:
+		if t == '[' {
+			elements := []Object{}
+			for d.More() {
+				e, err := decodeJSON(d)
+				if err != nil {
+					return nil, err
+				}
+				elements = append(elements, e)
+			}
+			_, err := d.Token()
+			return &Array{Elements: elements}, err
+		}
+		hash := NewHash()
+		for d.More() {
+			k, err := d.Token()
+			if err != nil {
+				return nil, err
+			}
+			v, err := decodeJSON(d)
+			if err != nil {
+				return nil, err
+			}
+			key := &String{Value: k.(string)}
+			hash.Set(key.HashKey(), HashPair{Key: key, Value: v})
+		}
+		_, err := d.Token()
+		return hash, err
+	case json.Number:
+		if i, err := strconv.ParseInt(string(t), 10, 64); err == nil {
+			return &Integer{Value: i}, nil
+		}
+		f, err := strconv.ParseFloat(string(t), 64)
+		if err != nil {
+			return nil, err
+		}
+		return &Float{Value: f}, nil
+	case string:
+		return &String{Value: t}, nil
+	case bool:
+		return NativeBool(t), nil
+	default:
+		return NullValue, nil
+	}
+}
+
+// jsonStringify encodes a value as JSON, compactly or, given an indent of
+// a number of spaces or a string, one element per line. Hash keys keep
+// their insertion order.
+func jsonStringify(rt Runtime, args ...Object) Object {
+	want := []ObjectType{anyType, anyType}
+	if len(args) == 1 {
+		want = want[:1]
+	}
+	if err := checkArgs("json.stringify", args, want...); err != nil {
+		return err
+	}
+	e := &jsonEncoder{visiting: map[Object]bool{}}
+	if len(args) == 2 {
+		switch indent := args[1].(type) {
+		case *Integer:
+			if indent.Value < 0 {
+				return newError("indent of `json.stringify` must not be negative, got %d", indent.Value)
+			}
+			e.indent = strings.Repeat(" ", int(indent.Value))
+		case *String:
+			e.indent = indent.Value
+		default:
+			return newError("argument to `json.stringify` must be INTEGER or STRING, got %s", indent.Type())
+		}
+	}
+	if err := e.encode(args[0], 0); err != nil {
+		return newError("json.stringify: %s", err)
+	}
+	return &String{Value: e.out.String()}
+}
+
+type jsonEncoder struct {
+	out    bytes.Buffer
+	indent string
+	// visiting holds the arrays and hashes being encoded, to detect cycles
+	visiting map[Object]bool
+}
+
+func (e *jsonEncoder) encode(o Object, depth int) error {
+	switch o := o.(type) {
+	case *Null:
+		e.out.WriteString("null")
+	case *Boolean:
+		e.out.WriteString(strconv.FormatBool(o.Value))
+	case *Integer:
+		e.out.WriteString(strconv.FormatInt(o.Value, 10))
+	case *Float:
+		if math.IsNaN(o.Value) || math.IsInf(o.Value, 0) {
+			return fmt.Errorf("cannot encode %s", o.Inspect())
+		}
+		e.out.WriteString(strconv.FormatFloat(o.Value, 'g', -1, 64))
+	case *String:
+		e.string(o.Value)
+	case *Array:
+		if e.visiting[o] {
+			return errors.New("cannot encode cyclic structure")
+		}
+		e.visiting[o] = true
+		defer delete(e.visiting, o)
+		e.out.WriteByte('[')
+		for i, elem := range o.Elements {
+			e.separate(i, depth+1)
+			if err := e.encode(elem, depth+1); err != nil {
+				return err
+			}
+		}
+		e.close(len(o.Elements), depth, ']')
+	case *Hash:
+		if e.visiting[o] {
+			return errors.New("cannot encode cyclic structure")
+		}
+		e.visiting[o] = true
+		defer delete(e.visiting, o)
+		e.out.WriteByte('{')
+		for i, pair := range o.OrderedPairs() {
+			e.separate(i, depth+1)
+			switch key := pair.Key.(type) {
+			case *String:
+				e.string(key.Value)
+			case *Integer:
+				e.string(key.Inspect())
+			default:
+				return fmt.Errorf("cannot encode hash key of type %s", TypeName(key))
+			}
+			e.out.WriteByte(':')
+			if e.indent != "" {
+				e.out.WriteByte(' ')
+			}
+			if err := e.encode(pair.Value, depth+1); err != nil {
+				return err
+			}
+		}
+		e.close(len(o.Pairs), depth, '}')
+	default:
+		return fmt.Errorf("cannot encode %s", TypeName(o))
+	}
+	return nil
+}
+
+func (e *jsonEncoder) string(s string) {
+	enc := json.NewEncoder(&e.out)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	e.out.Truncate(e.out.Len() - 1) // the newline Encode ends with
+}
+
+// separate starts the i-th element of a list at depth.
+func (e *jsonEncoder) separate(i, depth int) {
+	if i > 0 {
+		e.out.WriteByte(',')
+	}
+	e.newline(depth)
+}
+
+// close ends a list of n elements at depth.
+func (e *jsonEncoder) close(n, depth int, delim byte) {
+	if n > 0 {
+		e.newline(depth)
+	}
+	e.out.WriteByte(delim)
+}
+
+func (e *jsonEncoder) newline(depth int) {
+	if e.indent == "" {
+		return
+	}
+	e.out.WriteByte('\n')
+	e.out.WriteString(strings.Repeat(e.indent, depth))
+}
@@ -0,0 +1,205 @@
+package object
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// The math builtins accept integers and floats alike. They return floats,
+// except that abs, min, max, pow and the rounding functions keep integers
+// integers.
+
+func isNumber(o Object) bool {
+	return o.Type() == INTEGER || o.Type() == FLOAT
+}
+
+func floatOf(o Object) float64 {
+	if i, ok := o.(*Integer); ok {
+		return float64(i.Value)
+	}
+	return o.(*Float).Value
+}
+
+// mathFunc returns a builtin applying f to its single number argument.
+func mathFunc(name string, f func(float64) float64) BuiltinFunction {
+	return func(rt Runtime, args ...Object) Object {
+		if err := checkArgs(name, args, numberType); err != nil {
+			return err
+		}
+		return &Float{Value: f(floatOf(args[0]))}
+	}
+}
+
+func mathAbs(rt Runtime, args ...Object) Object {
+	if err := checkArgs("math.abs", args, numberType); err != nil {
+		return err
+	}
+	if i, ok := args[0].(*Integer); ok {
+		if i.Value == math.MinInt64 {
+			return newError("`math.abs` of %d is out of the range of INTEGER", i.Value)
+		}
+		if i.Value < 0 {
+			return &Integer{Value: -i.Value}
+		}
+		return i
+	}
+	return &Float{Value: math.Abs(args[0].(*Float).Value)}
+}
+
+// compareNumbers returns -1, 0 or 1 as a is less than, equal to or greater
+// than b, comparing integers exactly.
+func compareNumbers(a, b Object) int {
+	x, xInt := a.(*Integer)
+	y, yInt := b.(*Integer)
+	var less, greater bool
+	if xInt && yInt {
+		less, greater = x.Value < y.Value, x.Value > y.Value
+	} else {
+		less, greater = floatOf(a) < floatOf(b), floatOf(a) > floatOf(b)
+	}
+	switch {
+	case less:
+		return -1
+	case greater:
+		return 1
+	}
+	return 0
+}
+
+// mathExtreme returns a builtin returning the first of its arguments that
+// no other compares to as sign, so the least one for -1 and the greatest
+// one for 1.
+func mathExtreme(name string, sign int) BuiltinFunction {
+	return func(rt Runtime, args ...Object) Object {
+		if len(args) == 0 {
+			return newError("wrong number of arguments. got=0, want at least 1")
+		}
+		var result Object
+		for _, arg := range args {
+			if !isNumber(arg) {
+				return newError("argument to `%s` must be INTEGER or FLOAT, got %s", name, arg.Type())
+			}
+			if result == nil || compareNumbers(arg, result) == sign {
+				result = arg
+			}
+		}
+		return result
+	}
+}
+
+// mathPow raises x to y, exactly when both are integers and y is not
+// negative.
+func mathPow(rt Runtime, args ...Object) Object {
+	if err := checkArgs("math.pow", args, numberType, numberType); err != nil {
+		return err
+	}
+	x, xInt := args[0].(*Integer)
+	y, yInt := args[1].(*Integer)
+	if !xInt || !yInt || y.Value < 0 {
+		return &Float{Value: math.Pow(floatOf(args[0]), floatOf(args[1]))}
+	}
+	result, base := int64(1), x.Value
+	for e := y.Value; e > 0; e >>= 1 {
+		ok := true
+		if e&1 == 1 {
+			result, ok = mulInt(result, base)
+		}
+		if ok && e > 1 {
+			base, ok = mulInt(base, base)
+		}
+		if !ok {
+			return newError("`math.pow` of %d and %d is out of the range of INTEGER", x.Value, y.Value)
+		}
+	}
+	return &Integer{Value: result}
+}
+
+// mulInt returns a * b, and whether it does not overflow.
+func mulInt(a, b int64) (int64, bool) {
+	if a == 0 || b == 0 {
+		return 0, true
+	}
+	c := a * b
+	if c/b != a || (a == -1 && b == math.MinInt64) || (b == -1 && a == math.MinInt64) {
+		return c, false
+	}
+	return c, true
+}
+
+// mathRounding returns a builtin rounding its number argument to an
+// integer with f.
+func mathRounding(name string, f func(float64) float64) BuiltinFunction {
+	return func(rt Runtime, args ...Object) Object {
+		if err := checkArgs(name, args, numberType); err != nil {
+			return err
+		}
+		if i, ok := args[0].(*Integer); ok {
+			return i
+		}
+		v := f(args[0].(*Float).Value)
+		if math.IsNaN(v) || v < math.MinInt64 || v >= math.MaxInt64 {
+			return newError("`%s` of %s is out of the range of INTEGER", name, args[0].Inspect())
+		}
+		return &Integer{Value: int64(v)}
+	}
+}
+
+func mathAtan2(rt Runtime, args ...Object) Object {
+	if err := checkArgs("math.atan2", args, numberType, numberType); err != nil {
+		return err
+	}
+	return &Float{Value: math.Atan2(floatOf(args[0]), floatOf(args[1]))}
+}
+
+// defaultRand is the source of random numbers when the context has none.
+var defaultRand = struct {
+	sync.Mutex
+	*rand.Rand
+}{Rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+// withRand calls f with the source of random numbers of the program.
+func withRand(rt Runtime, f func(r *rand.Rand)) {
+	if ctx := rt.Context(); ctx != nil && ctx.Rand != nil {
+		f(ctx.Rand)
+		return
+	}
+	defaultRand.Lock()
+	defer defaultRand.Unlock()
+	f(defaultRand.Rand)
+}
+
+// mathRandom returns a float in [0, 1).
+func mathRandom(rt Runtime, args ...Object) Object {
+	if err := checkArgs("math.random", args); err != nil {
+		return err
+	}
+	var v float64
+	withRand(rt, func(r *rand.Rand) { v = r.Float64() })
+	return &Float{Value: v}
+}
+
+// mathRandInt returns an integer from start up to, but not including, end:
+// `math.randInt(end)` or `math.randInt(start, end)`.
+func mathRandInt(rt Runtime, args ...Object) Object {
+	want := []ObjectType{INTEGER, INTEGER}
+	if len(args) == 1 {
+		want = want[:1]
+	}
+	if err := checkArgs("math.randInt", args, want...); err != nil {
+		return err
+	}
+	var start, end int64
+	if len(args) == 1 {
+		end = args[0].(*Integer).Value
+	} else {
+		start, end = args[0].(*Integer).Value, args[1].(*Integer).Value
+	}
+	if end-start <= 0 {
+		return newError("empty range in `math.randInt`: %d to %d", start, end)
+	}
+	var v int64
+	withRand(rt, func(r *rand.Rand) { v = start + r.Int63n(end-start) })
+	return &Integer{Value: v}
+}
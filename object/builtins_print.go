@@ -0,0 +1,129 @@
+package object
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// The output builtins write to the writers of the context, so that a host
+// can capture what a program prints.
+
+func stdout(rt Runtime) io.Writer {
+	if ctx := rt.Context(); ctx != nil && ctx.Stdout != nil {
+		return ctx.Stdout
+	}
+	return os.Stdout
+}
+
+func stderr(rt Runtime) io.Writer {
+	if ctx := rt.Context(); ctx != nil && ctx.Stderr != nil {
+		return ctx.Stderr
+	}
+	return os.Stderr
+}
+
+// printTo returns a builtin writing its arguments, separated by spaces and
+// without a newline, to the writer w returns.
+func printTo(w func(Runtime) io.Writer) BuiltinFunction {
+	return func(rt Runtime, args ...Object) Object {
+		texts := make([]string, len(args))
+		for i, arg := range args {
+			texts[i] = arg.Inspect()
+		}
+		io.WriteString(w(rt), strings.Join(texts, " "))
+		return nil
+	}
+}
+
+// formatArgs formats args by the verbs of Go's fmt package in format. A verb
+// takes the Monkey objects it makes sense for: %d, %b, %o, %x, %X and %c
+// integers, %e, %f and %g numbers, %t booleans, %x strings too, and %v, %s
+// and %q anything, shown as puts shows it. %T is the type of an object.
+func formatArgs(name string, format string, args []Object) (string, *Error) {
+	var out strings.Builder
+	next := 0
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			out.WriteByte(format[i])
+			continue
+		}
+		start := i
+		for i++; i < len(format) && strings.IndexByte("+-# 0123456789.", format[i]) >= 0; i++ {
+		}
+		if i == len(format) {
+			return "", newError("%s: missing verb at the end of %q", name, format)
+		}
+		verb, size := utf8.DecodeRuneInString(format[i:])
+		spec := format[start:i]
+		i += size - 1
+		if verb == '%' {
+			out.WriteByte('%')
+			continue
+		}
+		if next == len(args) {
+			return "", newError("%s: missing argument for %s%c", name, spec, verb)
+		}
+		arg := args[next]
+		next++
+		var value interface{}
+		switch verb {
+		case 'v', 's', 'q':
+			value = arg.Inspect()
+		case 'T':
+			verb, value = 's', arg.Type().String()
+		case 'd', 'b', 'o', 'c', 'x', 'X':
+			switch arg := arg.(type) {
+			case *Integer:
+				value = arg.Value
+			case *String:
+				if verb == 'x' || verb == 'X' {
+					value = arg.Value
+				}
+			}
+		case 'e', 'E', 'f', 'F', 'g', 'G':
+			if isNumber(arg) {
+				value = floatOf(arg)
+			}
+		case 't':
+			if arg, ok := arg.(*Boolean); ok {
+				value = arg.Value
+			}
+		default:
+			return "", newError("%s: unknown verb %s%c", name, spec, verb)
+		}
+		if value == nil {
+			return "", newError("%s: cannot format %s with %s%c", name, arg.Type(), spec, verb)
+		}
+		fmt.Fprintf(&out, spec+string(verb), value)
+	}
+	if next < len(args) {
+		return "", newError("%s: %q uses %d of %d arguments", name, format, next, len(args))
+	}
+	return out.String(), nil
+}
+
+func sprintf(rt Runtime, args ...Object) Object {
+	if len(args) == 0 || args[0].Type() != STRING {
+		return newError("first argument to `sprintf` must be STRING")
+	}
+	s, err := formatArgs("sprintf", args[0].(*String).Value, args[1:])
+	if err != nil {
+		return err
+	}
+	return &String{Value: s}
+}
+
+func printf(rt Runtime, args ...Object) Object {
+	if len(args) == 0 || args[0].Type() != STRING {
+		return newError("first argument to `printf` must be STRING")
+	}
+	s, err := formatArgs("printf", args[0].(*String).Value, args[1:])
+	if err != nil {
+		return err
+	}
+	io.WriteString(stdout(rt), s)
+	return nil
+}
@@ -0,0 +1,126 @@
+package object
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// The regex builtins use the syntax of Go's regexp package. A regex is
+// made by `regex.compile(pattern)` and used through its methods, such as
+// `re.findAll(s)`. A match is a hash of the matched text, its index in
+// runes, the capture groups by number and the named ones by name.
+
+var regexMethods = map[string]BuiltinFunction{
+	"test":       regexTest,
+	"match":      regexMatch,
+	"findAll":    regexFindAll,
+	"replaceAll": regexReplaceAll,
+}
+
+func init() {
+	for name, fn := range regexMethods {
+		RegisterMethod(REGEX, name, &Builtin{Fn: fn})
+	}
+}
+
+func regexCompile(rt Runtime, args ...Object) Object {
+	if err := checkArgs("regex.compile", args, STRING); err != nil {
+		return err
+	}
+	re, err := regexp.Compile(args[0].(*String).Value)
+	if err != nil {
+		return newError("regex.compile: %s", err)
+	}
+	return &Regex{Value: re}
+}
+
+// matchOf returns the match of re in s at loc, the indexes returned by
+// FindStringSubmatchIndex. A group that took no part in the match is null.
+func matchOf(re *regexp.Regexp, s string, loc []int) *Hash {
+	groups := make([]Object, re.NumSubexp())
+	named := NewHash()
+	for i := range groups {
+		group := Object(NullValue)
+		if start := loc[2*i+2]; start >= 0 {
+			group = &String{Value: s[start:loc[2*i+3]]}
+		}
+		groups[i] = group
+		if name := re.SubexpNames()[i+1]; name != "" {
+			key := &String{Value: name}
+			named.Set(key.HashKey(), HashPair{Key: key, Value: group})
+		}
+	}
+	result := NewHash()
+	for _, pair := range []HashPair{
+		{Key: &String{Value: "text"}, Value: &String{Value: s[loc[0]:loc[1]]}},
+		{Key: &String{Value: "index"}, Value: &Integer{Value: int64(utf8.RuneCountInString(s[:loc[0]]))}},
+		{Key: &String{Value: "groups"}, Value: &Array{Elements: groups}},
+		{Key: &String{Value: "named"}, Value: named},
+	} {
+		result.Set(pair.Key.(*String).HashKey(), pair)
+	}
+	return result
+}
+
+func regexTest(rt Runtime, args ...Object) Object {
+	if err := checkArgs("test", args, REGEX, STRING); err != nil {
+		return err
+	}
+	return NativeBool(args[0].(*Regex).Value.MatchString(args[1].(*String).Value))
+}
+
+// regexMatch returns the first match in s, or null when there is none.
+func regexMatch(rt Runtime, args ...Object) Object {
+	if err := checkArgs("match", args, REGEX, STRING); err != nil {
+		return err
+	}
+	re, s := args[0].(*Regex).Value, args[1].(*String).Value
+	loc := re.FindStringSubmatchIndex(s)
+	if loc == nil {
+		return nil
+	}
+	return matchOf(re, s, loc)
+}
+
+func regexFindAll(rt Runtime, args ...Object) Object {
+	if err := checkArgs("findAll", args, REGEX, STRING); err != nil {
+		return err
+	}
+	re, s := args[0].(*Regex).Value, args[1].(*String).Value
+	matches := []Object{}
+	for _, loc := range re.FindAllStringSubmatchIndex(s, -1) {
+		matches = append(matches, matchOf(re, s, loc))
+	}
+	return &Array{Elements: matches}
+}
+
+// regexReplaceAll replaces the matches in s with a string, in which `$1`
+// or `${name}` stands for a group, or with what a function returns for
+// each match.
+func regexReplaceAll(rt Runtime, args ...Object) Object {
+	if err := checkArgs("replaceAll", args, REGEX, STRING, anyType); err != nil {
+		return err
+	}
+	re, s := args[0].(*Regex).Value, args[1].(*String).Value
+	if repl, ok := args[2].(*String); ok {
+		return &String{Value: re.ReplaceAllString(s, repl.Value)}
+	}
+	var out strings.Builder
+	last := 0
+	for _, loc := range re.FindAllStringSubmatchIndex(s, -1) {
+		v := rt.Call(args[2], matchOf(re, s, loc))
+		if isError(v) {
+			return v
+		}
+		repl, ok := v.(*String)
+		if !ok {
+			return newError("function given to `replaceAll` must return STRING, got %s", v.Type())
+		}
+		out.WriteString(s[last:loc[0]])
+		out.WriteString(repl.Value)
+		last = loc[1]
+	}
+	out.WriteString(s[last:])
+	return &String{Value: out.String()}
+}
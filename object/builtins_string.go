@@ -0,0 +1,142 @@
+package object
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// The string builtins count and index runes, not bytes. Each is also a
+// method of strings, so `split(s, ",")` can be written `s.split(",")`.
+
+var stringBuiltins = []string{
+	"split", "join", "trim", "upper", "lower", "replace", "contains",
+	"startsWith", "endsWith", "indexOf", "substr", "repeat", "chars",
+}
+
+func init() {
+	for _, name := range stringBuiltins {
+		RegisterMethod(STRING, name, GetBuiltinByName(name))
+	}
+}
+
+func stringsOf(values []string) *Array {
+	elements := make([]Object, len(values))
+	for i, v := range values {
+		elements[i] = &String{Value: v}
+	}
+	return &Array{Elements: elements}
+}
+
+func stringSplit(rt Runtime, args ...Object) Object {
+	if err := checkArgs("split", args, STRING, STRING); err != nil {
+		return err
+	}
+	return stringsOf(strings.Split(args[0].(*String).Value, args[1].(*String).Value))
+}
+
+func stringJoin(rt Runtime, args ...Object) Object {
+	if err := checkArgs("join", args, ARRAY, STRING); err != nil {
+		return err
+	}
+	elements := args[0].(*Array).Elements
+	values := make([]string, len(elements))
+	for i, e := range elements {
+		s, ok := e.(*String)
+		if !ok {
+			return newError("argument to `join` must be ARRAY of STRING, got %s in it", e.Type())
+		}
+		values[i] = s.Value
+	}
+	return &String{Value: strings.Join(values, args[1].(*String).Value)}
+}
+
+// stringFunc returns a builtin applying f to its single string argument.
+func stringFunc(name string, f func(string) string) BuiltinFunction {
+	return func(rt Runtime, args ...Object) Object {
+		if err := checkArgs(name, args, STRING); err != nil {
+			return err
+		}
+		return &String{Value: f(args[0].(*String).Value)}
+	}
+}
+
+func stringReplace(rt Runtime, args ...Object) Object {
+	if err := checkArgs("replace", args, STRING, STRING, STRING); err != nil {
+		return err
+	}
+	s, old, new := args[0].(*String).Value, args[1].(*String).Value, args[2].(*String).Value
+	return &String{Value: strings.ReplaceAll(s, old, new)}
+}
+
+// stringPredicate returns a builtin applying f to its two string arguments.
+func stringPredicate(name string, f func(string, string) bool) BuiltinFunction {
+	return func(rt Runtime, args ...Object) Object {
+		if err := checkArgs(name, args, STRING, STRING); err != nil {
+			return err
+		}
+		return NativeBool(f(args[0].(*String).Value, args[1].(*String).Value))
+	}
+}
+
+func stringIndexOf(rt Runtime, args ...Object) Object {
+	if err := checkArgs("indexOf", args, STRING, STRING); err != nil {
+		return err
+	}
+	s, sub := args[0].(*String).Value, args[1].(*String).Value
+	i := strings.Index(s, sub)
+	if i < 0 {
+		return &Integer{Value: -1}
+	}
+	return &Integer{Value: int64(utf8.RuneCountInString(s[:i]))}
+}
+
+// stringSubstr returns the length runes of s from start, or the runes
+// from start to the end without length.
+func stringSubstr(rt Runtime, args ...Object) Object {
+	want := []ObjectType{STRING, INTEGER, INTEGER}
+	if len(args) == 2 {
+		want = want[:2]
+	}
+	if err := checkArgs("substr", args, want...); err != nil {
+		return err
+	}
+	runes := []rune(args[0].(*String).Value)
+	start := args[1].(*Integer).Value
+	if start < 0 || start > int64(len(runes)) {
+		return newError("start of `substr` out of range: %d", start)
+	}
+	end := int64(len(runes))
+	if len(args) == 3 {
+		length := args[2].(*Integer).Value
+		if length < 0 {
+			return newError("length of `substr` must not be negative, got %d", length)
+		}
+		if start+length < end {
+			end = start + length
+		}
+	}
+	return &String{Value: string(runes[start:end])}
+}
+
+func stringRepeat(rt Runtime, args ...Object) Object {
+	if err := checkArgs("repeat", args, STRING, INTEGER); err != nil {
+		return err
+	}
+	count := args[1].(*Integer).Value
+	if count < 0 {
+		return newError("count of `repeat` must not be negative, got %d", count)
+	}
+	return &String{Value: strings.Repeat(args[0].(*String).Value, int(count))}
+}
+
+func stringChars(rt Runtime, args ...Object) Object {
+	if err := checkArgs("chars", args, STRING); err != nil {
+		return err
+	}
+	s := args[0].(*String).Value
+	chars := make([]string, 0, utf8.RuneCountInString(s))
+	for _, r := range s {
+		chars = append(chars, string(r))
+	}
+	return stringsOf(chars)
+}
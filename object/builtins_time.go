@@ -0,0 +1,147 @@
+package object
+
+import "time"
+
+// The time builtins count times in milliseconds since the Unix epoch and
+// durations in milliseconds, so that they are added and subtracted as
+// integers. They read the clock of the context, which a host can replace
+// to run programs deterministically.
+
+// Clock tells the time to the time builtins.
+type Clock interface {
+	// Now returns the current time. Times are formatted and parsed in
+	// its location.
+	Now() time.Time
+	// Monotonic returns the time elapsed since a fixed point, which never
+	// goes backwards.
+	Monotonic() time.Duration
+	// After returns a channel receiving the time once d has passed, like
+	// time.After. The program sleeps until then.
+	After(d time.Duration) <-chan time.Time
+}
+
+// SystemClock is the clock of the machine, used when the context has none.
+var SystemClock Clock = systemClock{start: time.Now()}
+
+type systemClock struct {
+	start time.Time
+}
+
+func (c systemClock) Now() time.Time                         { return time.Now() }
+func (c systemClock) Monotonic() time.Duration               { return time.Since(c.start) }
+func (c systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// defaultLayout is the layout of formatTime and parseTime without one,
+// RFC 3339 with the fraction of a second when it is not zero.
+const defaultLayout = time.RFC3339Nano
+
+func clockOf(rt Runtime) Clock {
+	if ctx := rt.Context(); ctx != nil && ctx.Clock != nil {
+		return ctx.Clock
+	}
+	return SystemClock
+}
+
+func millis(t time.Time) *Integer {
+	return &Integer{Value: t.UnixNano() / int64(time.Millisecond)}
+}
+
+func timeOf(ms int64, loc *time.Location) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond)).In(loc)
+}
+
+func now(rt Runtime, args ...Object) Object {
+	if err := checkArgs("now", args); err != nil {
+		return err
+	}
+	return millis(clockOf(rt).Now())
+}
+
+// monotonic returns the milliseconds since a fixed point, with a fraction,
+// to measure how long something takes.
+func monotonic(rt Runtime, args ...Object) Object {
+	if err := checkArgs("monotonic", args); err != nil {
+		return err
+	}
+	return &Float{Value: float64(clockOf(rt).Monotonic()) / float64(time.Millisecond)}
+}
+
+// sleep pauses the program for a number of milliseconds, or until it is
+// canceled.
+func sleep(rt Runtime, args ...Object) Object {
+	if err := checkArgs("sleep", args, INTEGER); err != nil {
+		return err
+	}
+	ms := args[0].(*Integer).Value
+	if ms < 0 {
+		return newError("duration of `sleep` must not be negative, got %d", ms)
+	}
+	ctx := rt.Context()
+	if err := ctx.Err(); err != nil {
+		return &Error{Message: err.Error(), Err: err}
+	}
+	select {
+	case <-clockOf(rt).After(time.Duration(ms) * time.Millisecond):
+		return nil
+	case <-ctx.Done():
+		err := ctx.Err()
+		return &Error{Message: err.Error(), Err: err}
+	}
+}
+
+// layoutArg returns the layout given at args[i], in the notation of Go's
+// time package, or defaultLayout.
+func layoutArg(args []Object, i int) string {
+	if len(args) > i {
+		return args[i].(*String).Value
+	}
+	return defaultLayout
+}
+
+func formatTime(rt Runtime, args ...Object) Object {
+	want := []ObjectType{INTEGER, STRING}
+	if len(args) == 1 {
+		want = want[:1]
+	}
+	if err := checkArgs("formatTime", args, want...); err != nil {
+		return err
+	}
+	t := timeOf(args[0].(*Integer).Value, clockOf(rt).Now().Location())
+	return &String{Value: t.Format(layoutArg(args, 1))}
+}
+
+func parseTime(rt Runtime, args ...Object) Object {
+	want := []ObjectType{STRING, STRING}
+	if len(args) == 1 {
+		want = want[:1]
+	}
+	if err := checkArgs("parseTime", args, want...); err != nil {
+		return err
+	}
+	t, err := time.ParseInLocation(layoutArg(args, 1), args[0].(*String).Value, clockOf(rt).Now().Location())
+	if err != nil {
+		return newError("parseTime: %s", err)
+	}
+	return millis(t)
+}
+
+// duration returns the milliseconds of a duration such as "1h30m" or
+// "250ms".
+func duration(rt Runtime, args ...Object) Object {
+	if err := checkArgs("duration", args, STRING); err != nil {
+		return err
+	}
+	d, err := time.ParseDuration(args[0].(*String).Value)
+	if err != nil {
+		return newError("duration: %s", err)
+	}
+	return &Integer{Value: int64(d / time.Millisecond)}
+}
+
+// formatDuration is the inverse of duration.
+func formatDuration(rt Runtime, args ...Object) Object {
+	if err := checkArgs("formatDuration", args, INTEGER); err != nil {
+		return err
+	}
+	return &String{Value: (time.Duration(args[0].(*Integer).Value) * time.Millisecond).String()}
+}
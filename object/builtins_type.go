@@ -0,0 +1,117 @@
+package object
+
+import (
+	"math"
+	"strconv"
+)
+
+// The type builtins tell values apart and convert between them. Functions
+// are of type FUNCTION in both engines, though the VM runs them as
+// closures.
+
+// TypeName returns the name of the type of o that programs see.
+func TypeName(o Object) string {
+	switch o.Type() {
+	case CLOSURE, COMPILED_FUNCTION:
+		return FUNCTION.String()
+	}
+	return o.Type().String()
+}
+
+func typeOf(rt Runtime, args ...Object) Object {
+	if err := checkArgs("type", args, anyType); err != nil {
+		return err
+	}
+	return &String{Value: TypeName(args[0])}
+}
+
+// typePredicate returns a builtin reporting whether its argument is of one
+// of types.
+func typePredicate(name string, types ...ObjectType) BuiltinFunction {
+	return func(rt Runtime, args ...Object) Object {
+		if err := checkArgs(name, args, anyType); err != nil {
+			return err
+		}
+		for _, t := range types {
+			if args[0].Type() == t {
+				return True
+			}
+		}
+		return False
+	}
+}
+
+// toInt converts numbers, truncating floats toward zero, booleans and
+// strings of decimal integers to integers.
+func toInt(rt Runtime, args ...Object) Object {
+	if err := checkArgs("int", args, anyType); err != nil {
+		return err
+	}
+	switch arg := args[0].(type) {
+	case *Integer:
+		return arg
+	case *Float:
+		v := math.Trunc(arg.Value)
+		if math.IsNaN(v) || v < math.MinInt64 || v >= math.MaxInt64 {
+			return newError("int: %s is out of the range of INTEGER", arg.Inspect())
+		}
+		return &Integer{Value: int64(v)}
+	case *Boolean:
+		if arg.Value {
+			return &Integer{Value: 1}
+		}
+		return &Integer{Value: 0}
+	case *String:
+		i, err := strconv.ParseInt(arg.Value, 10, 64)
+		if err != nil {
+			return newError("int: cannot parse %q as INTEGER", arg.Value)
+		}
+		return &Integer{Value: i}
+	}
+	return newError("int: cannot convert %s to INTEGER", TypeName(args[0]))
+}
+
+// toFloat converts numbers, booleans and strings of numbers to floats.
+func toFloat(rt Runtime, args ...Object) Object {
+	if err := checkArgs("float", args, anyType); err != nil {
+		return err
+	}
+	switch arg := args[0].(type) {
+	case *Integer:
+		return &Float{Value: float64(arg.Value)}
+	case *Float:
+		return arg
+	case *Boolean:
+		if arg.Value {
+			return &Float{Value: 1}
+		}
+		return &Float{Value: 0}
+	case *String:
+		f, err := strconv.ParseFloat(arg.Value, 64)
+		if err != nil {
+			return newError("float: cannot parse %q as FLOAT", arg.Value)
+		}
+		return &Float{Value: f}
+	}
+	return newError("float: cannot convert %s to FLOAT", TypeName(args[0]))
+}
+
+// toStr returns a value as puts shows it.
+func toStr(rt Runtime, args ...Object) Object {
+	if err := checkArgs("str", args, anyType); err != nil {
+		return err
+	}
+	if s, ok := args[0].(*String); ok {
+		return s
+	}
+	return &String{Value: args[0].Inspect()}
+}
+
+// toBool returns whether a value is truthy, which all but false and null
+// are.
+func toBool(rt Runtime, args ...Object) Object {
+	if err := checkArgs("bool", args, anyType); err != nil {
+		return err
+	}
+	return NativeBool(truthy(args[0]))
+}
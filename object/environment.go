@@ -1,5 +1,140 @@
 package object
 
+import (
+	"bufio"
+	"context"
+	"io"
+	"math/rand"
+
+	"github.com/wreulicke/monkey/module"
+)
+
+// Context holds what a running program knows about where it came from.
+type Context struct {
+	// File is the path of the file being run, or empty when the program
+	// was not read from a file.
+	File string
+	// Modules loads the modules the program imports.
+	Modules *module.Loader
+	// Rand is the source of `math.random` and `math.randInt`. Setting it
+	// to a source with a fixed seed makes them repeat across runs; without
+	// it they use a source seeded from the time.
+	Rand *rand.Rand
+	// Capabilities are the permissions the host grants the program. The
+	// builtins needing one fail without it.
+	Capabilities Capability
+	// Stdin is what `readLine` reads. It reads os.Stdin when Stdin is nil.
+	Stdin *bufio.Reader
+	// Stdout and Stderr are where the output builtins write. They write to
+	// os.Stdout and os.Stderr when these are nil.
+	Stdout io.Writer
+	Stderr io.Writer
+	// Clock is what the time builtins read. They read SystemClock when
+	// Clock is nil.
+	Clock Clock
+	// Builtins are the builtins of the program. The program gets the
+	// Builtins of the package when it is nil.
+	Builtins *Registry
+
+	// done stops the program when it is done, see WithDone.
+	done context.Context
+}
+
+// Capability is a set of permissions of a program.
+type Capability uint
+
+const (
+	// CapFileSystem lets the program read and write files.
+	CapFileSystem Capability = 1 << iota
+	// CapStdin lets the program read the standard input.
+	CapStdin
+)
+
+// Allows reports whether the program is granted all of caps. A nil context
+// grants nothing.
+func (c *Context) Allows(caps Capability) bool {
+	return c != nil && c.Capabilities&caps == caps
+}
+
+// BuiltinRegistry returns the registry of the builtins of the program. A
+// nil context has the Builtins of the package.
+func (c *Context) BuiltinRegistry() *Registry {
+	if c == nil || c.Builtins == nil {
+		return defaultRegistry
+	}
+	return c.Builtins
+}
+
+// CanceledError is the error of a program stopped because the context it
+// ran with was done. Err is the error of that context.
+type CanceledError struct {
+	Err error
+}
+
+func (e *CanceledError) Error() string {
+	return "program canceled: " + e.Err.Error()
+}
+
+func (e *CanceledError) Unwrap() error {
+	return e.Err
+}
+
+// WithDone returns a copy of c, which may be nil, whose program stops when
+// ctx is done.
+func (c *Context) WithDone(ctx context.Context) *Context {
+	var copied Context
+	if c != nil {
+		copied = *c
+	}
+	copied.done = ctx
+	return &copied
+}
+
+// WithDoneOf returns c, or a copy of it, whose program stops when the
+// program of caller does. Functions run with the context they are defined
+// in, but stop with the program calling them.
+func (c *Context) WithDoneOf(caller *Context) *Context {
+	var done context.Context
+	if caller != nil {
+		done = caller.done
+	}
+	if c != nil && c.Done() == caller.Done() {
+		return c
+	}
+	return c.WithDone(done)
+}
+
+// Done returns a channel closed when the program of c must stop, or nil
+// when it runs until it ends. Builtins waiting for something wait on it
+// too, and return the error of Err once it is closed.
+func (c *Context) Done() <-chan struct{} {
+	if c == nil || c.done == nil {
+		return nil
+	}
+	return c.done.Done()
+}
+
+// Err returns a *CanceledError when the program of c must stop, nil
+// otherwise. The engines check it on calls and on jumps backward.
+func (c *Context) Err() error {
+	select {
+	case <-c.Done():
+		return &CanceledError{Err: c.done.Err()}
+	default:
+		return nil
+	}
+}
+
+// ForModule returns the context of the module read from file, which shares
+// everything else with c but when the program stops: a module outlives
+// the program importing it, and stops with the programs calling it.
+func (c *Context) ForModule(file string) *Context {
+	module := *c
+	module.File = file
+	module.done = nil
+	return &module
+}
+
 func NewEnvironment() *Environment {
 	return &Environment{store: map[string]Object{}}
 }
@@ -7,6 +142,10 @@ func NewEnvironment() *Environment {
 type Environment struct {
 	store  map[string]Object
 	parent *Environment
+	ctx    *Context
+	// depth is the number of calls the body of a function is evaluated
+	// in, see SetDepth.
+	depth int
 }
 
 func (e *Environment) Get(name string) (Object, bool) {
@@ -27,3 +166,36 @@ func (e *Environment) NewEnclosedEnvironment() *Environment {
 	newEnv.parent = e
 	return newEnv
 }
+
+// SetContext sets the context of e and the environments enclosed in it.
+func (e *Environment) SetContext(ctx *Context) {
+	e.ctx = ctx
+}
+
+// SetDepth sets the number of nested calls of functions e, the
+// environment of the body of a function, and the environments enclosed in
+// it are evaluated in.
+func (e *Environment) SetDepth(depth int) {
+	e.depth = depth
+}
+
+// Depth returns the depth of the nearest environment with one, or 0
+// outside of functions.
+func (e *Environment) Depth() int {
+	for ; e != nil; e = e.parent {
+		if e.depth != 0 {
+			return e.depth
+		}
+	}
+	return 0
+}
+
+// Context returns the context of the nearest environment with one, or nil.
+func (e *Environment) Context() *Context {
+	for ; e != nil; e = e.parent {
+		if e.ctx != nil {
+			return e.ctx
+		}
+	}
+	return nil
+}
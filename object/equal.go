@@ -0,0 +1,71 @@
+package object
+
+// Equal reports whether a and b are structurally equal. Arrays and hashes
+// are compared element by element, other objects by identity.
+func Equal(a, b Object) bool {
+	return equal(a, b, map[[2]Object]bool{})
+}
+
+// equal compares a and b, treating pairs already under comparison as equal
+// so that cyclic structures terminate.
+func equal(a, b Object, visiting map[[2]Object]bool) bool {
+	if a == b {
+		return true
+	}
+	switch a := a.(type) {
+	case *Integer:
+		b, ok := b.(*Integer)
+		return ok && a.Value == b.Value
+	case *Float:
+		b, ok := b.(*Float)
+		return ok && a.Value == b.Value
+	case *String:
+		b, ok := b.(*String)
+		return ok && a.Value == b.Value
+	case *Regex:
+		b, ok := b.(*Regex)
+		return ok && a.Value.String() == b.Value.String()
+	case *Boolean:
+		b, ok := b.(*Boolean)
+		return ok && a.Value == b.Value
+	case *Null:
+		_, ok := b.(*Null)
+		return ok
+	case *Array:
+		b, ok := b.(*Array)
+		if !ok || len(a.Elements) != len(b.Elements) {
+			return false
+		}
+		pair := [2]Object{a, b}
+		if visiting[pair] {
+			return true
+		}
+		visiting[pair] = true
+		defer delete(visiting, pair)
+		for i := range a.Elements {
+			if !equal(a.Elements[i], b.Elements[i], visiting) {
+				return false
+			}
+		}
+		return true
+	case *Hash:
+		b, ok := b.(*Hash)
+		if !ok || len(a.Pairs) != len(b.Pairs) {
+			return false
+		}
+		pair := [2]Object{a, b}
+		if visiting[pair] {
+			return true
+		}
+		visiting[pair] = true
+		defer delete(visiting, pair)
+		for key, p := range a.Pairs {
+			q, ok := b.Pairs[key]
+			if !ok || !equal(p.Value, q.Value, visiting) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,43 @@
+package object
+
+var methods = map[ObjectType]map[string]*Builtin{}
+
+// RegisterMethod makes fn callable as `value.name(args)` on values of type t.
+// fn receives the receiver as its first argument.
+func RegisterMethod(t ObjectType, name string, fn *Builtin) {
+	if methods[t] == nil {
+		methods[t] = map[string]*Builtin{}
+	}
+	methods[t][name] = fn
+}
+
+// LookupMethod returns the method name registered for values of type t.
+func LookupMethod(t ObjectType, name string) (*Builtin, bool) {
+	fn, ok := methods[t][name]
+	return fn, ok
+}
+
+// ResolveMethod returns the function `receiver.name(args)` calls along with
+// the arguments to call it with. A hash holding the key name calls the
+// value stored there with args, any other receiver calls the method
+// registered for its type with the receiver prepended to args.
+func ResolveMethod(receiver Object, name string, args []Object) (Object, []Object, *Error) {
+	if hash, ok := receiver.(*Hash); ok {
+		key := &String{Value: name}
+		if pair, ok := hash.Pairs[key.HashKey()]; ok {
+			return pair.Value, args, nil
+		}
+	}
+	fn, ok := LookupMethod(receiver.Type(), name)
+	if !ok {
+		return nil, nil, newError("undefined method %s for %s", name, receiver.Type())
+	}
+	return fn, append([]Object{receiver}, args...), nil
+}
+
+func init() {
+	RegisterMethod(STRING, "len", GetBuiltinByName("len"))
+	for _, name := range []string{"len", "first", "last", "rest", "push"} {
+		RegisterMethod(ARRAY, name, GetBuiltinByName(name))
+	}
+}
@@ -2,8 +2,14 @@ package object
 
 import (
 	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"hash/fnv"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/wreulicke/monkey/ast"
@@ -21,6 +27,12 @@ var typeNames = []string{
 	"RETURN",
 	"ERROR",
 	"BUILTIN",
+	"COMPILED_FUNCTION",
+	"CLOSURE",
+	"QUOTE",
+	"MACRO",
+	"FLOAT",
+	"REGEX",
 }
 
 type ObjectType int
@@ -38,6 +50,10 @@ const (
 	BUILTIN
 	COMPILED_FUNCTION
 	CLOSURE
+	QUOTE
+	MACRO
+	FLOAT
+	REGEX
 )
 
 func (o ObjectType) String() string {
@@ -69,10 +85,75 @@ func (n *Integer) HashKey() HashKey {
 	return HashKey{Type: n.Type(), Value: uint64(n.Value)}
 }
 
+type Float struct {
+	Value float64
+}
+
+func (f *Float) Type() ObjectType {
+	return FLOAT
+}
+
+// Inspect prints f so that it does not read as an integer.
+func (f *Float) Inspect() string {
+	s := strconv.FormatFloat(f.Value, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eIN") {
+		s += ".0"
+	}
+	return s
+}
+
+func (f *Float) HashKey() HashKey {
+	return HashKey{Type: f.Type(), Value: math.Float64bits(f.Value)}
+}
+
+// ParseNumber returns the value of a number literal: a Float when it has a
+// fraction or an exponent, an Integer otherwise.
+func ParseNumber(literal string) (Object, error) {
+	if strings.ContainsAny(literal, ".eE") {
+		f, err := strconv.ParseFloat(literal, 64)
+		if err != nil {
+			return nil, err
+		}
+		return &Float{Value: f}, nil
+	}
+	i, err := strconv.ParseInt(literal, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &Integer{Value: i}, nil
+}
+
+type Regex struct {
+	Value *regexp.Regexp
+}
+
+func (r *Regex) Type() ObjectType {
+	return REGEX
+}
+
+func (r *Regex) Inspect() string {
+	return "/" + r.Value.String() + "/"
+}
+
 type Boolean struct {
 	Value bool
 }
 
+// True and False are the only booleans the engines create, so booleans
+// can be compared by identity.
+var (
+	True  = &Boolean{Value: true}
+	False = &Boolean{Value: false}
+)
+
+// NativeBool returns True or False.
+func NativeBool(b bool) *Boolean {
+	if b {
+		return True
+	}
+	return False
+}
+
 func (b *Boolean) Type() ObjectType {
 	return BOOLEAN
 }
@@ -117,6 +198,77 @@ func (a *Array) Type() ObjectType {
 	return ARRAY
 }
 
+// HashKey hashes the elements of a as Equal compares them: values by
+// value, arrays and hashes by their elements, and the objects Equal
+// compares by identity, such as functions, by their address. An array or
+// hash met again inside itself hashes as a marker, so that cyclic arrays
+// have a key.
+func (a *Array) HashKey() HashKey {
+	h := fnv.New64a()
+	hashObject(h, a, map[Object]bool{})
+	return HashKey{Type: a.Type(), Value: h.Sum64()}
+}
+
+func writeUint64(w io.Writer, v uint64) {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	w.Write(buf[:])
+}
+
+// mix scrambles the bits of x, as the finalizer of SplitMix64 does, so
+// that sums of hashes differing in few bits do not collide.
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	return x ^ x>>31
+}
+
+// hashObject writes o to w, visiting holding the arrays and hashes being
+// written.
+func hashObject(w io.Writer, o Object, visiting map[Object]bool) {
+	writeUint64(w, uint64(o.Type()))
+	switch o := o.(type) {
+	case *Array:
+		if visiting[o] {
+			io.WriteString(w, "cycle")
+			return
+		}
+		visiting[o] = true
+		defer delete(visiting, o)
+		writeUint64(w, uint64(len(o.Elements)))
+		for _, e := range o.Elements {
+			hashObject(w, e, visiting)
+		}
+	case *Hash:
+		if visiting[o] {
+			io.WriteString(w, "cycle")
+			return
+		}
+		visiting[o] = true
+		defer delete(visiting, o)
+		// Equal ignores the order of the pairs, so their hashes are mixed
+		// and added
+		var sum uint64
+		for key, pair := range o.Pairs {
+			h := fnv.New64a()
+			writeUint64(h, uint64(key.Type))
+			writeUint64(h, key.Value)
+			hashObject(h, pair.Value, visiting)
+			sum += mix(h.Sum64())
+		}
+		writeUint64(w, sum)
+	case *Regex:
+		io.WriteString(w, o.Value.String())
+	case *Null:
+	case Hashable:
+		writeUint64(w, o.HashKey().Value)
+	default:
+		fmt.Fprintf(w, "%p", o)
+	}
+}
+
 func (a *Array) Inspect() string {
 	var out bytes.Buffer
 	elements := []string{}
@@ -132,6 +284,9 @@ func (a *Array) Inspect() string {
 type Null struct {
 }
 
+// NullValue is the only null the engines create.
+var NullValue = &Null{}
+
 func (b *Null) Type() ObjectType {
 	return NULL
 }
@@ -154,6 +309,15 @@ func (rv *ReturnValue) Inspect() string {
 
 type Error struct {
 	Message string
+	// Err is the Go error the failure comes from, when it has one.
+	Err error
+}
+
+// Canceled reports whether e is the failure of a canceled program, which
+// `try` does not catch.
+func (e *Error) Canceled() bool {
+	var canceled *CanceledError
+	return errors.As(e.Err, &canceled)
 }
 
 func (e *Error) Type() ObjectType {
@@ -166,10 +330,20 @@ func (e *Error) Inspect() string {
 
 type Function struct {
 	Parameters []ast.Pattern
+	Defaults   []ast.Expression
+	Rest       *ast.Identifier
 	Body       *ast.BlockStatement
 	Env        *Environment
 }
 
+// Default returns the default value of the i-th parameter, or nil.
+func (f *Function) Default(i int) ast.Expression {
+	if i < len(f.Defaults) {
+		return f.Defaults[i]
+	}
+	return nil
+}
+
 func (f *Function) Type() ObjectType {
 	return FUNCTION
 }
@@ -178,8 +352,15 @@ func (f *Function) Inspect() string {
 	var out bytes.Buffer
 
 	params := []string{}
-	for _, v := range f.Parameters {
-		params = append(params, v.String())
+	for i, v := range f.Parameters {
+		if d := f.Default(i); d != nil {
+			params = append(params, v.String()+" = "+d.String())
+		} else {
+			params = append(params, v.String())
+		}
+	}
+	if f.Rest != nil {
+		params = append(params, "..."+f.Rest.String())
 	}
 	out.WriteString("fn(")
 	out.WriteString(strings.Join(params, ", "))
@@ -193,6 +374,11 @@ type CompiledFunction struct {
 	Instructions  code.Instructions
 	NumLocals     int
 	NumParameters int
+	// NumDefaults is the number of trailing parameters with defaults.
+	NumDefaults int
+	// Variadic is set when the local after the parameters collects the
+	// remaining arguments.
+	Variadic bool
 }
 
 func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION }
@@ -200,7 +386,65 @@ func (cf *CompiledFunction) Inspect() string {
 	return fmt.Sprintf("CompiledFunction[%p]", cf)
 }
 
-type BuiltinFunction func(args ...Object) Object
+// CheckArity returns an error unless a function taking required parameters,
+// optional parameters with defaults and, when variadic, a rest parameter
+// can be called with got arguments.
+func CheckArity(required, optional int, variadic bool, got int) *Error {
+	switch {
+	case got >= required && (variadic || got <= required+optional):
+		return nil
+	case variadic:
+		return newError("wrong number of arguments: want at least %d, got=%d", required, got)
+	case optional > 0:
+		return newError("wrong number of arguments: want=%d to %d, got=%d", required, required+optional, got)
+	default:
+		return newError("wrong number of arguments: want=%d, got=%d", required, got)
+	}
+}
+
+// CheckArrayPattern returns an error unless an array pattern of n elements
+// can destructure o, which takes an array of at least n elements.
+func CheckArrayPattern(o Object, n int) *Error {
+	array, ok := o.(*Array)
+	if !ok {
+		return newError("cannot destructure %s with an array pattern", TypeName(o))
+	}
+	if len(array.Elements) < n {
+		return newError("cannot destructure an array of length %d with an array pattern of length %d", len(array.Elements), n)
+	}
+	return nil
+}
+
+// CheckHashPattern returns an error unless a hash pattern of keys can
+// destructure o, which takes a hash holding every key.
+func CheckHashPattern(o Object, keys []string) *Error {
+	hash, ok := o.(*Hash)
+	if !ok {
+		return newError("cannot destructure %s with a hash pattern", TypeName(o))
+	}
+	for _, key := range keys {
+		if _, ok := hash.Pairs[(&String{Value: key}).HashKey()]; !ok {
+			return newError("cannot destructure a hash without the key %q", key)
+		}
+	}
+	return nil
+}
+
+// Runtime is the engine calling a builtin, which the builtin can use to
+// call the functions it is given.
+type Runtime interface {
+	// Call calls fn with args. A failing call returns an *Error, which the
+	// builtin should return as its result.
+	Call(fn Object, args ...Object) Object
+	// Recover handles the failure of the last call, so that the builtin
+	// can go on instead of returning the *Error.
+	Recover()
+	// Context returns the context of the running program, or nil when it
+	// has none.
+	Context() *Context
+}
+
+type BuiltinFunction func(rt Runtime, args ...Object) Object
 
 type Builtin struct {
 	Fn BuiltinFunction
@@ -224,6 +468,38 @@ func (c *Closure) Inspect() string {
 	return fmt.Sprintf("Closure[%p]", c)
 }
 
+// Quote is the unevaluated syntax tree returned by `quote`.
+type Quote struct {
+	Node ast.Node
+}
+
+func (q *Quote) Type() ObjectType { return QUOTE }
+func (q *Quote) Inspect() string {
+	return "QUOTE(" + q.Node.String() + ")"
+}
+
+type Macro struct {
+	Parameters []*ast.Identifier
+	Body       *ast.BlockStatement
+	Env        *Environment
+}
+
+func (m *Macro) Type() ObjectType { return MACRO }
+func (m *Macro) Inspect() string {
+	var out bytes.Buffer
+
+	params := []string{}
+	for _, p := range m.Parameters {
+		params = append(params, p.String())
+	}
+	out.WriteString("macro(")
+	out.WriteString(strings.Join(params, ", "))
+	out.WriteString(") {\n")
+	out.WriteString(m.Body.String())
+	out.WriteString("\n}")
+	return out.String()
+}
+
 type HashKey struct {
 	Type  ObjectType
 	Value uint64
@@ -236,6 +512,29 @@ type HashPair struct {
 
 type Hash struct {
 	Pairs map[HashKey]HashPair
+	keys  []HashKey
+}
+
+func NewHash() *Hash {
+	return &Hash{Pairs: map[HashKey]HashPair{}}
+}
+
+// Set stores pair under key. A new key is ordered after the existing ones,
+// an existing key keeps its position.
+func (h *Hash) Set(key HashKey, pair HashPair) {
+	if _, ok := h.Pairs[key]; !ok {
+		h.keys = append(h.keys, key)
+	}
+	h.Pairs[key] = pair
+}
+
+// OrderedPairs returns the pairs in insertion order.
+func (h *Hash) OrderedPairs() []HashPair {
+	pairs := make([]HashPair, 0, len(h.keys))
+	for _, k := range h.keys {
+		pairs = append(pairs, h.Pairs[k])
+	}
+	return pairs
 }
 
 func (h *Hash) Type() ObjectType {
@@ -246,7 +545,7 @@ func (h *Hash) Inspect() string {
 	var out bytes.Buffer
 
 	pairs := []string{}
-	for _, pair := range h.Pairs {
+	for _, pair := range h.OrderedPairs() {
 		pairs = append(pairs, fmt.Sprintf("%s: %s", pair.Key.Inspect(), pair.Value.Inspect()))
 	}
 	out.WriteRune('{')
@@ -1,6 +1,10 @@
 package object
 
-import "testing"
+import (
+	"regexp"
+	"strconv"
+	"testing"
+)
 
 func TestStringHashKey(t *testing.T) {
 	hello1 := &String{Value: "Hello World"}
@@ -22,3 +26,220 @@ func TestStringHashKey(t *testing.T) {
 	}
 
 }
+
+func TestHashInsertionOrder(t *testing.T) {
+	hash := NewHash()
+	for _, k := range []string{"b", "c", "a", "c"} {
+		key := &String{Value: k}
+		hash.Set(key.HashKey(), HashPair{Key: key, Value: &Integer{Value: int64(len(hash.Pairs))}})
+	}
+
+	if len(hash.Pairs) != 3 {
+		t.Fatalf("hash has wrong number of pairs. got=%d", len(hash.Pairs))
+	}
+	expected := "{b: 0, c: 3, a: 2}"
+	if hash.Inspect() != expected {
+		t.Errorf("hash.Inspect() wrong. want=%q, got=%q", expected, hash.Inspect())
+	}
+}
+
+func TestEqual(t *testing.T) {
+	one := &Integer{Value: 1}
+	pairs := func(kv ...Object) *Hash {
+		hash := NewHash()
+		for i := 0; i < len(kv); i += 2 {
+			hash.Set(kv[i].(Hashable).HashKey(), HashPair{Key: kv[i], Value: kv[i+1]})
+		}
+		return hash
+	}
+
+	cyclic1 := &Array{}
+	cyclic1.Elements = []Object{one, cyclic1}
+	cyclic2 := &Array{}
+	cyclic2.Elements = []Object{one, cyclic2}
+
+	tests := []struct {
+		a, b     Object
+		expected bool
+	}{
+		{one, &Integer{Value: 1}, true},
+		{one, &Integer{Value: 2}, false},
+		{&String{Value: "a"}, &String{Value: "a"}, true},
+		{&Null{}, &Null{}, true},
+		{&Array{Elements: []Object{one}}, &Array{Elements: []Object{&Integer{Value: 1}}}, true},
+		{&Array{Elements: []Object{one}}, &Array{Elements: []Object{}}, false},
+		{pairs(&String{Value: "a"}, one), pairs(&String{Value: "a"}, &Integer{Value: 1}), true},
+		{pairs(&String{Value: "a"}, one), pairs(&String{Value: "b"}, one), false},
+		{one, &String{Value: "1"}, false},
+		{cyclic1, cyclic2, true},
+	}
+
+	for i, tt := range tests {
+		if Equal(tt.a, tt.b) != tt.expected {
+			t.Errorf("tests[%d] Equal(%s, %s) wrong. want=%t", i, tt.a.Type(), tt.b.Type(), tt.expected)
+		}
+	}
+}
+
+func TestArrayHashKey(t *testing.T) {
+	a1 := &Array{Elements: []Object{&Integer{Value: 1}, &String{Value: "a"}}}
+	a2 := &Array{Elements: []Object{&Integer{Value: 1}, &String{Value: "a"}}}
+	diff := &Array{Elements: []Object{&String{Value: "a"}, &Integer{Value: 1}}}
+
+	if a1.HashKey() != a2.HashKey() {
+		t.Errorf("arrays with same content have different hash keys")
+	}
+	if a1.HashKey() == diff.HashKey() {
+		t.Errorf("arrays with different content have same hash keys")
+	}
+}
+
+func TestArrayHashKeyFollowsEqual(t *testing.T) {
+	fn := &Builtin{}
+	hash := func(v Object) *Hash {
+		h := NewHash()
+		for _, k := range []Object{&String{Value: "a"}, &String{Value: "b"}} {
+			h.Set(k.(Hashable).HashKey(), HashPair{Key: k, Value: v})
+		}
+		return h
+	}
+	swapped := NewHash()
+	for _, k := range []Object{&String{Value: "b"}, &String{Value: "a"}} {
+		swapped.Set(k.(Hashable).HashKey(), HashPair{Key: k, Value: NullValue})
+	}
+	cyclic := &Array{}
+	cyclic.Elements = []Object{&Integer{Value: 1}, cyclic}
+	other := &Array{}
+	other.Elements = []Object{&Integer{Value: 1}, other}
+
+	tests := []struct {
+		a, b *Array
+	}{
+		{&Array{Elements: []Object{hash(NullValue)}}, &Array{Elements: []Object{swapped}}},
+		{&Array{Elements: []Object{fn, NullValue}}, &Array{Elements: []Object{fn, NullValue}}},
+		{&Array{Elements: []Object{&Regex{Value: regexp.MustCompile("a+")}}}, &Array{Elements: []Object{&Regex{Value: regexp.MustCompile("a+")}}}},
+		{cyclic, other},
+	}
+	for _, tt := range tests {
+		if !Equal(tt.a, tt.b) {
+			t.Fatalf("expected %s and %s to be equal", tt.a.Inspect(), tt.b.Inspect())
+		}
+		if tt.a.HashKey() != tt.b.HashKey() {
+			t.Errorf("equal arrays %s and %s have different hash keys", tt.a.Inspect(), tt.b.Inspect())
+		}
+	}
+	if (&Array{Elements: []Object{hash(True)}}).HashKey() == (&Array{Elements: []Object{hash(False)}}).HashKey() {
+		t.Errorf("arrays of different hashes have the same hash key")
+	}
+	if (&Array{Elements: []Object{fn}}).HashKey() == (&Array{Elements: []Object{&Builtin{}}}).HashKey() {
+		t.Errorf("arrays of different functions have the same hash key")
+	}
+}
+
+func TestResolveMethod(t *testing.T) {
+	self := &Builtin{Fn: func(rt Runtime, args ...Object) Object { return args[0] }}
+	RegisterMethod(BOOLEAN, "self", self)
+
+	fn, args, err := ResolveMethod(&Boolean{Value: true}, "self", []Object{&Integer{Value: 1}})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Message)
+	}
+	if fn != self {
+		t.Errorf("wrong method resolved. got=%s", fn.Inspect())
+	}
+	if len(args) != 2 || args[0].Inspect() != "true" {
+		t.Errorf("receiver is not prepended to args. got=%v", args)
+	}
+
+	h := NewHash()
+	key := &String{Value: "self"}
+	h.Set(key.HashKey(), HashPair{Key: key, Value: &Integer{Value: 2}})
+	fn, args, err = ResolveMethod(h, "self", []Object{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Message)
+	}
+	if fn.Inspect() != "2" || len(args) != 0 {
+		t.Errorf("hash entry is not preferred over methods. got=%s %v", fn.Inspect(), args)
+	}
+
+	_, _, err = ResolveMethod(&Null{}, "self", nil)
+	if err == nil || err.Message != "undefined method self for NULL" {
+		t.Errorf("wrong error for undefined method. got=%v", err)
+	}
+}
+
+func TestJSONStringifyCycle(t *testing.T) {
+	a := &Array{}
+	a.Elements = []Object{&Integer{Value: 1}, a}
+	result := jsonStringify(nil, a)
+	if err, ok := result.(*Error); !ok || err.Message != "json.stringify: cannot encode cyclic structure" {
+		t.Errorf("cycle is not reported. got=%s", result.Inspect())
+	}
+
+	shared := &Array{Elements: []Object{}}
+	result = jsonStringify(nil, &Array{Elements: []Object{shared, shared}})
+	if result.Inspect() != "[[],[]]" {
+		t.Errorf("shared array is reported as a cycle. got=%s", result.Inspect())
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	r := NewDefaultRegistry()
+	if r.Len() != len(Builtins) {
+		t.Fatalf("expected %d builtins, got %d", len(Builtins), r.Len())
+	}
+	if i, ok := r.Index("len"); !ok || i != 0 {
+		t.Errorf("expected len at 0, got %d", i)
+	}
+	i, err := r.Register("twice", Arity{Min: 1, Max: 2}, func(rt Runtime, args ...Object) Object {
+		return &Integer{Value: args[0].(*Integer).Value * 2}
+	})
+	if err != nil || i != len(Builtins) {
+		t.Fatalf("expected twice at %d, got %d, %v", len(Builtins), i, err)
+	}
+	twice := r.Get(i).(*Builtin)
+	tests := []struct {
+		args     []Object
+		expected string
+	}{
+		{[]Object{&Integer{Value: 2}}, "4"},
+		{[]Object{}, "ERROR: wrong number of arguments: want=1 to 2, got=0"},
+		{[]Object{NullValue, NullValue, NullValue}, "ERROR: wrong number of arguments: want=1 to 2, got=3"},
+	}
+	for _, tt := range tests {
+		if got := twice.Fn(nil, tt.args...).Inspect(); got != tt.expected {
+			t.Errorf("expected %s, got %s", tt.expected, got)
+		}
+	}
+	if def, ok := r.Def("twice"); !ok || def.Arity != (Arity{Min: 1, Max: 2}) {
+		t.Errorf("wrong definition of twice: %+v", def)
+	}
+
+	if i, err := r.Define("len", NullValue); err != nil || i != 0 {
+		t.Errorf("expected len redefined at 0, got %d, %v", i, err)
+	}
+	if v, _ := r.Lookup("len"); v != NullValue {
+		t.Errorf("expected len redefined, got %s", v.Inspect())
+	}
+	if v, _ := DefaultRegistry().Lookup("len"); v.Type() != BUILTIN {
+		t.Errorf("redefining len changed the default registry")
+	}
+	if _, err := r.Register("bad", Arity{Min: 2, Max: 1}, nil); err == nil {
+		t.Errorf("expected an invalid arity to fail")
+	}
+}
+
+func TestRegistryFull(t *testing.T) {
+	r := NewRegistry()
+	for i := 0; i < MaxBuiltins; i++ {
+		if _, err := r.Define(strconv.Itoa(i), NullValue); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := r.Define("one more", NullValue); err == nil {
+		t.Errorf("expected defining more than %d builtins to fail", MaxBuiltins)
+	}
+	if _, err := r.Define("0", True); err != nil {
+		t.Errorf("expected redefining a builtin of a full registry to succeed, got %v", err)
+	}
+}
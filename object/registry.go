@@ -0,0 +1,140 @@
+package object
+
+import (
+	"fmt"
+	"math"
+)
+
+// MaxBuiltins is the number of builtins a registry can hold, as many as
+// the operand of OpGetBuiltin can refer to.
+const MaxBuiltins = math.MaxUint16 + 1
+
+// Variadic is the Max of the arity of a function taking any number of
+// arguments from Min on.
+const Variadic = -1
+
+// Arity is the number of arguments a builtin function takes, from Min to
+// Max.
+type Arity struct {
+	Min, Max int
+}
+
+// AnyArity is the arity of builtins checking their arguments themselves.
+var AnyArity = Arity{Min: 0, Max: Variadic}
+
+// check returns an error unless a function of arity a can be called with
+// got arguments.
+func (a Arity) check(got int) *Error {
+	if a.Max == Variadic {
+		return CheckArity(a.Min, 0, true, got)
+	}
+	return CheckArity(a.Min, a.Max-a.Min, false, got)
+}
+
+// BuiltinDef is a builtin of a registry.
+type BuiltinDef struct {
+	Name    string
+	Builtin Object
+	Arity   Arity
+}
+
+// Registry holds the builtins of an engine, which programs refer to by
+// name and compiled programs by index. Each engine may have its own, to
+// which the host adds its functions; redefining a name keeps its index, so
+// that programs compiled before see the new value.
+//
+// A Registry is not safe for concurrent use while it is being changed.
+type Registry struct {
+	defs   []BuiltinDef
+	byName map[string]int
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{byName: map[string]int{}}
+}
+
+var defaultRegistry = NewDefaultRegistry()
+
+// DefaultRegistry returns the registry of the Builtins shared by the
+// engines not given one. It must not be changed: a host adding builtins
+// gives its engine a registry of NewDefaultRegistry instead.
+func DefaultRegistry() *Registry {
+	return defaultRegistry
+}
+
+// NewDefaultRegistry returns a registry of the Builtins, in the order of
+// the slice, to which a host can add its own.
+func NewDefaultRegistry() *Registry {
+	r := NewRegistry()
+	for _, def := range Builtins {
+		r.Define(def.Name, def.Builtin)
+	}
+	return r
+}
+
+// Define defines name as value, and returns its index.
+func (r *Registry) Define(name string, value Object) (int, error) {
+	return r.define(BuiltinDef{Name: name, Builtin: value, Arity: AnyArity})
+}
+
+// Register defines name as a function taking arity arguments, which fails
+// with another number of arguments without calling fn, and returns its
+// index.
+func (r *Registry) Register(name string, arity Arity, fn BuiltinFunction) (int, error) {
+	if arity.Min < 0 || (arity.Max != Variadic && arity.Max < arity.Min) {
+		return 0, fmt.Errorf("builtin %s: invalid arity %d to %d", name, arity.Min, arity.Max)
+	}
+	checked := func(rt Runtime, args ...Object) Object {
+		if err := arity.check(len(args)); err != nil {
+			return err
+		}
+		return fn(rt, args...)
+	}
+	return r.define(BuiltinDef{Name: name, Builtin: &Builtin{Fn: checked}, Arity: arity})
+}
+
+func (r *Registry) define(def BuiltinDef) (int, error) {
+	if i, ok := r.byName[def.Name]; ok {
+		r.defs[i] = def
+		return i, nil
+	}
+	if len(r.defs) == MaxBuiltins {
+		return 0, fmt.Errorf("builtin %s: more than %d builtins", def.Name, MaxBuiltins)
+	}
+	r.byName[def.Name] = len(r.defs)
+	r.defs = append(r.defs, def)
+	return len(r.defs) - 1, nil
+}
+
+// Index returns the index of the builtin name, and whether there is one.
+func (r *Registry) Index(name string) (int, bool) {
+	i, ok := r.byName[name]
+	return i, ok
+}
+
+// Lookup returns the builtin name, and whether there is one.
+func (r *Registry) Lookup(name string) (Object, bool) {
+	if i, ok := r.byName[name]; ok {
+		return r.defs[i].Builtin, true
+	}
+	return nil, false
+}
+
+// Get returns the builtin at index.
+func (r *Registry) Get(index int) Object {
+	return r.defs[index].Builtin
+}
+
+// Def returns the definition of the builtin name, and whether there is one.
+func (r *Registry) Def(name string) (BuiltinDef, bool) {
+	if i, ok := r.byName[name]; ok {
+		return r.defs[i], true
+	}
+	return BuiltinDef{}, false
+}
+
+// Len returns the number of builtins in r.
+func (r *Registry) Len() int {
+	return len(r.defs)
+}
@@ -14,6 +14,8 @@ const (
 	_ Precedence = iota
 	LOWEST
 	PIPELINE
+	COMPOSE
+	NULLISH
 	EQUALS
 	LESSGREATER
 	SUM
@@ -35,6 +37,19 @@ var precedences = map[token.TokenType]Precedence{
 	token.LPAREN:   CALL,
 	token.LBRACKET: INDEX,
 	token.PIPELINE: PIPELINE,
+	token.NULLISH:  NULLISH,
+	token.COMPOSE:  COMPOSE,
+	token.OPTIONAL: INDEX,
+	token.DOT:      INDEX,
+}
+
+// PrecedenceOf returns how tightly the infix or postfix operator t binds,
+// or LOWEST when t is not one.
+func PrecedenceOf(t token.TokenType) Precedence {
+	if p, ok := precedences[t]; ok {
+		return p
+	}
+	return LOWEST
 }
 
 type (
@@ -50,16 +65,22 @@ type Parser struct {
 
 	prefixParseFns map[token.TokenType]prefixParseFn
 	infixParseFns  map[token.TokenType]infixParseFn
+
+	// placeholders are the placeholders parsed, and placed those of them
+	// passed to a pipeline call, the only place they can be.
+	placeholders []*ast.Identifier
+	placed       map[*ast.Identifier]bool
 }
 
 func New(l *lexer.Lexer) *Parser {
 	p := &Parser{
 		l:              l,
 		errors:         []error{},
+		placed:         map[*ast.Identifier]bool{},
 		prefixParseFns: map[token.TokenType]prefixParseFn{},
 		infixParseFns:  map[token.TokenType]infixParseFn{},
 	}
-	p.registerPrefix(token.IDENT, p.parseIdentifier)
+	p.registerPrefix(token.IDENT, p.parseIdentifierExpression)
 	p.registerPrefix(token.NUMBER, p.parseNumberLiteral)
 	p.registerPrefix(token.STRING, p.parseStringLiteral)
 	p.registerPrefix(token.BANG, p.parsePrefixExpression)
@@ -71,6 +92,10 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerPrefix(token.FUNCTION, p.parseFunctionLiteral)
 	p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)
 	p.registerPrefix(token.LBRACE, p.parseHashLiteral)
+	p.registerPrefix(token.MATCH, p.parseMatchExpression)
+	p.registerPrefix(token.NULL, p.parseNullLiteral)
+	p.registerPrefix(token.MACRO, p.parseMacroLiteral)
+	p.registerPrefix(token.IMPORT, p.parseImportExpression)
 
 	p.registerInfix(token.EQ, p.parseInfixExpression)
 	p.registerInfix(token.NOT_EQ, p.parseInfixExpression)
@@ -82,7 +107,11 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.ASTERISK, p.parseInfixExpression)
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
 	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
-	p.registerInfix(token.PIPELINE, p.parseInfixExpression)
+	p.registerInfix(token.PIPELINE, p.parsePipelineExpression)
+	p.registerInfix(token.COMPOSE, p.parseInfixExpression)
+	p.registerInfix(token.NULLISH, p.parseInfixExpression)
+	p.registerInfix(token.OPTIONAL, p.parseOptionalExpression)
+	p.registerInfix(token.DOT, p.parseDotExpression)
 
 	p.nextToken()
 	p.nextToken()
@@ -126,6 +155,14 @@ func (p *Parser) Parse() *ast.Program {
 		}
 		p.nextToken()
 	}
+	for _, placeholder := range p.placeholders {
+		if !p.placed[placeholder] {
+			p.errors = append(p.errors, fmt.Errorf("placeholder _ can only be an argument of a pipeline call"))
+		}
+	}
+	for _, c := range p.l.Comments() {
+		program.Comments = append(program.Comments, &ast.Comment{Token: c})
+	}
 
 	return program
 }
@@ -136,6 +173,8 @@ func (p *Parser) parseStatement() ast.Statement {
 		return p.parseLetStatement()
 	case token.RETURN:
 		return p.parseReturnStatement()
+	case token.EXPORT:
+		return p.parseExportStatement()
 	default:
 		return p.parseExpressionStatement()
 	}
@@ -171,8 +210,9 @@ func (p *Parser) parseLetStatement() ast.Statement {
 	stmt.Value = p.parseExpression(LOWEST)
 
 	if fl, ok := stmt.Value.(*ast.FunctionLiteral); ok {
-		// TODO support pattern match
-		fl.Name = stmt.Pattern.(*ast.Identifier).Value
+		if ident, ok := stmt.Pattern.(*ast.Identifier); ok {
+			fl.Name = ident.Value
+		}
 	}
 
 	if p.peekTokenIs(token.SEMICOLON) {
@@ -182,6 +222,19 @@ func (p *Parser) parseLetStatement() ast.Statement {
 	return stmt
 }
 
+func (p *Parser) parseExportStatement() ast.Statement {
+	stmt := &ast.ExportStatement{Token: p.curToken}
+	if !p.expectPeek(token.LET) {
+		return nil
+	}
+	let, ok := p.parseLetStatement().(*ast.LetStatement)
+	if !ok {
+		return nil
+	}
+	stmt.Statement = let
+	return stmt
+}
+
 func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 	stmt := &ast.ExpressionStatement{Token: p.curToken}
 	stmt.Expression = p.parseExpression(LOWEST)
@@ -203,6 +256,7 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 		}
 		p.nextToken()
 	}
+	b.Rbrace = p.curToken.Pos
 	return b
 }
 
@@ -248,6 +302,29 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	return e
 }
 
+func (p *Parser) parsePipelineExpression(left ast.Expression) ast.Expression {
+	e := p.parseInfixExpression(left).(*ast.InfixExpression)
+	var args []ast.Expression
+	switch call := e.Right.(type) {
+	case *ast.CallExpression:
+		args = call.Arguments
+	case *ast.MethodCallExpression:
+		args = call.Arguments
+	}
+	placeholders := 0
+	for _, a := range args {
+		if ast.IsPlaceholder(a) {
+			p.placed[a.(*ast.Identifier)] = true
+			placeholders++
+		}
+	}
+	if placeholders > 1 {
+		p.errors = append(p.errors, fmt.Errorf("pipeline call can contain at most one placeholder, got %d", placeholders))
+		return nil
+	}
+	return e
+}
+
 func (p *Parser) parseGroupedExpression() ast.Expression {
 	p.nextToken()
 
@@ -284,6 +361,58 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	return e
 }
 
+func (p *Parser) parseMatchExpression() ast.Expression {
+	e := &ast.MatchExpression{Token: p.curToken}
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	p.nextToken()
+	e.Value = p.parseExpression(LOWEST)
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	for !p.peekTokenIs(token.RBRACE) {
+		arm := p.parseMatchArm()
+		if arm == nil {
+			return nil
+		}
+		e.Arms = append(e.Arms, arm)
+		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+	return e
+}
+
+func (p *Parser) parseMatchArm() *ast.MatchArm {
+	arm := &ast.MatchArm{Token: p.peekToken}
+	arm.Pattern = p.parseMatchPattern()
+	if arm.Pattern == nil {
+		return nil
+	}
+
+	if p.peekTokenIs(token.IF) {
+		p.nextToken()
+		p.nextToken()
+		arm.Guard = p.parseExpression(LOWEST)
+	}
+
+	if !p.expectPeek(token.ARROW) {
+		return nil
+	}
+	p.nextToken()
+	arm.Body = p.parseExpression(LOWEST)
+	return arm
+}
+
 func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 	exp := &ast.IndexExpression{Token: p.curToken, Left: left}
 	p.nextToken()
@@ -294,6 +423,71 @@ func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 	return exp
 }
 
+func (p *Parser) parseOptionalExpression(left ast.Expression) ast.Expression {
+	switch {
+	case p.peekTokenIs(token.LBRACKET):
+		p.nextToken()
+		exp, ok := p.parseIndexExpression(left).(*ast.IndexExpression)
+		if !ok {
+			return nil
+		}
+		exp.Optional = true
+		return exp
+	case p.peekTokenIs(token.LPAREN):
+		p.nextToken()
+		exp := p.parseCallExpression(left).(*ast.CallExpression)
+		exp.Optional = true
+		return exp
+	case p.peekTokenIs(token.IDENT) || token.IsKeyword(p.peekToken.Type):
+		exp := p.parseDotExpression(left)
+		switch exp := exp.(type) {
+		case *ast.IndexExpression:
+			exp.Optional = true
+		case *ast.MethodCallExpression:
+			exp.Optional = true
+		}
+		return exp
+	default:
+		p.errors = append(p.errors, fmt.Errorf("expected [, ( or identifier after ?., got %s instead", p.peekToken.Type))
+		return nil
+	}
+}
+
+// parseDotExpression parses `left.name`, which indexes left by the string
+// "name", and `left.name(args)`, which calls the method name on left.
+func (p *Parser) parseDotExpression(left ast.Expression) ast.Expression {
+	tok := p.curToken
+	if token.IsKeyword(p.peekToken.Type) {
+		// a keyword is a name after a dot, as in `re.match(s)`
+		p.peekToken.Type = token.IDENT
+	}
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	name := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	if p.peekTokenIs(token.LPAREN) {
+		p.nextToken()
+		exp := &ast.MethodCallExpression{Token: tok, Receiver: left, Method: name}
+		exp.Arguments = p.parseExpressionList(token.RPAREN)
+		return exp
+	}
+	return &ast.IndexExpression{
+		Token: tok,
+		Left:  left,
+		Index: &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal},
+	}
+}
+
+// parseIdentifierExpression parses an identifier used as an expression,
+// keeping track of the placeholders.
+func (p *Parser) parseIdentifierExpression() ast.Expression {
+	ident := p.parseIdentifier().(*ast.Identifier)
+	if ast.IsPlaceholder(ident) {
+		p.placeholders = append(p.placeholders, ident)
+	}
+	return ident
+}
+
 func (p *Parser) parseIdentifier() ast.Expression {
 	return &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 }
@@ -306,6 +500,10 @@ func (p *Parser) parseBooleanLiteral() ast.Expression {
 	return &ast.BooleanLiteral{Token: p.curToken, Value: p.curTokenIs(token.TRUE)}
 }
 
+func (p *Parser) parseNullLiteral() ast.Expression {
+	return &ast.NullLiteral{Token: p.curToken}
+}
+
 func (p *Parser) parseStringLiteral() ast.Expression {
 	return &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
 }
@@ -316,7 +514,9 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 		return nil
 	}
 
-	lit.Parameters = p.parseFunctionParameters()
+	if !p.parseFunctionParameters(lit) {
+		return nil
+	}
 	if !p.expectPeek(token.LBRACE) {
 		return nil
 	}
@@ -325,6 +525,40 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 	return lit
 }
 
+func (p *Parser) parseImportExpression() ast.Expression {
+	e := &ast.ImportExpression{Token: p.curToken}
+	if !p.expectPeek(token.STRING) {
+		return nil
+	}
+	e.Path = &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
+	return e
+}
+
+func (p *Parser) parseMacroLiteral() ast.Expression {
+	lit := &ast.MacroLiteral{Token: p.curToken}
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	lit.Parameters = []*ast.Identifier{}
+	for !p.peekTokenIs(token.RPAREN) {
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		lit.Parameters = append(lit.Parameters, &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
+		if !p.peekTokenIs(token.RPAREN) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+	p.nextToken()
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	lit.Body = p.parseBlockStatement()
+	return lit
+}
+
 func (p *Parser) parseArrayLiteral() ast.Expression {
 	array := &ast.ArrayLiteral{Token: p.curToken}
 	array.Elements = p.parseExpressionList(token.RBRACKET)
@@ -332,7 +566,7 @@ func (p *Parser) parseArrayLiteral() ast.Expression {
 }
 
 func (p *Parser) parseHashLiteral() ast.Expression {
-	hash := &ast.HashLiteral{Token: p.curToken, Pairs: map[ast.Expression]ast.Expression{}}
+	hash := &ast.HashLiteral{Token: p.curToken, Pairs: []ast.HashPair{}}
 
 	for !p.peekTokenIs(token.RBRACE) {
 		p.nextToken()
@@ -344,7 +578,7 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 		p.nextToken()
 		value := p.parseExpression(LOWEST)
 
-		hash.Pairs[key] = value
+		hash.Pairs = append(hash.Pairs, ast.HashPair{Key: key, Value: value})
 		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
 			return nil
 		}
@@ -357,31 +591,59 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 	return hash
 }
 
-func (p *Parser) parseFunctionParameters() []ast.Pattern {
-	patterns := []ast.Pattern{}
+// parseFunctionParameters parses the parameters of lit: patterns optionally
+// followed by `= default`, and a final `...rest` parameter.
+func (p *Parser) parseFunctionParameters(lit *ast.FunctionLiteral) bool {
+	lit.Parameters = []ast.Pattern{}
 
 	if p.peekTokenIs(token.RPAREN) {
 		p.nextToken()
-		return patterns
+		return true
 	}
 
-	pattern := p.parsePattern()
-	if pattern == nil {
-		return patterns
-	}
-	patterns = append(patterns, pattern)
+	defaults := []ast.Expression{}
+	hasDefault := false
+	for {
+		if p.peekTokenIs(token.ELLIPSIS) {
+			p.nextToken()
+			if !p.expectPeek(token.IDENT) {
+				return false
+			}
+			lit.Rest = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+			if !p.peekTokenIs(token.RPAREN) {
+				p.errors = append(p.errors, fmt.Errorf("rest parameter %s must be the last parameter", lit.Rest.Value))
+				return false
+			}
+			break
+		}
 
-	for p.peekTokenIs(token.COMMA) {
+		pattern := p.parsePattern()
+		if pattern == nil {
+			return false
+		}
+		var value ast.Expression
+		if p.peekTokenIs(token.ASSIGN) {
+			p.nextToken()
+			p.nextToken()
+			value = p.parseExpression(LOWEST)
+			hasDefault = true
+		} else if hasDefault {
+			p.errors = append(p.errors, fmt.Errorf("parameter %s without default follows a parameter with default", pattern.String()))
+			return false
+		}
+		lit.Parameters = append(lit.Parameters, pattern)
+		defaults = append(defaults, value)
+
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
 		p.nextToken()
-		ident := p.parsePattern()
-		patterns = append(patterns, ident)
 	}
-
-	if !p.expectPeek(token.RPAREN) {
-		return nil
+	if hasDefault {
+		lit.Defaults = defaults
 	}
 
-	return patterns
+	return p.expectPeek(token.RPAREN)
 }
 
 func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
@@ -399,11 +661,11 @@ func (p *Parser) parseExpressionList(end token.TokenType) []ast.Expression {
 
 	p.nextToken()
 
-	args = append(args, p.parseExpression(LOWEST))
+	args = append(args, p.parseListElement())
 	for p.peekTokenIs(token.COMMA) {
 		p.nextToken()
 		p.nextToken()
-		args = append(args, p.parseExpression(LOWEST))
+		args = append(args, p.parseListElement())
 	}
 	if !p.expectPeek(end) {
 		return nil
@@ -412,34 +674,94 @@ func (p *Parser) parseExpressionList(end token.TokenType) []ast.Expression {
 	return args
 }
 
+// parseListElement parses an element of an argument list or array literal,
+// which may be spread with `...`.
+func (p *Parser) parseListElement() ast.Expression {
+	if !p.curTokenIs(token.ELLIPSIS) {
+		return p.parseExpression(LOWEST)
+	}
+	e := &ast.SpreadExpression{Token: p.curToken}
+	p.nextToken()
+	e.Value = p.parseExpression(LOWEST)
+	return e
+}
+
 func (p *Parser) parsePattern() ast.Pattern {
-	if p.peekTokenIs(token.IDENT) {
+	return p.parseNextPattern(false)
+}
+
+func (p *Parser) parseMatchPattern() ast.Pattern {
+	return p.parseNextPattern(true)
+}
+
+// parseNextPattern parses the pattern starting at the peek token.
+// Literal patterns are only accepted when literal is true.
+func (p *Parser) parseNextPattern(literal bool) ast.Pattern {
+	switch {
+	case p.peekTokenIs(token.IDENT):
 		p.nextToken()
+		if p.curToken.Literal == "_" {
+			return &ast.WildcardPattern{Token: p.curToken}
+		}
 		return &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-	} else if p.peekTokenIs(token.LBRACKET) {
+	case p.peekTokenIs(token.LBRACKET):
 		p.nextToken()
 		pattern := &ast.ArrayPattern{Token: p.curToken}
 		for !p.peekTokenIs(token.RBRACKET) {
-			pattern.Pattern = append(pattern.Pattern, p.parsePattern())
-			if p.peekTokenIs(token.COMMA) {
-				p.nextToken()
+			element := p.parseNextPattern(literal)
+			if element == nil {
+				return nil
+			}
+			pattern.Pattern = append(pattern.Pattern, element)
+			if !p.peekTokenIs(token.RBRACKET) && !p.expectPeek(token.COMMA) {
+				return nil
 			}
 		}
 		p.nextToken()
 		return pattern
-	} else if p.peekTokenIs(token.LBRACE) {
+	case p.peekTokenIs(token.LBRACE):
 		p.nextToken()
 		pattern := &ast.HashPattern{Token: p.curToken}
 		for !p.peekTokenIs(token.RBRACE) {
-			p.nextToken()
+			if !p.expectPeek(token.IDENT) {
+				return nil
+			}
 			pattern.Pattern = append(pattern.Pattern, p.parseIdentifier().(*ast.Identifier))
-			if p.peekTokenIs(token.COMMA) {
+			var value ast.Pattern
+			if p.peekTokenIs(token.COLON) {
 				p.nextToken()
+				value = p.parseNextPattern(literal)
+				if value == nil {
+					return nil
+				}
+			}
+			pattern.Values = append(pattern.Values, value)
+			if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+				return nil
 			}
 		}
 		p.nextToken()
 		return pattern
-	} else {
+	case literal && p.peekTokenIs(token.NUMBER):
+		p.nextToken()
+		return &ast.NumberLiteral{Token: p.curToken, Value: p.curToken.Literal}
+	case literal && p.peekTokenIs(token.MINUS):
+		p.nextToken()
+		if !p.expectPeek(token.NUMBER) {
+			return nil
+		}
+		return &ast.NumberLiteral{Token: p.curToken, Value: "-" + p.curToken.Literal}
+	case literal && p.peekTokenIs(token.STRING):
+		p.nextToken()
+		return &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
+	case literal && (p.peekTokenIs(token.TRUE) || p.peekTokenIs(token.FALSE)):
+		p.nextToken()
+		return &ast.BooleanLiteral{Token: p.curToken, Value: p.curTokenIs(token.TRUE)}
+	case literal && p.peekTokenIs(token.NULL):
+		p.nextToken()
+		return &ast.NullLiteral{Token: p.curToken}
+	default:
+		p.errors = append(p.errors, fmt.Errorf("expected pattern, got %s instead", p.peekToken.Type))
 		return nil
 	}
 }
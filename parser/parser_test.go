@@ -3,6 +3,7 @@ package parser
 import (
 	"bytes"
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/wreulicke/monkey/ast"
@@ -213,6 +214,197 @@ func TestParsingPipelineOperator(t *testing.T) {
 	}
 }
 
+func TestFunctionDefaultsAndRest(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"fn(a, b = 1) { a }", "fn(a, b = 1) a"},
+		{"fn(a, ...rest) { a }", "fn(a, ...rest) a"},
+		{"fn(a = 1 + 2, ...rest) { a }", "fn(a = (1 + 2), ...rest) a"},
+		{"fn(...rest) { rest }", "fn(...rest) rest"},
+		{"f(...xs, 1)", "f(...xs, 1)"},
+		{"[...a, ...b.c]", "[...a, ...(b[c])]"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(bytes.NewBufferString(tt.input))
+		p := New(l)
+		program := p.Parse()
+		checkParserErrors(t, p)
+		if program.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+}
+
+func TestMacroLiteralParsing(t *testing.T) {
+	input := `macro(x, y) { x + y; }`
+	l := lexer.New(bytes.NewBufferString(input))
+	p := New(l)
+	program := p.Parse()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program does not contain %d statements. got=%d", 1, len(program.Statements))
+	}
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.ExpressionStatement. got=%T", program.Statements[0])
+	}
+	macro, ok := stmt.Expression.(*ast.MacroLiteral)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.MacroLiteral. got=%T", stmt.Expression)
+	}
+	if len(macro.Parameters) != 2 {
+		t.Fatalf("macro literal parameters wrong. want 2, got=%d", len(macro.Parameters))
+	}
+	testIdentifier(t, macro.Parameters[0], "x")
+	testIdentifier(t, macro.Parameters[1], "y")
+	if macro.Body.String() != "(x + y)" {
+		t.Errorf("macro.Body wrong. got=%q", macro.Body.String())
+	}
+}
+
+func TestFunctionParameterErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"fn(a = 1, b) { a }", "parameter b without default follows a parameter with default"},
+		{"fn(...a, b) { a }", "rest parameter a must be the last parameter"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(bytes.NewBufferString(tt.input))
+		p := New(l)
+		p.Parse()
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Fatalf("expected errors for %q", tt.input)
+		}
+		if errors[0].Error() != tt.expected {
+			t.Errorf("wrong error. want=%q, got=%q", tt.expected, errors[0].Error())
+		}
+	}
+}
+
+func TestImportExport(t *testing.T) {
+	input := `let m = import "./lib/m"; export let [a, {b, c: d}] = m.f(); import "x".y;`
+	l := lexer.New(bytes.NewBufferString(input))
+	p := New(l)
+	program := p.Parse()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 3 {
+		t.Fatalf("program.Statements does not contain 3 statements. got=%d", len(program.Statements))
+	}
+	let, ok := program.Statements[0].(*ast.LetStatement)
+	if !ok {
+		t.Fatalf("stmt is not *ast.LetStatement. got=%T", program.Statements[0])
+	}
+	imp, ok := let.Value.(*ast.ImportExpression)
+	if !ok {
+		t.Fatalf("let.Value is not *ast.ImportExpression. got=%T", let.Value)
+	}
+	if imp.Path.Value != "./lib/m" {
+		t.Errorf("imp.Path.Value is not ./lib/m. got=%q", imp.Path.Value)
+	}
+
+	export, ok := program.Statements[1].(*ast.ExportStatement)
+	if !ok {
+		t.Fatalf("stmt is not *ast.ExportStatement. got=%T", program.Statements[1])
+	}
+	names := []string{}
+	for _, name := range export.Names() {
+		names = append(names, name.Value)
+	}
+	if strings.Join(names, ",") != "a,b,d" {
+		t.Errorf("wrong exported names. want=a,b,d, got=%v", names)
+	}
+
+	if program.Statements[2].String() != `(import "x"[y])` {
+		t.Errorf("wrong program. got=%s", program.Statements[2].String())
+	}
+}
+
+func TestImportExportErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"export x", "expected next token to be LET, got IDENT instead"},
+		{"import x", "expected next token to be STRING, got IDENT instead"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(bytes.NewBufferString(tt.input))
+		p := New(l)
+		p.Parse()
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Fatalf("expected errors for %q", tt.input)
+		}
+		if errors[0].Error() != tt.expected {
+			t.Errorf("wrong error. want=%q, got=%q", tt.expected, errors[0].Error())
+		}
+	}
+}
+
+func TestPipelinePlaceholderErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"x | f(_, 1, _)", "pipeline call can contain at most one placeholder, got 2"},
+		{"x | m.f(_, _)", "pipeline call can contain at most one placeholder, got 2"},
+		{"_", "placeholder _ can only be an argument of a pipeline call"},
+		{"f(_)", "placeholder _ can only be an argument of a pipeline call"},
+		{"x | f(g(_))", "placeholder _ can only be an argument of a pipeline call"},
+		{"let y = _ + 1", "placeholder _ can only be an argument of a pipeline call"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(bytes.NewBufferString(tt.input))
+		p := New(l)
+		p.Parse()
+
+		errors := p.Errors()
+		if len(errors) != 1 {
+			t.Fatalf("wrong number of errors for %q. got=%v", tt.input, errors)
+		}
+		if errors[0].Error() != tt.expected {
+			t.Errorf("wrong error for %q. want=%q, got=%q", tt.input, tt.expected, errors[0].Error())
+		}
+	}
+}
+
+func TestPipelineMethodCall(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"x | math.pow(2, _)", "math.pow(2, x)"},
+		{"x | json.stringify(2)", "json.stringify(x, 2)"},
+		{"x | f(_, 1)", "f(x, 1)"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(bytes.NewBufferString(tt.input))
+		p := New(l)
+		program := p.Parse()
+		checkParserErrors(t, p)
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		call, ok := stmt.Expression.(*ast.InfixExpression).PipelineCall()
+		if !ok {
+			t.Fatalf("%q is not a pipeline call", tt.input)
+		}
+		if call.String() != tt.expected {
+			t.Errorf("wrong call for %q. want=%q, got=%q", tt.input, tt.expected, call.String())
+		}
+	}
+}
+
 func TestParsingEmptyHashLiteral(t *testing.T) {
 	input := `{}`
 	l := lexer.New(bytes.NewBufferString(input))
@@ -261,13 +453,22 @@ func TestParsingHashLiteral(t *testing.T) {
 		"three": 3,
 	}
 
-	for k, v := range hash.Pairs {
-		literal, ok := k.(*ast.StringLiteral)
+	expectedKeys := []string{"one", "two", "three"}
+	for i, pair := range hash.Pairs {
+		literal, ok := pair.Key.(*ast.StringLiteral)
 		if !ok {
-			t.Errorf("key is not ast.StringLiteral. got=%T", k)
+			t.Errorf("key is not ast.StringLiteral. got=%T", pair.Key)
+			continue
+		}
+		if literal.String() != expectedKeys[i] {
+			t.Errorf("pairs[%d] has wrong key. want=%q, got=%q", i, expectedKeys[i], literal.String())
 		}
 		expectedValue := expected[literal.String()]
-		testNumberLiteral(t, v, fmt.Sprintf("%v", expectedValue))
+		testNumberLiteral(t, pair.Value, fmt.Sprintf("%v", expectedValue))
+	}
+
+	if hash.String() != "{one: 1, two: 2, three: 3}" {
+		t.Errorf("hash.String() wrong. got=%q", hash.String())
 	}
 }
 
@@ -339,17 +540,17 @@ func TestParsingHashLiteralWithExpression(t *testing.T) {
 		},
 	}
 
-	for k, v := range hash.Pairs {
-		literal, ok := k.(*ast.StringLiteral)
+	for _, pair := range hash.Pairs {
+		literal, ok := pair.Key.(*ast.StringLiteral)
 		if !ok {
-			t.Errorf("key is not ast.StringLiteral. got=%T", k)
+			t.Errorf("key is not ast.StringLiteral. got=%T", pair.Key)
 		}
 		assertion, ok := expected[literal.String()]
 		if !ok {
 			t.Errorf("No test function for key %q found", literal.String())
 			continue
 		}
-		assertion(v)
+		assertion(pair.Value)
 	}
 }
 
@@ -387,6 +588,87 @@ func TestParsingIndexExpressions(t *testing.T) {
 	})
 }
 
+func TestDotExpression(t *testing.T) {
+	input := "person.name"
+	l := lexer.New(bytes.NewBufferString(input))
+	p := New(l)
+	program := p.Parse()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program does not contain %d statements. got=%d", 1, len(program.Statements))
+	}
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.ExpressionStatement. got=%T", program.Statements[0])
+	}
+	indexExp, ok := stmt.Expression.(*ast.IndexExpression)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.IndexExpression. got=%T", stmt.Expression)
+	}
+	testIdentifier(t, indexExp.Left, "person")
+	str, ok := indexExp.Index.(*ast.StringLiteral)
+	if !ok {
+		t.Fatalf("indexExp.Index is not ast.StringLiteral. got=%T", indexExp.Index)
+	}
+	if str.Value != "name" {
+		t.Errorf("str.Value is not %q. got=%q", "name", str.Value)
+	}
+}
+
+func TestMethodCallExpression(t *testing.T) {
+	input := "list.push(1, 2 * 3)"
+	l := lexer.New(bytes.NewBufferString(input))
+	p := New(l)
+	program := p.Parse()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program does not contain %d statements. got=%d", 1, len(program.Statements))
+	}
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.ExpressionStatement. got=%T", program.Statements[0])
+	}
+	exp, ok := stmt.Expression.(*ast.MethodCallExpression)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.MethodCallExpression. got=%T", stmt.Expression)
+	}
+	testIdentifier(t, exp.Receiver, "list")
+	testIdentifier(t, exp.Method, "push")
+	if len(exp.Arguments) != 2 {
+		t.Fatalf("wrong length of arguments. got=%d", len(exp.Arguments))
+	}
+	testNumberLiteral(t, exp.Arguments[0], "1")
+	if exp.Arguments[1].String() != "(2 * 3)" {
+		t.Errorf("wrong second argument. got=%s", exp.Arguments[1].String())
+	}
+}
+
+func TestKeywordAfterDot(t *testing.T) {
+	input := "re.match(s); h?.if"
+	l := lexer.New(bytes.NewBufferString(input))
+	p := New(l)
+	program := p.Parse()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("program does not contain %d statements. got=%d", 2, len(program.Statements))
+	}
+	call, ok := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.MethodCallExpression)
+	if !ok {
+		t.Fatalf("first statement is not ast.MethodCallExpression. got=%T", program.Statements[0].(*ast.ExpressionStatement).Expression)
+	}
+	testIdentifier(t, call.Method, "match")
+	index, ok := program.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.IndexExpression)
+	if !ok {
+		t.Fatalf("second statement is not ast.IndexExpression. got=%T", program.Statements[1].(*ast.ExpressionStatement).Expression)
+	}
+	if str, ok := index.Index.(*ast.StringLiteral); !ok || str.Value != "if" || !index.Optional {
+		t.Errorf("wrong optional index. got=%s", index.String())
+	}
+}
+
 func TestArrayLiteral(t *testing.T) {
 	input := "[1, 2 * 2, 3 + 3]"
 	l := lexer.New(bytes.NewBufferString(input))
@@ -585,6 +867,86 @@ func TestFunctionLiteralParsing(t *testing.T) {
 		})
 }
 
+func TestMatchExpression(t *testing.T) {
+	input := `match (x) { 1 => "one", [a, _] if a > 1 => a, {kind: "circle", r} => r, _ => 0, }`
+	l := lexer.New(bytes.NewBufferString(input))
+	p := New(l)
+	program := p.Parse()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program does not contain %d statements. got=%d", 1, len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.ExpressionStatement. got=%T", program.Statements[0])
+	}
+	exp, ok := stmt.Expression.(*ast.MatchExpression)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.MatchExpression. got=%T", stmt.Expression)
+	}
+	if !testIdentifier(t, exp.Value, "x") {
+		return
+	}
+
+	tests := []struct {
+		pattern string
+		guard   string
+		body    string
+	}{
+		{"1", "", "one"},
+		{"[a, _]", "(a > 1)", "a"},
+		{"{kind: circle, r}", "", "r"},
+		{"_", "", "0"},
+	}
+	if len(exp.Arms) != len(tests) {
+		t.Fatalf("exp.Arms has wrong length. want=%d, got=%d", len(tests), len(exp.Arms))
+	}
+	for i, tt := range tests {
+		arm := exp.Arms[i]
+		if arm.Pattern.String() != tt.pattern {
+			t.Errorf("arms[%d] pattern wrong. want=%q, got=%q", i, tt.pattern, arm.Pattern.String())
+		}
+		guard := ""
+		if arm.Guard != nil {
+			guard = arm.Guard.String()
+		}
+		if guard != tt.guard {
+			t.Errorf("arms[%d] guard wrong. want=%q, got=%q", i, tt.guard, guard)
+		}
+		if arm.Body.String() != tt.body {
+			t.Errorf("arms[%d] body wrong. want=%q, got=%q", i, tt.body, arm.Body.String())
+		}
+	}
+	if _, ok := exp.Arms[3].Pattern.(*ast.WildcardPattern); !ok {
+		t.Errorf("arms[3] pattern is not ast.WildcardPattern. got=%T", exp.Arms[3].Pattern)
+	}
+}
+
+func TestMatchExpressionErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`match (x) { 1 2 }`, "expected next token to be ARROW, got NUMBER instead"},
+		{`match (x) { => 1 }`, "expected pattern, got ARROW instead"},
+		{`let [1] = x`, "expected pattern, got NUMBER instead"},
+	}
+	for _, tt := range tests {
+		l := lexer.New(bytes.NewBufferString(tt.input))
+		p := New(l)
+		p.Parse()
+		if len(p.Errors()) == 0 {
+			t.Errorf("expected parser errors for %q", tt.input)
+			continue
+		}
+		if p.Errors()[0].Error() != tt.expected {
+			t.Errorf("wrong error for %q. want=%q, got=%q", tt.input, tt.expected, p.Errors()[0])
+		}
+	}
+}
+
 func TestIfExpression(t *testing.T) {
 	input := `if (x < y) { x }`
 	l := lexer.New(bytes.NewBufferString(input))
@@ -700,6 +1062,46 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"-a * b",
 			"((-a) * b)",
 		},
+		{
+			"a ?? b == c",
+			"(a ?? (b == c))",
+		},
+		{
+			"a ?? b ?? c",
+			"((a ?? b) ?? c)",
+		},
+		{
+			"a?.[b]?.(c) ?? null",
+			"((a?.[b])?.(c) ?? null)",
+		},
+		{
+			"a | f ?? g",
+			"(a | (f ?? g))",
+		},
+		{
+			"a | f >> g",
+			"(a | (f >> g))",
+		},
+		{
+			"f >> g >> h",
+			"((f >> g) >> h)",
+		},
+		{
+			"a | f(b, _) | g",
+			"((a | f(b, _)) | g)",
+		},
+		{
+			"-a.b * c",
+			"((-(a[b])) * c)",
+		},
+		{
+			"a.b.c(d).e",
+			"((a[b]).c(d)[e])",
+		},
+		{
+			"a?.b?.c()",
+			"(a?.[b])?.c()",
+		},
 		{
 			"!-a",
 			"(!(-a))",
@@ -1,8 +1,27 @@
 package token
 
+import "fmt"
+
 type Token struct {
 	Type    TokenType
 	Literal string
+	Pos     Position
+}
+
+// Position is the line and column, both starting at 1, of the first
+// character of a token. Columns count bytes.
+type Position struct {
+	Line   int
+	Column int
+}
+
+// IsValid reports whether the position is known.
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
 }
 
 var typeNames = []string{
@@ -12,6 +31,7 @@ var typeNames = []string{
 	"IDENT",
 	"NUMBER",
 	"STRING",
+	"COMMENT",
 
 	"ASSIGN",
 	"PLUS",
@@ -20,6 +40,12 @@ var typeNames = []string{
 	"ASTERISK",
 	"SLASH",
 	"PIPELINE",
+	"COMPOSE",
+	"ARROW",
+	"NULLISH",
+	"OPTIONAL",
+	"DOT",
+	"ELLIPSIS",
 
 	"EQ",
 	"NOT_EQ",
@@ -47,6 +73,11 @@ var typeNames = []string{
 	"FALSE",
 	"IF",
 	"ELSE",
+	"MATCH",
+	"NULL",
+	"MACRO",
+	"IMPORT",
+	"EXPORT",
 }
 
 type TokenType int
@@ -62,6 +93,7 @@ const (
 	IDENT
 	NUMBER
 	STRING
+	COMMENT
 
 	ASSIGN
 	PLUS
@@ -70,6 +102,12 @@ const (
 	ASTERISK
 	SLASH
 	PIPELINE
+	COMPOSE
+	ARROW
+	NULLISH
+	OPTIONAL
+	DOT
+	ELLIPSIS
 
 	EQ
 	NOT_EQ
@@ -97,6 +135,11 @@ const (
 	FALSE
 	IF
 	ELSE
+	MATCH
+	NULL
+	MACRO
+	IMPORT
+	EXPORT
 )
 
 var keywords = map[string]TokenType{
@@ -107,6 +150,21 @@ var keywords = map[string]TokenType{
 	"false":  FALSE,
 	"if":     IF,
 	"else":   ELSE,
+	"match":  MATCH,
+	"null":   NULL,
+	"macro":  MACRO,
+	"import": IMPORT,
+	"export": EXPORT,
+}
+
+// IsKeyword reports whether t is the type of a keyword.
+func IsKeyword(t TokenType) bool {
+	for _, k := range keywords {
+		if k == t {
+			return true
+		}
+	}
+	return false
 }
 
 func LookupIdent(ident string) TokenType {
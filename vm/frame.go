@@ -9,10 +9,15 @@ type Frame struct {
 	cl          *object.Closure
 	ip          int
 	basePointer int
+	// numArgs is the number of parameters the caller gave arguments for.
+	numArgs int
+	// exportsGlobal is the global the value returned by a module is stored
+	// in, or -1 when the frame does not run a module.
+	exportsGlobal int
 }
 
 func NewFrame(cl *object.Closure, basePointer int) *Frame {
-	return &Frame{cl: cl, ip: -1, basePointer: basePointer}
+	return &Frame{cl: cl, ip: -1, basePointer: basePointer, exportsGlobal: -1}
 }
 
 func (f *Frame) Instructions() code.Instructions {
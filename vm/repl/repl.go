@@ -3,11 +3,17 @@ package repl
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"os"
+	"os/signal"
 
 	"github.com/wreulicke/monkey/compiler"
+	"github.com/wreulicke/monkey/interpreter"
 	"github.com/wreulicke/monkey/lexer"
+	"github.com/wreulicke/monkey/module"
 	"github.com/wreulicke/monkey/object"
 	"github.com/wreulicke/monkey/parser"
 	"github.com/wreulicke/monkey/vm"
@@ -19,10 +25,14 @@ func Start(in io.Reader, out io.Writer) {
 	scanner := bufio.NewScanner(in)
 	constants := []object.Object{}
 	globals := make([]object.Object, vm.GlobalsSize)
-	symbolTable := compiler.NewSymbolTable()
-	for i, v := range object.Builtins {
-		symbolTable.DefineBuiltin(i, v.Name)
+	macroEnv := object.NewEnvironment()
+	// the prompt reads the standard input, so programs may not
+	ctx := &object.Context{
+		Modules:      module.NewLoader(module.SearchPath()...),
+		Capabilities: object.CapFileSystem,
+		Stdout:       out,
 	}
+	symbolTable := compiler.NewSymbolTable()
 
 	for {
 		fmt.Fprintf(out, PROMPT)
@@ -42,17 +52,33 @@ func Start(in io.Reader, out io.Writer) {
 			continue
 		}
 
+		interpreter.DefineMacros(program, macroEnv)
+		expanded, expandErr := interpreter.ExpandMacros(program, macroEnv)
+		if expandErr != nil {
+			fmt.Fprintf(out, "Woops! Macro expansion failed:\n %s\n", expandErr.Message)
+			continue
+		}
+
 		comp := compiler.NewWithState(symbolTable, constants)
-		err := comp.Compile(program)
+		comp.SetContext(ctx)
+		err := comp.Compile(expanded)
+		// keep the constants of modules loaded before a failure, which
+		// the module loader refers to from then on
+		code := comp.Bytecode()
+		constants = code.Constants
 		if err != nil {
 			fmt.Fprintf(out, "Woops! Compilation failed:\n %s\n", err)
 			continue
 		}
-		code := comp.Bytecode()
-		constants = code.Constants
 
 		machine := vm.NewWithGlobalsStore(comp.Bytecode(), globals)
-		err = machine.Run()
+		machine.SetContext(ctx)
+		err = run(machine)
+		var canceled *object.CanceledError
+		if errors.As(err, &canceled) {
+			fmt.Fprintln(out, "Interrupted")
+			continue
+		}
 		if err != nil {
 			fmt.Fprintf(out, "Woops! Exceuting bytecode failed:\n %s\n", err)
 			continue
@@ -68,6 +94,13 @@ func Start(in io.Reader, out io.Writer) {
 	}
 }
 
+// run runs machine until the program ends or Ctrl-C interrupts it.
+func run(machine *vm.VM) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	return machine.RunContext(ctx)
+}
+
 func printParseErrors(out io.Writer, errors []error) {
 	for _, msg := range errors {
 		fmt.Fprintln(out, "\t", msg)
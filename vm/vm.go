@@ -1,6 +1,7 @@
 package vm
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/wreulicke/monkey/code"
@@ -12,9 +13,32 @@ const StackSize = 2048
 const GlobalsSize = 65536
 const MaxFrames = 1024
 
-var Null = &object.Null{}
-var True = &object.Boolean{Value: true}
-var False = &object.Boolean{Value: false}
+var Null = object.NullValue
+var True = object.True
+var False = object.False
+
+// composeFn is the body of the closures OpCompose creates, calling the first
+// free function and passing the result to the second.
+var composeFn = &object.CompiledFunction{
+	Instructions: concatInstructions(
+		code.Make(code.OpGetFree, 1),
+		code.Make(code.OpGetFree, 0),
+		code.Make(code.OpGetLocal, 0),
+		code.Make(code.OpCall, 1),
+		code.Make(code.OpCall, 1),
+		code.Make(code.OpReturnValue),
+	),
+	NumLocals:     1,
+	NumParameters: 1,
+}
+
+func concatInstructions(ins ...code.Instructions) code.Instructions {
+	out := code.Instructions{}
+	for _, i := range ins {
+		out = append(out, i...)
+	}
+	return out
+}
 
 type VM struct {
 	constants []object.Object
@@ -25,6 +49,11 @@ type VM struct {
 
 	frames      []*Frame
 	framesIndex int
+
+	// callErr is the error a call made by a builtin failed with.
+	callErr error
+
+	ctx *object.Context
 }
 
 func New(bytecode *compiler.Bytecode) *VM {
@@ -65,11 +94,26 @@ func (vm *VM) LastPoppedStackElem() object.Object {
 }
 
 func (vm *VM) Run() error {
+	return vm.run(0)
+}
+
+// RunContext runs the program like Run, but stops with a
+// *object.CanceledError when ctx is done before the program ends.
+func (vm *VM) RunContext(ctx context.Context) error {
+	outer := vm.ctx
+	vm.ctx = outer.WithDone(ctx)
+	defer func() { vm.ctx = outer }()
+	return vm.run(0)
+}
+
+// run executes instructions until the frame at index exitFrame returns,
+// or until the instructions of the main frame run out.
+func (vm *VM) run(exitFrame int) error {
 	var ip int
 	var ins code.Instructions
 	var op code.Opcode
 
-	for vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
+	for vm.framesIndex > exitFrame && vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
 		vm.currentFrame().ip++
 
 		ip = vm.currentFrame().ip
@@ -126,6 +170,35 @@ func (vm *VM) Run() error {
 		case code.OpJump:
 			pos := int(code.ReadUint16(ins[ip+1:]))
 			vm.currentFrame().ip = pos - 1
+			if pos <= ip {
+				if err := vm.ctx.Err(); err != nil {
+					return err
+				}
+			}
+		case code.OpJumpNull:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			if vm.StackTop().Type() == object.NULL {
+				vm.currentFrame().ip = pos - 1
+			}
+		case code.OpJumpNotNull:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			if vm.StackTop().Type() != object.NULL {
+				vm.currentFrame().ip = pos - 1
+			} else {
+				vm.pop()
+			}
+		case code.OpJumpArgGiven:
+			paramIndex := int(code.ReadUint8(ins[ip+1:]))
+			pos := int(code.ReadUint16(ins[ip+2:]))
+			vm.currentFrame().ip += 3
+
+			if paramIndex < vm.currentFrame().numArgs {
+				vm.currentFrame().ip = pos - 1
+			}
 		case code.OpJumpNotTruthy:
 			pos := int(code.ReadUint16(ins[ip+1:]))
 			vm.currentFrame().ip += 2
@@ -168,11 +241,10 @@ func (vm *VM) Run() error {
 				return err
 			}
 		case code.OpGetBuiltin:
-			builtinIndex := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip += 1
+			builtinIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
 
-			definition := object.Builtins[builtinIndex]
-			err := vm.push(definition.Builtin)
+			err := vm.push(vm.ctx.BuiltinRegistry().Get(int(builtinIndex)))
 			if err != nil {
 				return err
 			}
@@ -182,6 +254,16 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
+		case code.OpCompose:
+			g := vm.pop()
+			f := vm.pop()
+			if !isCallable(f) || !isCallable(g) {
+				return fmt.Errorf("cannot compose %s and %s", f.Type(), g.Type())
+			}
+			err := vm.push(&object.Closure{Fn: composeFn, Free: []object.Object{f, g}})
+			if err != nil {
+				return err
+			}
 		case code.OpPop:
 			vm.pop()
 		case code.OpNull:
@@ -193,9 +275,13 @@ func (vm *VM) Run() error {
 			numElements := int(code.ReadUint16(ins[ip+1:]))
 			vm.currentFrame().ip += 2
 
+			numElements, err := vm.expandSpreads(numElements)
+			if err != nil {
+				return err
+			}
 			array := vm.buildArray(vm.sp-numElements, vm.sp)
 			vm.sp -= numElements
-			err := vm.push(array)
+			err = vm.push(array)
 			if err != nil {
 				return err
 			}
@@ -203,7 +289,7 @@ func (vm *VM) Run() error {
 			numElements := int(code.ReadUint16(ins[ip+1:]))
 			vm.currentFrame().ip += 2
 
-			hash, err := vm.buildHash(numElements/2, vm.sp-numElements, vm.sp)
+			hash, err := vm.buildHash(vm.sp-numElements, vm.sp)
 			if err != nil {
 				return err
 			}
@@ -213,6 +299,16 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
+		case code.OpSpread:
+			value := vm.pop()
+			array, ok := value.(*object.Array)
+			if !ok {
+				return fmt.Errorf("cannot spread %s", value.Type())
+			}
+			err := vm.push(&spread{elements: array.Elements})
+			if err != nil {
+				return err
+			}
 		case code.OpIndex:
 			index := vm.pop()
 			left := vm.pop()
@@ -220,11 +316,103 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
+		case code.OpMatchArray:
+			length := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			array, ok := vm.pop().(*object.Array)
+			err := vm.push(nativeBoolToBooleanObject(ok && len(array.Elements) == length))
+			if err != nil {
+				return err
+			}
+		case code.OpMatchHash:
+			_, ok := vm.pop().(*object.Hash)
+			err := vm.push(nativeBoolToBooleanObject(ok))
+			if err != nil {
+				return err
+			}
+		case code.OpMatchKey:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			hash := vm.pop().(*object.Hash)
+			key := vm.constants[constIndex].(object.Hashable)
+			_, ok := hash.Pairs[key.HashKey()]
+			err := vm.push(nativeBoolToBooleanObject(ok))
+			if err != nil {
+				return err
+			}
+		case code.OpMatchValue:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			value := vm.pop()
+			err := vm.push(nativeBoolToBooleanObject(object.Equal(vm.constants[constIndex], value)))
+			if err != nil {
+				return err
+			}
+		case code.OpNoMatch:
+			value := vm.pop()
+			return fmt.Errorf("no match arm matched value: %s", value.Inspect())
+		case code.OpDestructureArray:
+			length := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			if err := object.CheckArrayPattern(vm.pop(), length); err != nil {
+				return fmt.Errorf("%s", err.Message)
+			}
+		case code.OpDestructureHash:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			elements := vm.constants[constIndex].(*object.Array).Elements
+			keys := make([]string, len(elements))
+			for i, key := range elements {
+				keys[i] = key.(*object.String).Value
+			}
+			if err := object.CheckHashPattern(vm.pop(), keys); err != nil {
+				return fmt.Errorf("%s", err.Message)
+			}
+		case code.OpImport:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			globalIndex := int(code.ReadUint16(ins[ip+3:]))
+			vm.currentFrame().ip += 4
+
+			if exports := vm.globals[globalIndex]; exports != nil {
+				err := vm.push(exports)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			err := vm.pushClosure(int(constIndex), 0)
+			if err != nil {
+				return err
+			}
+			err = vm.executeCall(0)
+			if err != nil {
+				return err
+			}
+			vm.currentFrame().exportsGlobal = globalIndex
 		case code.OpCall:
 			numArguments := int(code.ReadUint8(ins[ip+1:]))
 			vm.currentFrame().ip += 1
 
-			err := vm.executeCall(numArguments)
+			numArguments, err := vm.expandSpreads(numArguments)
+			if err != nil {
+				return err
+			}
+			err = vm.executeCall(numArguments)
+			if err != nil {
+				return err
+			}
+		case code.OpMethodCall:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			numArguments := int(code.ReadUint8(ins[ip+3:]))
+			vm.currentFrame().ip += 3
+
+			name := vm.constants[constIndex].(*object.String)
+			err := vm.executeMethodCall(name.Value, numArguments)
 			if err != nil {
 				return err
 			}
@@ -241,6 +429,9 @@ func (vm *VM) Run() error {
 
 			frame := vm.popFrame()
 			vm.sp = frame.basePointer - 1
+			if frame.exportsGlobal >= 0 {
+				vm.globals[frame.exportsGlobal] = returnValue
+			}
 
 			err := vm.push(returnValue)
 			if err != nil {
@@ -263,7 +454,19 @@ func isTruthy(obj object.Object) bool {
 	}
 }
 
+func isCallable(obj object.Object) bool {
+	switch obj.(type) {
+	case *object.Closure, *object.Builtin:
+		return true
+	default:
+		return false
+	}
+}
+
 func (vm *VM) executeCall(numArgs int) error {
+	if err := vm.ctx.Err(); err != nil {
+		return err
+	}
 	callee := vm.stack[vm.sp-1-numArgs]
 	switch callee := callee.(type) {
 	case *object.Closure:
@@ -275,21 +478,115 @@ func (vm *VM) executeCall(numArgs int) error {
 	}
 }
 
+// executeMethodCall replaces the receiver below the arguments with the
+// function the method call resolves to and calls it.
+func (vm *VM) executeMethodCall(name string, numArgs int) error {
+	numArgs, err := vm.expandSpreads(numArgs)
+	if err != nil {
+		return err
+	}
+	receiverIndex := vm.sp - 1 - numArgs
+	receiver := vm.stack[receiverIndex]
+	args := make([]object.Object, numArgs)
+	copy(args, vm.stack[receiverIndex+1:vm.sp])
+
+	fn, args, methodErr := object.ResolveMethod(receiver, name, args)
+	if methodErr != nil {
+		return fmt.Errorf("%s", methodErr.Message)
+	}
+	vm.sp = receiverIndex
+	if err := vm.push(fn); err != nil {
+		return err
+	}
+	for _, arg := range args {
+		if err := vm.push(arg); err != nil {
+			return err
+		}
+	}
+	return vm.executeCall(len(args))
+}
+
+// SetContext sets the context the builtins called by the program see.
+func (vm *VM) SetContext(ctx *object.Context) {
+	vm.ctx = ctx
+}
+
+// Context returns the context set by SetContext, or nil.
+func (vm *VM) Context() *object.Context {
+	return vm.ctx
+}
+
+// Recover handles the failure of the last Call, which is then not reported
+// by the OpCall that called the builtin.
+func (vm *VM) Recover() {
+	vm.callErr = nil
+}
+
+// Call calls fn with args from a builtin, running the VM until fn returns.
+// When the call fails, the error is reported by the OpCall that called the
+// builtin.
+func (vm *VM) Call(fn object.Object, args ...object.Object) object.Object {
+	frame, sp := vm.framesIndex, vm.sp
+	err := vm.push(fn)
+	for _, arg := range args {
+		if err != nil {
+			break
+		}
+		err = vm.push(arg)
+	}
+	if err == nil {
+		err = vm.executeCall(len(args))
+	}
+	if err == nil && vm.framesIndex > frame {
+		err = vm.run(frame)
+	}
+	if err != nil {
+		vm.framesIndex, vm.sp = frame, sp
+		vm.callErr = err
+		return &object.Error{Message: err.Error(), Err: err}
+	}
+	return vm.pop()
+}
+
 func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
-	if numArgs != cl.Fn.NumParameters {
-		return fmt.Errorf("wrong number of arguments: want=%d, got=%d",
-			cl.Fn.NumParameters, numArgs)
+	fn := cl.Fn
+	required := fn.NumParameters - fn.NumDefaults
+	if err := object.CheckArity(required, fn.NumDefaults, fn.Variadic, numArgs); err != nil {
+		return fmt.Errorf("%s", err.Message)
 	}
 	frame := NewFrame(cl, vm.sp-numArgs)
-	vm.pushFrame(frame)
+	if frame.basePointer+fn.NumLocals >= StackSize {
+		return fmt.Errorf("stack overflow")
+	}
+	frame.numArgs = numArgs
+	if fn.Variadic {
+		rest := []object.Object{}
+		if numArgs > fn.NumParameters {
+			rest = append(rest, vm.stack[frame.basePointer+fn.NumParameters:vm.sp]...)
+			frame.numArgs = fn.NumParameters
+		}
+		vm.stack[frame.basePointer+fn.NumParameters] = &object.Array{Elements: rest}
+	}
+	if err := vm.pushFrame(frame); err != nil {
+		return err
+	}
 
 	vm.sp = frame.basePointer + cl.Fn.NumLocals
 	return nil
 }
 
 func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
-	args := vm.stack[vm.sp-numArgs : vm.sp]
-	result := builtin.Fn(args...)
+	args := make([]object.Object, numArgs)
+	copy(args, vm.stack[vm.sp-numArgs:vm.sp])
+	result := builtin.Fn(vm, args...)
+	if err := vm.callErr; err != nil {
+		vm.callErr = nil
+		return err
+	}
+	// a builtin stopped waiting because the program is canceled
+	if err, ok := result.(*object.Error); ok && err.Canceled() {
+		return err.Err
+	}
 
 	vm.sp = vm.sp - numArgs - 1
 
@@ -301,6 +598,41 @@ func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
 	return nil
 }
 
+// spread is an array marked by OpSpread to be expanded into its elements.
+type spread struct {
+	elements []object.Object
+}
+
+func (s *spread) Type() object.ObjectType { return object.ARRAY }
+func (s *spread) Inspect() string {
+	return "..." + (&object.Array{Elements: s.elements}).Inspect()
+}
+
+// expandSpreads replaces the spread arrays among the n values on top of the
+// stack with their elements and returns the resulting number of values.
+func (vm *VM) expandSpreads(n int) (int, error) {
+	start := vm.sp - n
+	expanded := []object.Object{}
+	found := false
+	for _, v := range vm.stack[start:vm.sp] {
+		if s, ok := v.(*spread); ok {
+			expanded = append(expanded, s.elements...)
+			found = true
+		} else {
+			expanded = append(expanded, v)
+		}
+	}
+	if !found {
+		return n, nil
+	}
+	if start+len(expanded) >= StackSize {
+		return 0, fmt.Errorf("stack overflow")
+	}
+	copy(vm.stack[start:], expanded)
+	vm.sp = start + len(expanded)
+	return len(expanded), nil
+}
+
 func (vm *VM) executeIndexExpression(left, index object.Object) error {
 	switch {
 	case left.Type() == object.ARRAY && index.Type() == object.INTEGER:
@@ -340,12 +672,14 @@ func (vm *VM) executeHashIndex(hash, index object.Object) error {
 func (vm *VM) executeMinusOperator() error {
 	operand := vm.pop()
 
-	if operand.Type() != object.INTEGER {
+	switch operand := operand.(type) {
+	case *object.Integer:
+		return vm.push(&object.Integer{Value: -operand.Value})
+	case *object.Float:
+		return vm.push(&object.Float{Value: -operand.Value})
+	default:
 		return fmt.Errorf("unsupported type for negation: %s", operand.Type())
 	}
-
-	value := operand.(*object.Integer).Value
-	return vm.push(&object.Integer{Value: -value})
 }
 
 func (vm *VM) executeBangOperator() error {
@@ -373,12 +707,15 @@ func (vm *VM) executeComparison(op code.Opcode) error {
 	if leftType == object.INTEGER && rightType == object.INTEGER {
 		return vm.executeIntegerComparison(op, left, right)
 	}
+	if isNumber(left) && isNumber(right) && op == code.OpGreaterThan {
+		return vm.push(nativeBoolToBooleanObject(toFloat(left) > toFloat(right)))
+	}
 
 	switch op {
 	case code.OpEqual:
-		return vm.push(nativeBoolToBooleanObject(right == left))
+		return vm.push(nativeBoolToBooleanObject(object.Equal(left, right)))
 	case code.OpNotEqual:
-		return vm.push(nativeBoolToBooleanObject(right != left))
+		return vm.push(nativeBoolToBooleanObject(!object.Equal(left, right)))
 	default:
 		return fmt.Errorf("unknown operator: %d (%s %s)",
 			op, left.Type(), right.Type())
@@ -417,6 +754,8 @@ func (vm *VM) executeBinaryOperation(op code.Opcode) error {
 	switch {
 	case leftType == object.INTEGER && rightType == object.INTEGER:
 		return vm.executeBinaryIntegerOperation(op, left, right)
+	case isNumber(left) && isNumber(right):
+		return vm.executeBinaryFloatOperation(op, toFloat(left), toFloat(right))
 	case leftType == object.STRING && rightType == object.STRING:
 		return vm.executeBinaryStringOperation(op, left, right)
 	default:
@@ -452,6 +791,9 @@ func (vm *VM) executeBinaryIntegerOperation(op code.Opcode, left, right object.O
 	case code.OpMul:
 		result = leftValue * rightValue
 	case code.OpDiv:
+		if rightValue == 0 {
+			return fmt.Errorf("division by zero: %d / 0", leftValue)
+		}
 		result = leftValue / rightValue
 	default:
 		return fmt.Errorf("unknown integer operator: %d", op)
@@ -462,6 +804,40 @@ func (vm *VM) executeBinaryIntegerOperation(op code.Opcode, left, right object.O
 	})
 }
 
+func isNumber(o object.Object) bool {
+	return o.Type() == object.INTEGER || o.Type() == object.FLOAT
+}
+
+func toFloat(o object.Object) float64 {
+	if i, ok := o.(*object.Integer); ok {
+		return float64(i.Value)
+	}
+	return o.(*object.Float).Value
+}
+
+// executeBinaryFloatOperation executes an operation with a float operand,
+// the other one converted to a float if it is an integer.
+func (vm *VM) executeBinaryFloatOperation(op code.Opcode, leftValue, rightValue float64) error {
+	var result float64
+
+	switch op {
+	case code.OpAdd:
+		result = leftValue + rightValue
+	case code.OpSub:
+		result = leftValue - rightValue
+	case code.OpMul:
+		result = leftValue * rightValue
+	case code.OpDiv:
+		result = leftValue / rightValue
+	default:
+		return fmt.Errorf("unknown float operator: %d", op)
+	}
+
+	return vm.push(&object.Float{
+		Value: result,
+	})
+}
+
 func (vm *VM) buildArray(startIndex, endIndex int) *object.Array {
 	elements := make([]object.Object, endIndex-startIndex)
 	for i := startIndex; i < endIndex; i++ {
@@ -473,8 +849,8 @@ func (vm *VM) buildArray(startIndex, endIndex int) *object.Array {
 	}
 }
 
-func (vm *VM) buildHash(size, startIndex, endIndex int) (*object.Hash, error) {
-	hashedPairs := make(map[object.HashKey]object.HashPair, size)
+func (vm *VM) buildHash(startIndex, endIndex int) (*object.Hash, error) {
+	hash := object.NewHash()
 	for i := startIndex; i < endIndex; i += 2 {
 		key := vm.stack[i]
 		value := vm.stack[i+1]
@@ -485,22 +861,23 @@ func (vm *VM) buildHash(size, startIndex, endIndex int) (*object.Hash, error) {
 		if !ok {
 			return nil, fmt.Errorf("unusable as hash key: %s", key.Type())
 		}
-		hashedPairs[hashKey.HashKey()] = pair
-
+		hash.Set(hashKey.HashKey(), pair)
 	}
 
-	return &object.Hash{
-		Pairs: hashedPairs,
-	}, nil
+	return hash, nil
 }
 
 func (vm *VM) currentFrame() *Frame {
 	return vm.frames[vm.framesIndex-1]
 }
 
-func (vm *VM) pushFrame(f *Frame) {
+func (vm *VM) pushFrame(f *Frame) error {
+	if vm.framesIndex >= MaxFrames {
+		return fmt.Errorf("stack overflow")
+	}
 	vm.frames[vm.framesIndex] = f
 	vm.framesIndex++
+	return nil
 }
 
 func (vm *VM) popFrame() *Frame {
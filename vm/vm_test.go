@@ -1,13 +1,24 @@
 package vm
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/wreulicke/monkey/ast"
 	"github.com/wreulicke/monkey/compiler"
+	"github.com/wreulicke/monkey/interpreter"
 	"github.com/wreulicke/monkey/lexer"
+	"github.com/wreulicke/monkey/module"
 	"github.com/wreulicke/monkey/object"
 	"github.com/wreulicke/monkey/parser"
 )
@@ -28,6 +39,7 @@ func TestSimplePatternMatch(t *testing.T) {
 			`,
 			expected: 5,
 		},
+		{`let [x] = [15, 0]; x`, 15},
 	}
 	runVmTests(t, tests)
 }
@@ -56,6 +68,223 @@ func TestPatternMatchWithFunction(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestMatchExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{`match (1) { 1 => 10, _ => 20 }`, 10},
+		{`match (2) { 1 => 10, _ => 20 }`, 20},
+		{`match (-1) { -1 => 10, _ => 20 }`, 10},
+		{`match ("b") { "a" => 1, "b" => 2 }`, 2},
+		{`match (true) { false => 1, true => 2 }`, 2},
+		{`match (5) { x => x * 2 }`, 10},
+		{`match ([1, 2]) { [x] => x, [x, y] => x + y }`, 3},
+		{`match ([1, [2, 3]]) { [a, [b, c]] => a + b + c }`, 6},
+		{`match ([1, 2]) { [2, x] => x, [1, x] => x * 10 }`, 20},
+		{`match ({"x": 1, "y": 2}) { {z} => z, {x, y} => x + y }`, 3},
+		{`match ({"kind": "sq", "w": 3}) { {kind: "circle", w} => 0, {kind: "sq", w} => w * w }`, 9},
+		{`match (3) { x if x > 5 => 1, x if x > 2 => 2, _ => 3 }`, 2},
+		{`match ([1, 2]) { [x, _] if x > 1 => x, [_, y] => y }`, 2},
+		{`let x = 1; match (2) { x => x }; x`, 1},
+		{`let f = fn(v) { match (v) { [a, b] => a + b, n => n } }; f([1, 2]) + f(3)`, 6},
+		{`let k = 3; let f = fn(v) { match (v) { x if x == k => fn() { x + k } } }; f(3)()`, 6},
+	}
+	runVmTests(t, tests)
+}
+
+func TestMatchExpressionWithoutMatchingArm(t *testing.T) {
+	program := parse(`match ("x") { 1 => 1, [a] => a }`)
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm := New(comp.Bytecode())
+	err = vm.Run()
+	if err == nil {
+		t.Fatalf("expected VM error but resulted in none.")
+	}
+	expected := "no match arm matched value: x"
+	if err.Error() != expected {
+		t.Fatalf("wrong VM error: want=%q, got=%q", expected, err)
+	}
+}
+
+func TestPatternMismatch(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let [x] = 1`, "cannot destructure INTEGER with an array pattern"},
+		{`let {x} = [1]`, "cannot destructure ARRAY with a hash pattern"},
+		{`let f = fn([x]) { x }; f(1)`, "cannot destructure INTEGER with an array pattern"},
+		{`let [a, b] = [1]; b`, "cannot destructure an array of length 1 with an array pattern of length 2"},
+		{`let {a, b} = {"a": 1}; b`, `cannot destructure a hash without the key "b"`},
+		{`let [a, {b}] = [1, 2]; b`, "cannot destructure INTEGER with a hash pattern"},
+		{`let f = fn({a}) { a }; f({})`, `cannot destructure a hash without the key "a"`},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		err := vm.Run()
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong VM error for %q. want=%q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func TestNullAndOptionalChaining(t *testing.T) {
+	tests := []vmTestCase{
+		{"null", Null},
+		{"null == null", true},
+		{"null ?? 1", 1},
+		{"2 ?? 1", 2},
+		{"false ?? 1", false},
+		{`{"a": 1}["b"] ?? 3`, 3},
+		{`{"a": {"b": 2}}["a"]?.["b"]`, 2},
+		{`{"a": {"b": 2}}["x"]?.["b"]`, Null},
+		{`{"a": {"b": 2}}["x"]?.["b"] ?? 5`, 5},
+		{`let f = fn(x) { x * 2 }; f?.(3)`, 6},
+		{`let h = {}; h["f"]?.(3)`, Null},
+		{`let calls = fn() { 1 + true }; null?.(calls())`, Null},
+		{`match (null) { null => 1, _ => 2 }`, 1},
+		{`match ([1, null]) { [_, null] => 1, _ => 2 }`, 1},
+		{`null?.["a"]["b"]`, Null},
+		{`let h = {"a": null}; h.a?.b.c`, Null},
+		{`let h = {"a": null}; h.a?.b.c() ?? 5`, 5},
+		{`let h = {"a": {"b": {"c": 3}}}; h.a?.b.c`, 3},
+		{`let h = {}; h.f?.(1)(2)`, Null},
+		{`null?.x.len()`, Null},
+		{`let calls = fn() { 1 + true }; null?.["a"][calls()]`, Null},
+	}
+	runVmTests(t, tests)
+}
+
+func TestDefaultRestAndSpread(t *testing.T) {
+	tests := []vmTestCase{
+		{`let f = fn(a, b = 10) { a + b }; f(1)`, 11},
+		{`let f = fn(a, b = 10) { a + b }; f(1, 2)`, 3},
+		{`let f = fn(a, b = a * 2) { a + b }; f(3)`, 9},
+		{`let f = fn(a = 1, b = 2) { a * 10 + b }; f()`, 12},
+		{`let f = fn([a, b], c = a + b) { c }; f([1, 2])`, 3},
+		{`let f = fn(...xs) { xs }; f()`, []int{}},
+		{`let f = fn(a, ...xs) { xs }; f(1, 2, 3)`, []int{2, 3}},
+		{`let f = fn(a, b = 5, ...xs) { [a, b, len(xs)] }; f(1)`, []int{1, 5, 0}},
+		{`let f = fn(a, b = 5, ...xs) { [a, b, len(xs)] }; f(1, 2, 3, 4)`, []int{1, 2, 2}},
+		{`let f = fn(a, ...xs) { let y = a; y + len(xs) }; f(1, 2)`, 2},
+		{`let add = fn(a, b) { a + b }; let xs = [1, 2]; add(...xs)`, 3},
+		{`let f = fn(...xs) { xs }; f(0, ...[1, 2], 3, ...[])`, []int{0, 1, 2, 3}},
+		{`let a = [1, 2]; [...a, ...a, 3]`, []int{1, 2, 1, 2, 3}},
+		{`len(...["abc"])`, 3},
+		{`[1, 2].push(...[3])`, []int{1, 2, 3}},
+		{`let outer = fn(x) { fn(y = x) { y } }; outer(4)()`, 4},
+	}
+	runVmTests(t, tests)
+}
+
+func TestMacroExpansion(t *testing.T) {
+	program := parse(`
+	let unless = macro(cond, consequence, alternative) {
+		quote(if (!(unquote(cond))) {
+			unquote(consequence);
+		} else {
+			unquote(alternative);
+		});
+	};
+	unless(10 > 5, 1, 2);
+	`)
+	env := object.NewEnvironment()
+	interpreter.DefineMacros(program, env)
+	expanded, expandErr := interpreter.ExpandMacros(program, env)
+	if expandErr != nil {
+		t.Fatalf("expansion error: %s", expandErr.Message)
+	}
+	comp := compiler.New()
+	err := comp.Compile(expanded)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm := New(comp.Bytecode())
+	err = vm.Run()
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	testExpectedObject(t, 2, vm.LastPoppedStackElem())
+}
+
+func TestPipelineOperator(t *testing.T) {
+	tests := []vmTestCase{
+		{`1 | fn(x) { x }`, 1},
+		{`[1, 2] | fn([x, y]) { x + y }`, 3},
+		{`[1, 2] | len`, 2},
+		{`10 | fn(x, y) { x - y }(3)`, 7},
+		{`10 | fn(x, y) { x - y }(3, _)`, -7},
+		{`let sub = fn(a, b, c) { a - b - c }; 1 | sub(10, _, 2)`, 7},
+		{`[1] | push(2)`, []int{1, 2}},
+		{`3 | math.pow(2, _)`, 8},
+		{`2 | math.pow(3)`, 8},
+		{`"[1, 2]" | json.parse() | len`, 2},
+		{`let inc = fn(x) { x + 1 }; let double = fn(x) { x * 2 }; (inc >> double)(3)`, 8},
+		{`let inc = fn(x) { x + 1 }; let double = fn(x) { x * 2 }; 3 | double >> inc`, 7},
+		{`let inc = fn(x) { x + 1 }; 1 | inc >> inc >> inc`, 4},
+		{`[1, 2] | rest >> first`, 2},
+	}
+	runVmTests(t, tests)
+}
+
+func TestComposeNonFunction(t *testing.T) {
+	program := parse(`len >> 1`)
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm := New(comp.Bytecode())
+	err = vm.Run()
+	if err == nil {
+		t.Fatalf("expected VM error but resulted in none.")
+	}
+	expected := "cannot compose BUILTIN and INTEGER"
+	if err.Error() != expected {
+		t.Fatalf("wrong VM error: want=%q, got=%q", expected, err)
+	}
+}
+
+func TestDotAndMethodCalls(t *testing.T) {
+	tests := []vmTestCase{
+		{`let h = {"name": "monkey"}; h.name`, "monkey"},
+		{`let h = {"a": {"b": 2}}; h.a.b`, 2},
+		{`{}.missing`, Null},
+		{`let h = null; h?.a?.b`, Null},
+		{`"abc".len()`, 3},
+		{`[1, 2].push(3)`, []int{1, 2, 3}},
+		{`[1, 2, 3].rest().first()`, 2},
+		{`let h = {"double": fn(x) { x * 2 }}; h.double(4)`, 8},
+		{`let h = null; h?.len()`, Null},
+	}
+	runVmTests(t, tests)
+}
+
+func TestUndefinedMethod(t *testing.T) {
+	program := parse(`let n = 1; n.len()`)
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm := New(comp.Bytecode())
+	err = vm.Run()
+	if err == nil {
+		t.Fatalf("expected VM error but resulted in none.")
+	}
+	expected := "undefined method len for INTEGER"
+	if err.Error() != expected {
+		t.Fatalf("wrong VM error: want=%q, got=%q", expected, err)
+	}
+}
+
 func TestRecursiveFunctions(t *testing.T) {
 	tests := []vmTestCase{
 		{
@@ -106,6 +335,29 @@ func TestRecursiveFunctions(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestStackOverflow(t *testing.T) {
+	tests := []string{
+		`let x = fn() { x() }; x()`,
+		`let f = fn(n) { f(n + 1) + 1 }; f(0)`,
+		`map([1], fn(v) { let f = fn() { f() }; f() })`,
+	}
+
+	for _, input := range tests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		if err := vm.Run(); err == nil || err.Error() != "stack overflow" {
+			t.Errorf("expected stack overflow for %q, got %v", input, err)
+		}
+	}
+
+	runVmTests(t, []vmTestCase{
+		{`let x = fn() { x() }; try(x).error`, "stack overflow"},
+	})
+}
+
 func TestClosure(t *testing.T) {
 	tests := []vmTestCase{
 		{
@@ -225,10 +477,484 @@ func TestBuiltinFunctions(t *testing.T) {
 				Message: "argument to `push` must be ARRAY, got INTEGER",
 			},
 		},
+		{`len("日本語")`, 3},
+		{`split("a,b,,c", ",")`, []string{"a", "b", "", "c"}},
+		{`join(["a", "b"], "-")`, "a-b"},
+		{`"  a ".trim().upper()`, "A"},
+		{`replace("a-b", "-", "+")`, "a+b"},
+		{`[contains("abc", "b"), startsWith("abc", "b"), "abc".endsWith("c")]`, []bool{true, false, true}},
+		{`indexOf("日本語", "語")`, 2},
+		{`substr("日本語です", 1, 2)`, "本語"},
+		{`repeat("ab", 2)`, "abab"},
+		{`"日本".chars()`, []string{"日", "本"}},
+		{`join(["a", 1], "")`,
+			&object.Error{
+				Message: "argument to `join` must be ARRAY of STRING, got INTEGER in it",
+			},
+		},
 	}
 	runVmTests(t, tests)
 }
 
+func TestCollectionBuiltins(t *testing.T) {
+	tests := []vmTestCase{
+		{`map([1, 2, 3], fn(x) { x * 2 })`, []int{2, 4, 6}},
+		{`let k = 10; [1, 2].map(fn(x) { x + k })`, []int{11, 12}},
+		{`filter(range(10), fn(x) { x / 3 * 3 == x })`, []int{0, 3, 6, 9}},
+		{`reduce([1, 2, 3, 4], fn(acc, x) { acc + x })`, 10},
+		{`reduce([], fn(acc, x) { acc + x }, 5)`, 5},
+		{`find([1, 2, 3], fn(x) { x > 1 })`, 2},
+		{`find([1, 2, 3], fn(x) { x > 5 })`, Null},
+		{`[any([1, 2], fn(x) { x > 1 }), all([1, 2], fn(x) { x > 1 })]`, []bool{true, false}},
+		{`sort([3, 1, 2], fn(a, b) { a > b })`, []int{3, 2, 1}},
+		{`str(sort([2.5, 1.5]))`, "[1.5, 2.5]"},
+		{`str(sort([3, 1.5, 2, -0.5]))`, "[-0.5, 1.5, 2, 3]"},
+		{`zip(range(3), reverse(range(3))) | flatten`, []int{0, 2, 1, 1, 2, 0}},
+		{`uniq([1, 2, 1, 3, 2])`, []int{1, 2, 3}},
+		{`uniq([[{"a": 1}], [{"a": 1}], [{"a": 2}]]).len()`, 2},
+		{`map([[1, 2], [3]], fn(xs) { reduce(xs, fn(a, b) { a + b }) })`, []int{3, 3}},
+		{`map([1, 2], fn(x) { x } >> fn(x) { x * 3 })`, []int{3, 6}},
+		{`map([1], len)`, &object.Error{Message: "argument to `len` not supported, got INTEGER"}},
+		{`reduce(range(5000), fn(acc, x) { acc + x })`, 12497500},
+	}
+	runVmTests(t, tests)
+}
+
+func TestHashBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`keys({"b": 1, "a": 2, 3: 3})`, `[b, a, 3]`},
+		{`{"b": 1, "a": 2}.values()`, `[1, 2]`},
+		{`entries({"b": 1, "a": 2})`, `[[b, 1], [a, 2]]`},
+		{`[has({"a": 1}, "a"), has({"a": 1}, "b")]`, `[true, false]`},
+		{`let h = {"a": 1, "b": 2, "c": 3}; [delete(h, "b"), h]`, `[{a: 1, c: 3}, {a: 1, b: 2, c: 3}]`},
+		{`let h = {"a": 1, "b": 2}; [h.merge({"c": 3, "a": 4}), h]`, `[{a: 4, b: 2, c: 3}, {a: 1, b: 2}]`},
+		{`fromEntries(entries({"x": 1}).map(fn(e) { [e[0], e[1] + 1] }))`, `{x: 2}`},
+		{`has({}, fn() {})`, `ERROR: unusable as hash key: CLOSURE`},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		comp := compiler.New()
+		if err := comp.Compile(program); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		if err := vm.Run(); err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		if got := vm.LastPoppedStackElem().Inspect(); got != tt.expected {
+			t.Errorf("wrong result for %s. want=%s, got=%s", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestJSONBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`json.parse("[1, 1.5, {\"a\": false}]")`, `[1, 1.5, {a: false}]`},
+		{`json.stringify({"b": [1, null], "a": "x"})`, `{"b":[1,null],"a":"x"}`},
+		{`json.stringify({"f": fn() {}})`, `ERROR: json.stringify: cannot encode FUNCTION`},
+		{`json.stringify([fn(x) { fn() { x } }(1)])`, `ERROR: json.stringify: cannot encode FUNCTION`},
+		{`json.parse("")`, `ERROR: json.parse: unexpected end of JSON input`},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		comp := compiler.New()
+		if err := comp.Compile(program); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		if err := vm.Run(); err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		if got := vm.LastPoppedStackElem().Inspect(); got != tt.expected {
+			t.Errorf("wrong result for %s. want=%s, got=%s", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestMathBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`[math.abs(-3), math.min(3, 1.5), math.pow(2, 10), math.sqrt(16)]`, `[3, 1.5, 1024, 4.0]`},
+		{`[math.floor(2.7), math.round(-2.5), math.log10(100)]`, `[2, -3, 2.0]`},
+		{`[math.pow(2, 62), math.pow(-2, 63), math.pow(-1, 9223372036854775807), math.pow(0, 100)]`, `[4611686018427387904, -9223372036854775808, -1, 0]`},
+		{`math.pow(2, 63)`, "ERROR: `math.pow` of 2 and 63 is out of the range of INTEGER"},
+		{`math.pow(2, 64)`, "ERROR: `math.pow` of 2 and 64 is out of the range of INTEGER"},
+		{`math.abs(-9223372036854775807 - 1)`, "ERROR: `math.abs` of -9223372036854775808 is out of the range of INTEGER"},
+		{`range(100).map(fn(_) { math.randInt(2, 4) }).uniq().sort()`, `[2, 3]`},
+		{`math.pow("2", 1)`, "ERROR: argument to `math.pow` must be INTEGER or FLOAT, got STRING"},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		comp := compiler.New()
+		if err := comp.Compile(program); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		if err := vm.Run(); err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		if got := vm.LastPoppedStackElem().Inspect(); got != tt.expected {
+			t.Errorf("wrong result for %s. want=%s, got=%s", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestSeededRandom(t *testing.T) {
+	input := `[math.random(), math.randInt(1000000), [1, 2].map(fn(_) { math.randInt(1000000) })]`
+	run := func() string {
+		comp := compiler.New()
+		if err := comp.Compile(parse(input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		vm.SetContext(&object.Context{Rand: rand.New(rand.NewSource(42))})
+		if err := vm.Run(); err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		return vm.LastPoppedStackElem().Inspect()
+	}
+	first, second := run(), run()
+	if first != second {
+		t.Errorf("same seed gives different numbers: %s and %s", first, second)
+	}
+}
+
+func TestIOBuiltins(t *testing.T) {
+	dir := writeModules(t, map[string]string{"a.txt": "hello\n"})
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`writeFile("DIR/b.txt", "x"); appendFile("DIR/b.txt", "y"); [readFile("DIR/b.txt"), listDir("DIR")]`, "[xy, [a.txt, b.txt]]"},
+		{`[exists("DIR/a.txt"), stat("DIR/a.txt").size]`, "[true, 6]"},
+		{`[readLine(), readLine()]`, "[line, null]"},
+		{`readFile("DIR/none")`, "ERROR: readFile: open DIR/none: no such file or directory"},
+		{`try(fn() { readFile("DIR/none") }).ok`, "false"},
+		{`try(fn(x) { x + true }, 1).error`, "unsupported types for binary operation: INTEGER BOOLEAN"},
+		{`let r = try(fn() { [1].map(fn(x) { x() }) }); [r.ok, r.error, 1 + 1]`, "[false, calling non-function and non-built-in, 2]"},
+		{`try(fn() { 1 }).value`, "1"},
+		{`try(fn() { 1 / 0 }).error`, "division by zero: 1 / 0"},
+	}
+
+	for _, tt := range tests {
+		input := strings.ReplaceAll(tt.input, "DIR", dir)
+		expected := strings.ReplaceAll(tt.expected, "DIR", dir)
+		program := parse(input)
+		comp := compiler.New()
+		if err := comp.Compile(program); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		vm.SetContext(&object.Context{
+			Capabilities: object.CapFileSystem | object.CapStdin,
+			Stdin:        bufio.NewReader(strings.NewReader("line\n")),
+		})
+		if err := vm.Run(); err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		if got := vm.LastPoppedStackElem().Inspect(); got != expected {
+			t.Errorf("wrong result for %s. want=%s, got=%s", input, expected, got)
+		}
+	}
+}
+
+func TestIOBuiltinsWithoutCapability(t *testing.T) {
+	program := parse(`[readFile("a.txt"), readLine()]`)
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm := New(comp.Bytecode())
+	if err := vm.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	expected := "[ERROR: `readFile` is not allowed: the host does not grant access to the file system, " +
+		"ERROR: `readLine` is not allowed: the host does not grant access to the standard input]"
+	if got := vm.LastPoppedStackElem().Inspect(); got != expected {
+		t.Errorf("wrong result. want=%s, got=%s", expected, got)
+	}
+}
+
+func TestRegexBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let re = regex.compile("(?P<k>\\w)=(\\d)"); [re.test("x=1"), re.match("x=1 y=2").named.k]`, `[true, x]`},
+		{`regex.compile("\\d").findAll("a1b2").map(fn(m) { m.index })`, `[1, 3]`},
+		{`regex.compile("\\d+").replaceAll("a1b22", fn(m) { "<" + m.text + ">" })`, `a<1>b<22>`},
+		{`try(regex.compile, "(").error`, "regex.compile: error parsing regexp: missing closing ): `(`"},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		comp := compiler.New()
+		if err := comp.Compile(program); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		if err := vm.Run(); err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		if got := vm.LastPoppedStackElem().Inspect(); got != tt.expected {
+			t.Errorf("wrong result for %s. want=%s, got=%s", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestOutputBuiltins(t *testing.T) {
+	input := `puts("a", 1); print("b", [2]); eprint("e"); printf("%s=%.1f\n", "x", 1); puts(sprintf("%05d", 42))`
+	program := parse(input)
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	var stdout, stderr bytes.Buffer
+	vm := New(comp.Bytecode())
+	vm.SetContext(&object.Context{Stdout: &stdout, Stderr: &stderr})
+	if err := vm.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	if want := "a\n1\nb [2]x=1.0\n00042\n"; stdout.String() != want {
+		t.Errorf("wrong stdout. want=%q, got=%q", want, stdout.String())
+	}
+	if stderr.String() != "e" {
+		t.Errorf("wrong stderr. want=%q, got=%q", "e", stderr.String())
+	}
+}
+
+func TestTypeBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`[type(fn() {}), type(len), type(1.5), type(null)]`, `[FUNCTION, BUILTIN, FLOAT, NULL]`},
+		{`let f = fn(x) { fn() { x } }; [type(f(1)), isFunction(f(1)), isFunction(1)]`, `[FUNCTION, true, false]`},
+		{`[int("7") + 1, float(1) / 2, str(12) + "!", bool(null)]`, `[8, 0.5, 12!, false]`},
+		{`int(fn() {})`, `ERROR: int: cannot convert FUNCTION to INTEGER`},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		comp := compiler.New()
+		if err := comp.Compile(program); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		if err := vm.Run(); err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		if got := vm.LastPoppedStackElem().Inspect(); got != tt.expected {
+			t.Errorf("wrong result for %s. want=%s, got=%s", tt.input, tt.expected, got)
+		}
+	}
+}
+
+// fakeClock starts at a fixed time and advances only when the program
+// sleeps.
+type fakeClock struct {
+	now   time.Time
+	start time.Time
+}
+
+func (c *fakeClock) Now() time.Time           { return c.now }
+func (c *fakeClock) Monotonic() time.Duration { return c.now.Sub(c.start) }
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.now = c.now.Add(d)
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+func TestTimeBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let t = now(); sleep(1500); [formatTime(t), now() - t]`, "[2024-02-29T12:00:00Z, 1500]"},
+		{`let start = monotonic(); [1, 2].map(fn(x) { sleep(x) }); monotonic() - start`, "3.0"},
+		{`formatTime(parseTime("2024-03-01", "2006-01-02") + duration("90m"), "15:04")`, "01:30"},
+		{`duration("x")`, `ERROR: duration: time: invalid duration "x"`},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		comp := compiler.New()
+		if err := comp.Compile(program); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		start := time.Date(2024, 2, 29, 12, 0, 0, 0, time.UTC)
+		vm.SetContext(&object.Context{Clock: &fakeClock{now: start, start: start}})
+		if err := vm.Run(); err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		if got := vm.LastPoppedStackElem().Inspect(); got != tt.expected {
+			t.Errorf("wrong result for %s. want=%s, got=%s", tt.input, tt.expected, got)
+		}
+	}
+}
+
+// stuckClock never wakes the program up, and cancels it when it sleeps.
+type stuckClock struct {
+	*fakeClock
+	cancel context.CancelFunc
+}
+
+func (c stuckClock) After(d time.Duration) <-chan time.Time {
+	c.cancel()
+	return nil
+}
+
+func TestSleepCanceled(t *testing.T) {
+	tests := []string{
+		`sleep(1000)`,
+		`sleep(1000); 1`,
+		`try(fn() { sleep(1000) })`,
+		`[1, 2].map(fn(x) { sleep(x) })`,
+	}
+
+	for _, input := range tests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		start := time.Date(2024, 2, 29, 12, 0, 0, 0, time.UTC)
+		vm := New(comp.Bytecode())
+		vm.SetContext(&object.Context{Clock: stuckClock{&fakeClock{now: start, start: start}, cancel}})
+		err := vm.RunContext(ctx)
+		var canceled *object.CanceledError
+		if !errors.As(err, &canceled) || !errors.Is(err, context.Canceled) {
+			t.Errorf("expected %s to be canceled, got %v", input, err)
+		}
+	}
+}
+
+func TestRegistryBuiltins(t *testing.T) {
+	registry := object.NewDefaultRegistry()
+	for i := 0; i < 300; i++ {
+		value := &object.Integer{Value: int64(i)}
+		registry.Register(fmt.Sprintf("b%d", i), object.Arity{}, func(rt object.Runtime, args ...object.Object) object.Object {
+			return value
+		})
+	}
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`b299() + len("ab")`, "301"},
+		{`fn() { [b0(), b256()] }()`, "[0, 256]"},
+		{`b1(2)`, "ERROR: wrong number of arguments: want=0, got=1"},
+		{`let b2 = 5; b2`, "5"},
+	}
+
+	for _, tt := range tests {
+		ctx := &object.Context{Builtins: registry}
+		comp := compiler.New()
+		comp.SetContext(ctx)
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		vm.SetContext(ctx)
+		if err := vm.Run(); err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		if got := vm.LastPoppedStackElem().Inspect(); got != tt.expected {
+			t.Errorf("wrong result for %s. want=%s, got=%s", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestRunContext(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`cancel(); 1`, "1"},
+		{`let f = fn() { 1 }; cancel(); f()`, ""},
+		{`cancel(); [1, 2].map(fn(x) { x })`, ""},
+		{`try(fn() { cancel(); len("a") })`, ""},
+		{`[1, 2].map(fn(x) { try(fn() { cancel(); x }) })`, ""},
+	}
+
+	for _, tt := range tests {
+		ctx, cancel := context.WithCancel(context.Background())
+		registry := object.NewDefaultRegistry()
+		registry.Register("cancel", object.Arity{}, func(rt object.Runtime, args ...object.Object) object.Object {
+			cancel()
+			return nil
+		})
+		objectCtx := &object.Context{Builtins: registry}
+		comp := compiler.New()
+		comp.SetContext(objectCtx)
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		vm.SetContext(objectCtx)
+		err := vm.RunContext(ctx)
+		if tt.expected != "" {
+			if err != nil {
+				t.Fatalf("vm error: %s", err)
+			}
+			if got := vm.LastPoppedStackElem().Inspect(); got != tt.expected {
+				t.Errorf("wrong result for %s. want=%s, got=%s", tt.input, tt.expected, got)
+			}
+			continue
+		}
+		var canceled *object.CanceledError
+		if !errors.As(err, &canceled) || !errors.Is(err, context.Canceled) {
+			t.Errorf("expected %s to be canceled, got %v", tt.input, err)
+		}
+		if vm.Context() != objectCtx {
+			t.Errorf("expected the context of the VM to be restored")
+		}
+	}
+}
+
+func TestCollectionBuiltinCallErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`map([1], fn(x) { x + true })`, "unsupported types for binary operation: INTEGER BOOLEAN"},
+		{`map([1], fn() { 1 })`, "wrong number of arguments: want=0, got=1"},
+		{`map([[1]], fn(x) { map(x, fn(y) { y() }) })`, "calling non-function and non-built-in"},
+		{`map([0], fn(x) { 1 / x })`, "division by zero: 1 / 0"},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		err := vm.Run()
+		if err == nil {
+			t.Errorf("expected VM error for %q", tt.input)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("wrong VM error for %q. want=%q, got=%q", tt.input, tt.expected, err)
+		}
+	}
+}
+
 func TestCallingFunctionsWithWrongArguments(t *testing.T) {
 	tests := []vmTestCase{
 		{
@@ -243,6 +969,22 @@ func TestCallingFunctionsWithWrongArguments(t *testing.T) {
 			input:    `fn(a, b) { a + b; }(1);`,
 			expected: `wrong number of arguments: want=2, got=1`,
 		},
+		{
+			input:    `fn(a, b = 1) { a + b; }();`,
+			expected: `wrong number of arguments: want=1 to 2, got=0`,
+		},
+		{
+			input:    `fn(a, b = 1) { a + b; }(1, 2, 3);`,
+			expected: `wrong number of arguments: want=1 to 2, got=3`,
+		},
+		{
+			input:    `fn(a, ...rest) { a; }();`,
+			expected: `wrong number of arguments: want at least 1, got=0`,
+		},
+		{
+			input:    `let f = fn(a) { a }; f(...1);`,
+			expected: `cannot spread INTEGER`,
+		},
 	}
 	for _, tt := range tests {
 		program := parse(tt.input)
@@ -505,6 +1247,24 @@ func TestHashLiterals(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestHashLiteralOrder(t *testing.T) {
+	program := parse(`{"b": 1, "a": 2, 3: 3, "b": 4}`)
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm := New(comp.Bytecode())
+	err = vm.Run()
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	expected := "{b: 4, a: 2, 3: 3}"
+	if inspected := vm.LastPoppedStackElem().Inspect(); inspected != expected {
+		t.Errorf("wrong hash order. want=%q, got=%q", expected, inspected)
+	}
+}
+
 func TestArrayLiterals(t *testing.T) {
 	tests := []vmTestCase{
 		{"[]", []int{}},
@@ -571,6 +1331,20 @@ func TestBooleanExpressions(t *testing.T) {
 		{"(1 < 2) == false", false},
 		{"(1 > 2) == true", false},
 		{"(1 > 2) == false", true},
+		{`"a" == "a"`, true},
+		{`"a" != "b"`, true},
+		{"[1, 2] == [1, 2]", true},
+		{"[1, 2] == [2, 1]", false},
+		{"[1, [2, 3]] == [1, [2, 3]]", true},
+		{"[1] != [1, 2]", true},
+		{`{"a": 1, "b": [2]} == {"b": [2], "a": 1}`, true},
+		{`{"a": 1} == {"a": 2}`, false},
+		{`{"a": 1} == {"b": 1}`, false},
+		{`[1] == {"a": 1}`, false},
+		{`1 == "1"`, false},
+		{`{[1, 2]: 3}[[1, 2]] == 3`, true},
+		{`let f = fn() { 1 }; f == f`, true},
+		{`fn() { 1 } == fn() { 1 }`, false},
 
 		{"!true", false},
 		{"!false", true},
@@ -608,6 +1382,37 @@ func TestIntegerArithmetic(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestFloatArithmetic(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1.5", "1.5"},
+		{"-2.5", "-2.5"},
+		{"1e3", "1000.0"},
+		{"1.5 + 2", "3.5"},
+		{"3 / 2.0", "1.5"},
+		{"2 * 0.25 - 1", "-0.5"},
+		{"[1 < 1.5, 2.5 > 3, 1.5 == 1.5, 1 == 1.0]", "[true, false, true, false]"},
+		{"match (2.5) { 2.5 => 1, _ => 2 }", "1"},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		comp := compiler.New()
+		if err := comp.Compile(program); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		if err := vm.Run(); err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		if got := vm.LastPoppedStackElem().Inspect(); got != tt.expected {
+			t.Errorf("wrong result for %s. want=%s, got=%s", tt.input, tt.expected, got)
+		}
+	}
+}
+
 func parse(input string) *ast.Program {
 	l := lexer.New(bytes.NewBufferString(input))
 	p := parser.New(l)
@@ -707,6 +1512,38 @@ func testExpectedObject(
 		if err != nil {
 			t.Errorf("testStringObject failed: %s", err)
 		}
+	case []string:
+		array, ok := actual.(*object.Array)
+		if !ok {
+			t.Errorf("object not Array: %T (%+v)", actual, actual)
+			return
+		}
+		if len(array.Elements) != len(expected) {
+			t.Errorf("wrong num of elements. want=%d, got=%d", len(expected), len(array.Elements))
+			return
+		}
+		for i, expectedElem := range expected {
+			err := testStringObject(expectedElem, array.Elements[i])
+			if err != nil {
+				t.Errorf("testStringObject failed: %s", err)
+			}
+		}
+	case []bool:
+		array, ok := actual.(*object.Array)
+		if !ok {
+			t.Errorf("object not Array: %T (%+v)", actual, actual)
+			return
+		}
+		if len(array.Elements) != len(expected) {
+			t.Errorf("wrong num of elements. want=%d, got=%d", len(expected), len(array.Elements))
+			return
+		}
+		for i, expectedElem := range expected {
+			err := testBooleanObject(expectedElem, array.Elements[i])
+			if err != nil {
+				t.Errorf("testBooleanObject failed: %s", err)
+			}
+		}
 	case bool:
 		err := testBooleanObject(bool(expected), actual)
 		if err != nil {
@@ -762,3 +1599,122 @@ func testStringObject(expected string, actual object.Object) error {
 	}
 	return nil
 }
+
+func TestImport(t *testing.T) {
+	dir := writeModules(t, map[string]string{
+		"util.mk": `
+			let secret = 40;
+			export let add = fn(a, b) { a + b };
+			export let answer = add(secret, 2);
+			export let [first, {second}] = [1, {"second": 2}];
+		`,
+		"nested/a.mk":   `export let value = (import "../util").answer + 1;`,
+		"lib/search.mk": `export let value = "found";`,
+	})
+
+	tests := []vmTestCase{
+		{`let u = import "./util"; u.add(u.answer, 1)`, 43},
+		{`let u = import "./util.mk"; u.first + u.second`, 3},
+		{`let u = import "./util"; u.secret`, Null},
+		{`let secret = 1; let u = import "./util"; secret + u.answer`, 43},
+		{`(import "./nested/a").value`, 43},
+		{`let f = fn() { import "search" }; f().value`, "found"},
+		{`let f = fn() { import "./util" }; [f().first, f().second, (import "./util").answer]`, []int{1, 2, 42}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			result, err := runFile(tt.input, filepath.Join(dir, "main.mk"), filepath.Join(dir, "lib"))
+			if err != nil {
+				t.Fatalf("error: %s", err)
+			}
+			testExpectedObject(t, tt.expected, result)
+		})
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	dir := writeModules(t, map[string]string{
+		"cycle/a.mk": `import "./b";`,
+		"cycle/b.mk": `import "./a";`,
+		"fail.mk":    `let x = 1 + true;`,
+		"inner.mk":   `if (true) { export let x = 1; }`,
+	})
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`import "./cycle/a"`, "import cycle: " + filepath.Join(dir, "cycle/a.mk") + " -> " + filepath.Join(dir, "cycle/b.mk") + " -> " + filepath.Join(dir, "cycle/a.mk")},
+		{`import "./missing"`, `cannot find module "./missing.mk"`},
+		{`import "./fail"`, "unsupported types for binary operation: INTEGER BOOLEAN"},
+		{`import "./inner"`, "export is only allowed at the top level"},
+		{`fn() { export let x = 1; }()`, "export is only allowed at the top level"},
+	}
+
+	for _, tt := range tests {
+		_, err := runFile(tt.input, filepath.Join(dir, "main.mk"))
+		if err == nil {
+			t.Errorf("expected error for %q", tt.input)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("wrong error for %q. want=%q, got=%q", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func TestImportRunsModulesOnce(t *testing.T) {
+	dir := writeModules(t, map[string]string{
+		"m.mk":   `export let f = fn() { 1 };`,
+		"a.mk":   `export let m = import "./m";`,
+		"lib.mk": `export let m = import "./m";`,
+	})
+	result, err := runFile(`[import "./m", (import "./a").m, (import "lib").m, fn() { import "./m" }()]`, filepath.Join(dir, "main.mk"), dir)
+	if err != nil {
+		t.Fatalf("error: %s", err)
+	}
+	array, ok := result.(*object.Array)
+	if !ok {
+		t.Fatalf("object is not Array. got=%T (%+v)", result, result)
+	}
+	for _, e := range array.Elements[1:] {
+		if e != array.Elements[0] {
+			t.Errorf("module is run more than once. got=%s, want=%s", e.Inspect(), array.Elements[0].Inspect())
+		}
+	}
+}
+
+func writeModules(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "monkey-vm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	for name, src := range files {
+		file := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// runFile runs input as if it was read from file and returns the last
+// popped value.
+func runFile(input, file string, path ...string) (object.Object, error) {
+	comp := compiler.New()
+	comp.SetContext(&object.Context{File: file, Modules: module.NewLoader(path...)})
+	if err := comp.Compile(parse(input)); err != nil {
+		return nil, err
+	}
+	vm := New(comp.Bytecode())
+	if err := vm.Run(); err != nil {
+		return nil, err
+	}
+	return vm.LastPoppedStackElem(), nil
+}